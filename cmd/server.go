@@ -22,20 +22,129 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/telepair/telepair/core/proxy/api"
 )
 
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Start the server",
-	Run: func(_ *cobra.Command, _ []string) {
-		fmt.Println("server called")
+	Long: `Start the server with the API gateway.
+
+The gateway executes the registered APIs and templates:
+  POST /apis/{name}       execute the API
+  POST /templates/{name}  render the template with the vars in the query or json body and execute it
+
+The management routes of the APIs and templates are served with --management, the requests
+must carry the token as "Authorization: Bearer <token>". The token is read from --management-token
+or the TELEPAIR_MANAGEMENT_TOKEN environment variable.
+
+Examples:
+  # Serve the templates in ./configs/apis.yaml
+  ./telepair server
+
+  # Serve on a custom address with an API file
+  ./telepair server --addr :9090 --apis ./apis.yaml -t ./templates.yaml
+
+  # Serve the management routes
+  TELEPAIR_MANAGEMENT_TOKEN=my-token ./telepair server --management
+
+  # Serve the templates in a directory and reload them on changes
+  ./telepair server -t ./configs --watch
+
+  # Call a template
+  curl -X POST localhost:8080/templates/weather -d '{"city": "beijing", "lang": "zh"}'
+`,
+	Run: func(cmd *cobra.Command, _ []string) {
+		addr, _ := cmd.Flags().GetString("addr")
+		apis, _ := cmd.Flags().GetString("apis")
+		templates, _ := cmd.Flags().GetStringSlice("templates")
+		watch, _ := cmd.Flags().GetBool("watch")
+		interval, _ := cmd.Flags().GetDuration("reload-interval")
+		management, _ := cmd.Flags().GetBool("management")
+		token, _ := cmd.Flags().GetString("management-token")
+		if token == "" {
+			token = os.Getenv("TELEPAIR_MANAGEMENT_TOKEN")
+		}
+		if management && token == "" {
+			log.Fatalf("The management token is required with --management")
+		}
+		if !management {
+			token = ""
+		}
+		if apis != "" {
+			if err := registerFile(apis, api.RegisterAPIData); err != nil {
+				log.Fatalf("Failed to register apis: %v", err)
+			}
+		}
+		var loader *api.TemplateLoader
+		if len(templates) > 0 {
+			loader = api.NewTemplateLoader(templates, api.WithReloadInterval(interval))
+			if _, err := loader.Load(); err != nil {
+				log.Fatalf("Failed to register templates: %v", err)
+			}
+		}
+
+		server := &http.Server{
+			Addr:              addr,
+			Handler:           api.NewGateway(api.WithGatewayManagement(token)),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if watch && loader != nil {
+			go loader.Watch(ctx)
+		}
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = server.Shutdown(shutdownCtx)
+		}()
+
+		slog.Info("server started", "addr", addr, "management", management)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		slog.Info("server stopped")
 	},
 }
 
+// registerFile registers the yaml or json file with the register function
+func registerFile(path string, register func(dataType string, data []byte) error) error {
+	fileType := strings.TrimPrefix(filepath.Ext(path), ".")
+	if fileType != "yaml" && fileType != "yml" && fileType != "json" {
+		return fmt.Errorf("unsupported file type: %s", path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file (%s): %w", path, err)
+	}
+	return register(fileType, data)
+}
+
 func init() {
 	rootCmd.AddCommand(serverCmd)
+	serverCmd.Flags().String("addr", "127.0.0.1:8080", "Address of the API gateway")
+	serverCmd.Flags().String("apis", "", "API file, yaml or json")
+	serverCmd.Flags().StringSliceP("templates", "t", []string{"./configs/apis.yaml"}, "API template files or directories, yaml or json")
+	serverCmd.Flags().Bool("watch", false, "Reload the templates when the files change")
+	serverCmd.Flags().Duration("reload-interval", api.DefaultReloadInterval, "Interval to check the template files for changes")
+	serverCmd.Flags().Bool("management", false, "Serve the management routes of the APIs and templates")
+	serverCmd.Flags().String("management-token", "", "Bearer token of the management routes, TELEPAIR_MANAGEMENT_TOKEN by default")
 }
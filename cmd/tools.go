@@ -37,4 +37,6 @@ func init() {
 	rootCmd.AddCommand(toolsCmd)
 	toolsCmd.AddCommand(tools.APICmd)
 	toolsCmd.AddCommand(tools.APITemplateCmd)
+	toolsCmd.AddCommand(tools.APIWorkflowCmd)
+	toolsCmd.AddCommand(tools.APIImportCmd)
 }
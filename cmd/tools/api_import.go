@@ -0,0 +1,77 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+
+	"github.com/telepair/telepair/core/proxy/api"
+)
+
+// APIImportCmd represents the api import command
+var APIImportCmd = &cobra.Command{
+	Use:   "api-import",
+	Short: "Import API templates",
+}
+
+// apiImportOpenAPICmd represents the api import openapi command
+var apiImportOpenAPICmd = &cobra.Command{
+	Use:   "openapi [spec]",
+	Short: "Import API templates from an OpenAPI 3 spec",
+	Long: `Import API templates from an OpenAPI 3 spec, yaml or json.
+
+Every operation becomes a template named by its operationId. Path, query and header
+parameters and the properties of JSON request bodies become template variables.
+
+Examples:
+  # Print the templates
+  ./telepair tools api-import openapi ./petstore.yaml
+
+  # Write the templates with a name prefix and a custom server
+  ./telepair tools api-import openapi ./petstore.yaml -o ./configs/petstore.yaml --prefix pet. --base-url http://localhost:8080
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		baseURL, _ := cmd.Flags().GetString("base-url")
+		prefix, _ := cmd.Flags().GetString("prefix")
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			log.Fatalf("Failed to read spec file (%s): %v", args[0], err)
+		}
+		templates, err := api.ImportOpenAPI(data, api.WithOpenAPIBaseURL(baseURL), api.WithOpenAPIPrefix(prefix))
+		if err != nil {
+			log.Fatalf("Failed to import spec: %v", err)
+		}
+
+		if strings.EqualFold(filepath.Ext(output), ".json") {
+			data, err = json.MarshalIndent(templates, "", "  ")
+		} else {
+			data, err = yaml.Marshal(templates)
+		}
+		if err != nil {
+			log.Fatalf("Failed to marshal templates: %v", err)
+		}
+		if output == "" {
+			fmt.Print(string(data))
+			return
+		}
+		if err := os.WriteFile(output, data, 0o600); err != nil {
+			log.Fatalf("Failed to write templates (%s): %v", output, err)
+		}
+		fmt.Printf("Imported %d templates to %s\n", len(templates), output)
+	},
+}
+
+func init() {
+	APIImportCmd.AddCommand(apiImportOpenAPICmd)
+	apiImportOpenAPICmd.Flags().StringP("output", "o", "", "Output template file, yaml or json, print to stdout if empty")
+	apiImportOpenAPICmd.Flags().String("base-url", "", "Base URL of the APIs, overrides the servers of the spec")
+	apiImportOpenAPICmd.Flags().String("prefix", "", "Prefix of the template names")
+}
@@ -1,16 +1,12 @@
 package tools
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/telepair/telepair/core/proxy/api"
-	"github.com/telepair/telepair/pkg/httpclient"
+	"github.com/telepair/telepair/pkg/httpclient/fallback"
 )
 
 // APITemplateCmd represents the api template command
@@ -28,39 +24,48 @@ Examples:
 
   # Request with variables
   ./telepair tools api-template weather -v '{"city": "beijing", "lang": "zh"}'
+
+  # Request through a proxy, it overrides the proxy of the template
+  ./telepair tools api-template eip --proxy http://proxy.corp:3128 --no-proxy .corp
 `,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		name := args[0]
 		template, _ := cmd.Flags().GetString("template")
-		fileType := filepath.Ext(template)
-		if fileType != ".yaml" && fileType != ".yml" && fileType != ".json" {
-			log.Fatalf("Unsupported file type: %s", fileType)
-		}
-		fileType = strings.TrimPrefix(fileType, ".")
-		data, err := os.ReadFile(template)
+		fileType, data, err := readDataFile(template)
 		if err != nil {
 			log.Fatalf("Failed to read template file (%s): %v", template, err)
 		}
-		values, _ := cmd.Flags().GetString("values")
-		v := make(map[string]string)
-		if values != "" {
-			if err := json.Unmarshal([]byte(values), &v); err != nil {
-				log.Fatalf("Failed to parse values: %v", err)
-			}
-		}
+		v := valuesFromFlags(cmd)
 		fmt.Printf("Running API template with name <%s> template <%s>\n", name, template)
 
 		if err := api.RegisterAPITemplateData(fileType, data); err != nil {
 			log.Fatalf("Failed to register template: %v", err)
 		}
-		resp, err := api.DoTemplate(name, v)
-		if err != nil {
-			log.Fatalf("Failed to do template: %v", err)
+		var opts []api.Option
+		if proxy := newProxyFromFlags(cmd); proxy != nil {
+			if _, err := proxy.ProxyFunc(); err != nil {
+				log.Fatalf("Invalid proxy: %v", err)
+			}
+			opts = append(opts, api.WithProxy(proxy))
+		}
+		if cmd.Flags().Changed("fail-fast") {
+			failFast, _ := cmd.Flags().GetBool("fail-fast")
+			opts = append(opts, api.WithRateLimitFailFast(failFast))
+		}
+		var attempts []fallback.Attempt
+		opts = append(opts, api.WithAttemptObserver(func(a fallback.Attempt) {
+			attempts = append(attempts, a)
+		}))
+		mediaType, body, err := api.DoTemplateParsed(name, v, opts...)
+		if len(attempts) > 0 {
+			fmt.Println("Attempts:")
+			for i, a := range attempts {
+				fmt.Printf("\t%d. %s\n", i+1, a)
+			}
 		}
-		mediaType, body, err := httpclient.ParseResponse(resp)
 		if err != nil {
-			log.Fatalf("Failed to parse response: %v", err)
+			log.Fatalf("Failed to do template: %v", err)
 		}
 		fmt.Printf("Response: \n\tContent-Type: %s\n\tBody: \n", mediaType)
 		fmt.Println("--------------------------------")
@@ -72,4 +77,7 @@ Examples:
 func init() {
 	APITemplateCmd.Flags().StringP("template", "t", "./configs/apis.yaml", "Template file, yaml or json")
 	APITemplateCmd.Flags().StringP("values", "v", "", "Values for the template, json format")
+	APITemplateCmd.Flags().String("proxy", "", "Proxy URL, http, https, socks5 or socks5h, it overrides the proxy of the template")
+	APITemplateCmd.Flags().StringSlice("no-proxy", []string{}, "Hosts connected without the proxy, e.g. *.internal or 10.0.0.0/8")
+	APITemplateCmd.Flags().Bool("fail-fast", false, "Fail when the rate limit of the template is exceeded instead of waiting, it overrides the template")
 }
@@ -0,0 +1,108 @@
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/telepair/telepair/core/proxy/api"
+)
+
+// APIWorkflowCmd represents the api workflow command
+var APIWorkflowCmd = &cobra.Command{
+	Use:   "api-workflow [name]",
+	Short: "API proxy workflow",
+	Long: `API proxy workflow tool running the chained API template calls of a workflow.
+
+Examples:
+  # Run a workflow
+  ./telepair tools api-workflow local-weather
+
+  # Use custom workflow and template files
+  ./telepair tools api-workflow user-orders -w ./configs/workflows/workflows.yaml -t ./configs/apis.yaml
+
+  # Run with variables
+  ./telepair tools api-workflow user-orders -v '{"user": "alice"}'
+`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := args[0]
+		template, _ := cmd.Flags().GetString("template")
+		workflow, _ := cmd.Flags().GetString("workflow")
+		fileType, data, err := readDataFile(template)
+		if err != nil {
+			log.Fatalf("Failed to read template file (%s): %v", template, err)
+		}
+		if err := api.RegisterAPITemplateData(fileType, data); err != nil {
+			log.Fatalf("Failed to register template: %v", err)
+		}
+		fileType, data, err = readDataFile(workflow)
+		if err != nil {
+			log.Fatalf("Failed to read workflow file (%s): %v", workflow, err)
+		}
+		if err := api.RegisterWorkflowData(fileType, data); err != nil {
+			log.Fatalf("Failed to register workflow: %v", err)
+		}
+		fmt.Printf("Running API workflow with name <%s> workflow <%s> template <%s>\n", name, workflow, template)
+
+		var opts []api.Option
+		if proxy := newProxyFromFlags(cmd); proxy != nil {
+			if _, err := proxy.ProxyFunc(); err != nil {
+				log.Fatalf("Invalid proxy: %v", err)
+			}
+			opts = append(opts, api.WithProxy(proxy))
+		}
+		result, err := api.RunWorkflow(name, valuesFromFlags(cmd), opts...)
+		if result != nil {
+			fmt.Println("Steps:")
+			for i, step := range result.Steps {
+				fmt.Printf("\t%d. %s %s, %d attempt(s) in %s\n", i+1, step.Name, step.Status, step.Attempts, step.Duration)
+				if step.Err != nil {
+					fmt.Printf("\t   error: %v\n", step.Err)
+				}
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to run workflow: %v", err)
+		}
+		fmt.Println("Output:")
+		fmt.Println("--------------------------------")
+		fmt.Println(string(result.Output))
+		fmt.Println("--------------------------------")
+	},
+}
+
+// readDataFile reads the yaml or json file and returns its data type
+func readDataFile(path string) (string, []byte, error) {
+	ext := filepath.Ext(path)
+	if ext != ".yaml" && ext != ".yml" && ext != ".json" {
+		return "", nil, errors.New("unsupported file type: " + ext)
+	}
+	data, err := os.ReadFile(path)
+	return strings.TrimPrefix(ext, "."), data, err
+}
+
+// valuesFromFlags returns the template variables of the values flag
+func valuesFromFlags(cmd *cobra.Command) map[string]string {
+	values, _ := cmd.Flags().GetString("values")
+	v := make(map[string]string)
+	if values != "" {
+		if err := json.Unmarshal([]byte(values), &v); err != nil {
+			log.Fatalf("Failed to parse values: %v", err)
+		}
+	}
+	return v
+}
+
+func init() {
+	APIWorkflowCmd.Flags().StringP("workflow", "w", "./configs/workflows/workflows.yaml", "Workflow file, yaml or json")
+	APIWorkflowCmd.Flags().StringP("template", "t", "./configs/apis.yaml", "Template file, yaml or json")
+	APIWorkflowCmd.Flags().StringP("values", "v", "", "Values for the workflow, json format")
+	APIWorkflowCmd.Flags().String("proxy", "", "Proxy URL, http, https, socks5 or socks5h, it overrides the proxy of the templates")
+	APIWorkflowCmd.Flags().StringSlice("no-proxy", []string{}, "Hosts connected without the proxy, e.g. *.internal or 10.0.0.0/8")
+}
@@ -1,15 +1,16 @@
 package api
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/telepair/telepair/pkg/cache"
 	"github.com/telepair/telepair/pkg/httpclient"
 	"github.com/telepair/telepair/pkg/httpclient/fallback"
 )
@@ -28,7 +29,14 @@ type API struct {
 	URLs    []string          `yaml:"urls" json:"urls"`
 	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
 	Body    string            `yaml:"body,omitempty" json:"body,omitempty"`
-	Config  Config            `yaml:"config,omitempty" json:"config,omitempty"`
+	// BodySource is a body not inlined, a file, a form or a multipart form, it excludes the Body
+	BodySource *BodySource `yaml:"body_source,omitempty" json:"body_source,omitempty"`
+	Config     Config      `yaml:"config,omitempty" json:"config,omitempty"`
+
+	// secrets are the secret values rendered into the API, masked in logs and dumps
+	secrets []string
+	// template is the name of the template the API is rendered from
+	template string
 }
 
 // Parse parses the API
@@ -37,10 +45,19 @@ func (c *API) Parse() error {
 	switch c.Method {
 	case http.MethodOptions, http.MethodGet, http.MethodHead:
 		c.Body = ""
+		c.BodySource = nil
 	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
 	default:
 		return fmt.Errorf("method %s is invalid", c.Method)
 	}
+	if c.BodySource != nil {
+		if c.Body != "" {
+			return errors.New("body and body source are exclusive")
+		}
+		if err := c.BodySource.Parse(); err != nil {
+			return err
+		}
+	}
 
 	c.URL = strings.TrimSpace(c.URL)
 	if c.URL == "" {
@@ -57,41 +74,202 @@ func (c *API) Parse() error {
 	if c.Config.Timeout == 0 {
 		c.Config.Timeout = DefaultTimeout
 	}
+	weights := c.Config.Fallback.Weights
+	for url, weight := range weights {
+		if weight < 0 {
+			return fmt.Errorf("weight %d of url %s is invalid", weight, url)
+		}
+	}
+	switch selector := c.Config.Fallback.Selector; selector {
+	case fallback.SelectStrategyRace, fallback.SelectStrategyHedged:
+		if c.header(httpclient.IdempotencyKeyHeader) == "" && !fallback.IsParallelSafe(c.Method, nil) {
+			return fmt.Errorf("%s selector requires the %s header for the %s method", selector, httpclient.IdempotencyKeyHeader, c.Method)
+		}
+	}
+	if c.Config.Fallback.Selector == fallback.SelectStrategyWeighted && len(c.URLs) > 1 &&
+		!slices.ContainsFunc(c.URLs, func(url string) bool { w, ok := weights[url]; return !ok || w > 0 }) {
+		return errors.New("weighted selector requires a url with a positive weight")
+	}
 
-	return nil
+	if err := c.Config.Auth.Parse(); err != nil {
+		return err
+	}
+	if err := c.Config.TLS.Parse(); err != nil {
+		return err
+	}
+	if c.Config.Proxy != nil {
+		if _, err := c.Config.Proxy.ProxyFunc(); err != nil {
+			return err
+		}
+	}
+	if c.Config.Cache != nil {
+		if err := c.Config.Cache.Parse(); err != nil {
+			return err
+		}
+	}
+	if c.Config.RateLimit != nil {
+		if err := c.Config.RateLimit.Parse(); err != nil {
+			return err
+		}
+	}
+	return c.Config.Checker.Parse()
 }
 
-// Do executes the API
+// Do executes the API.
+// The timeout covers reading the response body, which must be closed to release it.
+// With WithStream, the idle timeout replaces the timeout and the body checks are skipped.
 func (c *API) Do(opts ...Option) (resp *http.Response, err error) {
 	cfg := &config{}
 	for _, opt := range opts {
 		opt(cfg)
 	}
 	if cfg.ctx == nil {
-		ctx, cancel := context.WithTimeout(context.Background(), c.Config.Timeout)
-		defer cancel()
-		cfg.ctx = ctx
+		cfg.ctx = context.Background()
+	}
+
+	// a fresh cached response is served without the upstream, a stale one is revalidated
+	var (
+		rc    *responseCache
+		entry *cachedResponse
+	)
+	call := c
+	if c.cacheable(cfg) {
+		if rc, err = c.responseCache(cfg); err != nil {
+			return nil, err
+		}
+		if entry = rc.get(cfg.ctx); entry != nil {
+			if entry.fresh(time.Now()) {
+				return entry.response(nil, CacheHit), nil
+			}
+			if validators := entry.validators(); validators != nil {
+				call = c.withHeaders(validators)
+			} else {
+				entry = nil
+			}
+		}
+	}
+
+	if cfg.client == nil {
+		conf := c.Config
+		if cfg.proxy != nil {
+			conf.Proxy = cfg.proxy
+		}
+		if cfg.client, err = conf.client(c.URL == ""); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		idle   *httpclient.IdleTimeout
+	)
+	if cfg.stream {
+		idle = httpclient.NewIdleTimeout(cfg.ctx, c.Config.idleTimeout())
+		ctx, cancel = idle.Context(), idle.Stop
+	} else {
+		ctx, cancel = context.WithTimeout(cfg.ctx, c.Config.Timeout)
+	}
+	ctx = httpclient.ContextWithSecrets(ctx, c.secrets...)
+	if cfg.failFast != nil {
+		ctx = httpclient.ContextWithRateLimitFailFast(ctx, *cfg.failFast)
+	} else if c.Config.RateLimit != nil {
+		// the rate limiters are created without fail fast, the calls of the API set it on the context
+		ctx = httpclient.ContextWithRateLimitFailFast(ctx, c.Config.RateLimit.FailFast)
+	}
+	release, err := c.acquireRateLimit(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	stop := cancel
+	cancel = func() {
+		stop()
+		release()
+	}
+	ctx, authorize, err := c.Config.Auth.Authorizer(ctx, cfg.client)
+	if err != nil {
+		cancel()
+		return nil, httpclient.MaskError(ctx, err)
+	}
+	if authorize != nil {
+		// the client signs every attempt, a retried request is not sent with a stale signature
+		ctx = httpclient.ContextWithAuthorizer(ctx, authorize)
 	}
 
 	if c.URL != "" {
-		resp, err = c.do(cfg.ctx, cfg.client)
+		resp, err = call.do(ctx, cfg.client)
 	} else {
-		resp, err = c.doWithFallback(cfg.ctx, cfg.client)
+		resp, err = call.doWithFallback(ctx, cfg)
 	}
 	if err != nil {
+		if idle != nil {
+			if cause := context.Cause(idle.Context()); errors.Is(cause, httpclient.ErrIdleTimeout) {
+				err = fmt.Errorf("%w: %w", cause, err)
+			}
+		}
+		cancel()
 		return nil, err
 	}
+	if idle != nil {
+		resp.Body = idle.Body(resp.Body)
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// the token may be revoked before it expires, the next call fetches a new one
+		c.Config.Auth.expireOAuth2Token(cfg.ctx)
+	}
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		rc.revalidated(cfg.ctx, entry, resp)
+		return entry.response(resp.Request, CacheRevalidated), nil
+	}
 
-	if !c.IsSuccess(resp) {
-		return resp, fmt.Errorf("status code %d is not in success codes", resp.StatusCode)
+	if !c.isSuccess(resp, !cfg.stream) {
+		if !c.isSuccessCode(resp.StatusCode) {
+			return resp, fmt.Errorf("status code %d is not in success codes", resp.StatusCode)
+		}
+		return resp, errors.New("response does not match the checker")
 	}
 
+	if rc != nil {
+		rc.store(cfg.ctx, resp)
+		resp.Header.Set(CacheStatusHeader, CacheMiss)
+	}
 	return resp, nil
 }
 
+// withHeaders returns a copy of the API with the headers added
+func (c *API) withHeaders(headers map[string]string) *API {
+	cp := *c
+	cp.Headers = make(map[string]string, len(c.Headers)+len(headers))
+	for k, v := range c.Headers {
+		cp.Headers[k] = v
+	}
+	for k, v := range headers {
+		cp.Headers[k] = v
+	}
+	return &cp
+}
+
+// cancelBody cancels the request context when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 func (c *API) do(ctx context.Context, client httpclient.Client) (*http.Response, error) {
-	var req *http.Request
-	req, err := http.NewRequestWithContext(ctx, c.Method, c.URL, bytes.NewBufferString(c.Body))
+	body, err := c.body()
+	if err != nil {
+		return nil, err
+	}
+	req, err := body.NewRequest(ctx, c.Method, c.URL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -104,14 +282,14 @@ func (c *API) do(ctx context.Context, client httpclient.Client) (*http.Response,
 	return client.Do(req)
 }
 
-func (c *API) doWithFallback(ctx context.Context, client httpclient.Client) (*http.Response, error) {
+func (c *API) doWithFallback(ctx context.Context, cfg *config) (*http.Response, error) {
 	if len(c.URLs) == 0 {
 		return nil, errors.New("urls is required")
 	}
 
 	opts := []fallback.Option{fallback.WithContext(ctx)}
-	if client != nil {
-		opts = append(opts, fallback.WithRetryClient(client))
+	if cfg.client != nil {
+		opts = append(opts, fallback.WithRetryClient(cfg.client))
 	}
 	if c.Headers != nil {
 		headers := http.Header{}
@@ -120,27 +298,64 @@ func (c *API) doWithFallback(ctx context.Context, client httpclient.Client) (*ht
 		}
 		opts = append(opts, fallback.WithHeader(headers))
 	}
+	if cfg.observer != nil {
+		opts = append(opts, fallback.WithAttemptObserver(cfg.observer))
+	}
 	if c.Config.Fallback.Selector != "" {
 		opts = append(opts, fallback.WithSelectStrategy(c.Config.Fallback.Selector))
 	}
-	if len(c.Config.Fallback.RetryCodes) > 0 {
+	if c.Config.Fallback.HedgeDelay > 0 {
+		opts = append(opts, fallback.WithHedgeDelay(c.Config.Fallback.HedgeDelay))
+	}
+	if len(c.Config.Fallback.Weights) > 0 {
+		opts = append(opts, fallback.WithWeights(c.Config.Fallback.Weights))
+	}
+	matchBody := !cfg.stream && len(c.Config.Checker.BodyMatch) > 0
+	if len(c.Config.Fallback.RetryCodes) > 0 || matchBody {
 		opts = append(opts, fallback.WithRetryChecker(func(resp *http.Response) bool {
-			return slices.Contains(c.Config.Fallback.RetryCodes, resp.StatusCode)
+			return c.shouldFallback(resp, matchBody)
 		}))
 	}
 
-	return fallback.Do(c.Method, c.URLs, []byte(c.Body), opts...)
+	body, err := c.body()
+	if err != nil {
+		return nil, err
+	}
+	return fallback.DoBody(c.Method, c.URLs, body, opts...)
+}
+
+// body returns the request body, replayed on each retry and fallback url
+func (c *API) body() (*httpclient.Body, error) {
+	if c.BodySource != nil {
+		return c.BodySource.body()
+	}
+	return httpclient.BytesBody([]byte(c.Body), ""), nil
+}
+
+// shouldFallback checks if the response should fall back to the next url,
+// the body checks are skipped for the streamed responses
+func (c *API) shouldFallback(resp *http.Response, matchBody bool) bool {
+	// a not modified response answers the conditional request of the response cache
+	if resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if len(c.Config.Fallback.RetryCodes) > 0 {
+		if slices.Contains(c.Config.Fallback.RetryCodes, resp.StatusCode) {
+			return true
+		}
+	} else if fallback.DefaultRetry(resp) {
+		return true
+	}
+	return matchBody && !c.IsSuccess(resp)
 }
 
 // IsSuccess checks if the response is successful
 func (c *API) IsSuccess(resp *http.Response) bool {
-	var flag bool
-	if len(c.Config.Checker.SuccessCodes) == 0 {
-		flag = slices.Contains(SuccessCodes, resp.StatusCode)
-	} else {
-		flag = slices.Contains(c.Config.Checker.SuccessCodes, resp.StatusCode)
-	}
-	if !flag {
+	return c.isSuccess(resp, true)
+}
+
+func (c *API) isSuccess(resp *http.Response, matchBody bool) bool {
+	if !c.isSuccessCode(resp.StatusCode) {
 		return false
 	}
 
@@ -150,8 +365,14 @@ func (c *API) IsSuccess(resp *http.Response) bool {
 		}
 	}
 
-	// TODO: body match
-	return true
+	return !matchBody || c.Config.Checker.MatchBody(resp)
+}
+
+func (c *API) isSuccessCode(code int) bool {
+	if len(c.Config.Checker.SuccessCodes) == 0 {
+		return slices.Contains(SuccessCodes, code)
+	}
+	return slices.Contains(c.Config.Checker.SuccessCodes, code)
 }
 
 // Config is a struct for API config
@@ -159,25 +380,56 @@ type Config struct {
 	Checker  Checker       `yaml:"checker" json:"checker"`
 	Fallback Fallback      `yaml:"fallback" json:"fallback"`
 	Timeout  time.Duration `yaml:"timeout" json:"timeout"`
+	// IdleTimeout is the max time without data of a streamed response, the Timeout by default
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+	Auth        Auth          `yaml:"auth,omitempty" json:"auth,omitempty"`
+	TLS         TLSConfig     `yaml:"tls,omitempty" json:"tls,omitempty"`
+	// CircuitBreaker enables a circuit breaker per upstream host, the fallback skips the open ones
+	CircuitBreaker *httpclient.BreakerConfig `yaml:"circuit_breaker,omitempty" json:"circuit_breaker,omitempty"`
+	// Proxy is the outbound proxy of the API, the environment proxies are used if it is nil
+	Proxy *httpclient.ProxyConfig `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	// Cache caches the successful responses, the streamed responses are not cached
+	Cache *CacheConfig `yaml:"cache,omitempty" json:"cache,omitempty"`
+	// RateLimit limits the calls per API, per upstream host or per key
+	RateLimit *RateLimit `yaml:"rate_limit,omitempty" json:"rate_limit,omitempty"`
+}
+
+// idleTimeout returns the idle timeout of the streamed responses
+func (c Config) idleTimeout() time.Duration {
+	if c.IdleTimeout > 0 {
+		return c.IdleTimeout
+	}
+	return c.Timeout
 }
 
 // Checker is a struct for API checker
 type Checker struct {
 	SuccessCodes []int             `yaml:"success_codes" json:"success_codes"`
 	HeaderMatch  map[string]string `yaml:"header_match" json:"header_match"`
+	BodyMatch    []BodyMatch       `yaml:"body_match" json:"body_match"`
 }
 
 // Fallback is a struct for API fallback
 type Fallback struct {
 	Selector   fallback.SelectStrategy `yaml:"selector" json:"selector"`
 	RetryCodes []int                   `yaml:"retry_codes" json:"retry_codes"`
+	// HedgeDelay is the delay before the hedged selector starts the next url
+	HedgeDelay time.Duration `yaml:"hedge_delay,omitempty" json:"hedge_delay,omitempty"`
+	// Weights are the weights of the urls for the weighted selector, the default weight is 1
+	// and a zero weight disables the url
+	Weights map[string]int `yaml:"weights,omitempty" json:"weights,omitempty"`
 }
 
 type Option func(*config)
 
 type config struct {
-	ctx    context.Context
-	client httpclient.Client
+	ctx      context.Context
+	client   httpclient.Client
+	proxy    *httpclient.ProxyConfig
+	observer fallback.AttemptObserver
+	stream   bool
+	cache    cache.Cache
+	failFast *bool
 }
 
 func WithClient(client httpclient.Client) Option {
@@ -191,3 +443,41 @@ func WithContext(ctx context.Context) Option {
 		c.ctx = ctx
 	}
 }
+
+// WithAttemptObserver sets the observer of the attempts of the fallback, it is not called for a single url.
+func WithAttemptObserver(observer fallback.AttemptObserver) Option {
+	return func(c *config) {
+		c.observer = observer
+	}
+}
+
+// WithStream streams the response: the idle timeout of the config replaces its timeout,
+// and the body checks are skipped not to wait for the end of the stream.
+func WithStream() Option {
+	return func(c *config) {
+		c.stream = true
+	}
+}
+
+// WithCache sets the backend of the response cache, DefaultResponseCache by default.
+// It is ignored if the API config has no cache.
+func WithCache(backend cache.Cache) Option {
+	return func(c *config) {
+		c.cache = backend
+	}
+}
+
+// WithRateLimitFailFast overrides the fail fast of the rate limit of the API config:
+// the calls over the limits fail with a httpclient.RateLimitError instead of waiting.
+func WithRateLimitFailFast(failFast bool) Option {
+	return func(c *config) {
+		c.failFast = &failFast
+	}
+}
+
+// WithProxy overrides the proxy of the API config, it is ignored with WithClient.
+func WithProxy(proxy *httpclient.ProxyConfig) Option {
+	return func(c *config) {
+		c.proxy = proxy
+	}
+}
@@ -2,13 +2,17 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/telepair/telepair/pkg/httpclient"
+	"github.com/telepair/telepair/pkg/httpclient/fallback"
 )
 
 func TestAPI_Parse(t *testing.T) {
@@ -49,6 +53,46 @@ func TestAPI_Parse(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "negative fallback weight",
+			api: API{
+				Method: "GET",
+				URLs:   []string{"http://a.com", "http://b.com"},
+				Config: Config{Fallback: Fallback{Weights: map[string]int{"http://a.com": -1}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "race selector of a POST request",
+			api: API{
+				Method: "POST",
+				URLs:   []string{"http://a.com", "http://b.com"},
+				Config: Config{Fallback: Fallback{Selector: fallback.SelectStrategyRace}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "hedged selector of a POST request with an idempotency key",
+			api: API{
+				Method:  "POST",
+				URLs:    []string{"http://a.com", "http://b.com"},
+				Headers: map[string]string{"idempotency-key": "key"},
+				Config:  Config{Fallback: Fallback{Selector: fallback.SelectStrategyHedged}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "zero weight of every URL",
+			api: API{
+				Method: "GET",
+				URLs:   []string{"http://a.com", "http://b.com"},
+				Config: Config{Fallback: Fallback{
+					Selector: fallback.SelectStrategyWeighted,
+					Weights:  map[string]int{"http://a.com": 0, "http://b.com": 0},
+				}},
+			},
+			wantErr: true,
+		},
 		{
 			name: "single URL in URLs",
 			api: API{
@@ -249,3 +293,100 @@ func TestAPI_Options(t *testing.T) {
 		})
 	}
 }
+
+func TestAPI_DoWithAttemptObserver(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+
+	api := &API{
+		Method: "GET",
+		URLs:   []string{down.URL, up.URL},
+		Config: Config{Timeout: time.Second, Fallback: Fallback{RetryCodes: []int{http.StatusNotFound}}},
+	}
+	var attempts []fallback.Attempt
+	resp, err := api.Do(WithAttemptObserver(func(a fallback.Attempt) { attempts = append(attempts, a) }))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	if assert.Len(t, attempts, 2) {
+		assert.Equal(t, http.StatusNotFound, attempts[0].StatusCode)
+		assert.False(t, attempts[0].Accepted)
+		assert.Equal(t, up.URL, attempts[1].URL)
+		assert.True(t, attempts[1].Accepted)
+	}
+}
+
+func TestAPI_DoWithFallbackNoRetry(t *testing.T) {
+	var hits atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	// the client of the circuit breaker does not retry, the next url is tried at once
+	api := &API{
+		Method: "GET",
+		URLs:   []string{down.URL + "/a", down.URL + "/b"},
+		Config: Config{Timeout: 4 * time.Second, CircuitBreaker: &httpclient.BreakerConfig{ConsecutiveFailures: 100}},
+	}
+	start := time.Now()
+	resp, err := api.Do()
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, int32(2), hits.Load())
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestAPI_DoWithStream(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		for i := range 4 {
+			select {
+			case <-time.After(40 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			_, _ = fmt.Fprintf(w, "{\"n\":%d}\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	api := &API{
+		Method: "GET",
+		URL:    ts.URL,
+		Config: Config{
+			Timeout: 100 * time.Millisecond,
+			Checker: Checker{BodyMatch: []BodyMatch{{Op: MatchOpContains, Value: "never"}}},
+		},
+	}
+	assert.NoError(t, api.Parse())
+
+	// the stream lasts longer than the timeout, the body checks are skipped
+	resp, err := api.Do(WithStream())
+	assert.NoError(t, err)
+	var lines []string
+	err = httpclient.ReadLines(resp.Body, func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	_ = resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Len(t, lines, 4)
+
+	api.Config.IdleTimeout = 10 * time.Millisecond
+	resp, err = api.Do(WithStream())
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+	assert.ErrorIs(t, err, httpclient.ErrIdleTimeout)
+}
@@ -0,0 +1,526 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telepair/telepair/pkg/cache"
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+// AuthType is the authentication scheme of the API
+type AuthType string
+
+const (
+	AuthTypeNone   AuthType = ""
+	AuthTypeBasic  AuthType = "basic"
+	AuthTypeBearer AuthType = "bearer"
+	AuthTypeOAuth2 AuthType = "oauth2"
+	AuthTypeHMAC   AuthType = "hmac"
+	AuthTypeSigV4  AuthType = "sigv4"
+)
+
+const (
+	DefaultHMACAlgorithm       = "sha256"
+	DefaultHMACSignatureHeader = "X-Signature"
+	DefaultHMACTimestampHeader = "X-Timestamp"
+	DefaultHMACKeyIDHeader     = "X-Key-ID"
+	// DefaultTokenExpiryDelta is subtracted from the OAuth2 token lifetime,
+	// so the cached token is refreshed before it expires.
+	DefaultTokenExpiryDelta = 30 * time.Second
+	// DefaultTokenTTL is the cache ttl of the OAuth2 token without expires_in
+	DefaultTokenTTL = 5 * time.Minute
+)
+
+var (
+	// authNow returns the signing time, replaced in tests
+	authNow = time.Now
+
+	oauth2TokenCache = cache.NewMemory("api-oauth2-token")
+	oauth2TokenLock  sync.Mutex
+)
+
+// Auth is the authentication of the API.
+// Example:
+//
+//	auth:
+//	  type: oauth2
+//	  token_url: https://auth.example.com/oauth2/token
+//	  client_id: my-client
+//	  client_secret: my-secret
+//	  scopes: [read]
+//
+//	auth:
+//	  type: sigv4
+//	  access_key: AKIDEXAMPLE
+//	  secret_key: secret
+//	  region: us-east-1
+//	  service: execute-api
+type Auth struct {
+	Type AuthType `yaml:"type,omitempty" json:"type,omitempty"`
+
+	// Basic
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Password string `yaml:"password,omitempty" json:"password,omitempty"`
+
+	// Bearer
+	Token string `yaml:"token,omitempty" json:"token,omitempty"`
+
+	// OAuth2 client credentials, AuthStyle is header (default) or params
+	TokenURL     string            `yaml:"token_url,omitempty" json:"token_url,omitempty"`
+	ClientID     string            `yaml:"client_id,omitempty" json:"client_id,omitempty"`
+	ClientSecret string            `yaml:"client_secret,omitempty" json:"client_secret,omitempty"`
+	Scopes       []string          `yaml:"scopes,omitempty" json:"scopes,omitempty"`
+	Params       map[string]string `yaml:"params,omitempty" json:"params,omitempty"`
+	AuthStyle    string            `yaml:"auth_style,omitempty" json:"auth_style,omitempty"`
+
+	// HMAC signing, Algorithm is sha256 (default) or sha512
+	KeyID           string `yaml:"key_id,omitempty" json:"key_id,omitempty"`
+	Secret          string `yaml:"secret,omitempty" json:"secret,omitempty"`
+	Algorithm       string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	SignatureHeader string `yaml:"signature_header,omitempty" json:"signature_header,omitempty"`
+	TimestampHeader string `yaml:"timestamp_header,omitempty" json:"timestamp_header,omitempty"`
+	KeyIDHeader     string `yaml:"key_id_header,omitempty" json:"key_id_header,omitempty"`
+
+	// AWS SigV4 signing
+	AccessKey    string `yaml:"access_key,omitempty" json:"access_key,omitempty"`
+	SecretKey    string `yaml:"secret_key,omitempty" json:"secret_key,omitempty"`
+	SessionToken string `yaml:"session_token,omitempty" json:"session_token,omitempty"`
+	Region       string `yaml:"region,omitempty" json:"region,omitempty"`
+	Service      string `yaml:"service,omitempty" json:"service,omitempty"`
+}
+
+// Parse parses the auth
+func (a *Auth) Parse() error {
+	a.Type = AuthType(strings.ToLower(strings.TrimSpace(string(a.Type))))
+	switch a.Type {
+	case AuthTypeNone:
+	case AuthTypeBasic:
+		if a.Username == "" {
+			return errors.New("basic auth username is required")
+		}
+	case AuthTypeBearer:
+		if a.Token == "" {
+			return errors.New("bearer auth token is required")
+		}
+	case AuthTypeOAuth2:
+		if a.TokenURL == "" || a.ClientID == "" {
+			return errors.New("oauth2 auth token_url and client_id are required")
+		}
+		if a.AuthStyle == "" {
+			a.AuthStyle = "header"
+		}
+		if a.AuthStyle != "header" && a.AuthStyle != "params" {
+			return fmt.Errorf("oauth2 auth style %s is invalid", a.AuthStyle)
+		}
+	case AuthTypeHMAC:
+		if a.Secret == "" {
+			return errors.New("hmac auth secret is required")
+		}
+		a.Algorithm = strings.ToLower(a.Algorithm)
+		if a.Algorithm == "" {
+			a.Algorithm = DefaultHMACAlgorithm
+		}
+		if a.Algorithm != "sha256" && a.Algorithm != "sha512" {
+			return fmt.Errorf("hmac auth algorithm %s is invalid", a.Algorithm)
+		}
+		if a.SignatureHeader == "" {
+			a.SignatureHeader = DefaultHMACSignatureHeader
+		}
+		if a.TimestampHeader == "" {
+			a.TimestampHeader = DefaultHMACTimestampHeader
+		}
+		if a.KeyIDHeader == "" {
+			a.KeyIDHeader = DefaultHMACKeyIDHeader
+		}
+	case AuthTypeSigV4:
+		if a.AccessKey == "" || a.SecretKey == "" || a.Region == "" || a.Service == "" {
+			return errors.New("sigv4 auth access_key, secret_key, region and service are required")
+		}
+	default:
+		return fmt.Errorf("auth type %s is invalid", a.Type)
+	}
+	return nil
+}
+
+// templateFields returns the credential fields rendered by the template
+func (a *Auth) templateFields() map[string]*string {
+	return map[string]*string{
+		"username":      &a.Username,
+		"password":      &a.Password,
+		"token":         &a.Token,
+		"client_id":     &a.ClientID,
+		"client_secret": &a.ClientSecret,
+		"key_id":        &a.KeyID,
+		"secret":        &a.Secret,
+		"access_key":    &a.AccessKey,
+		"secret_key":    &a.SecretKey,
+		"session_token": &a.SessionToken,
+	}
+}
+
+// secretFields returns the credential fields masked in the shown auth config
+func (a *Auth) secretFields() []*string {
+	return []*string{&a.Password, &a.Token, &a.ClientSecret, &a.Secret, &a.SecretKey, &a.SessionToken}
+}
+
+// masked returns the auth with the credentials masked, to show the auth config
+func (a *Auth) masked() Auth {
+	m := *a
+	for _, field := range m.secretFields() {
+		if *field != "" {
+			*field = httpclient.SecretMask
+		}
+	}
+	return m
+}
+
+// unmask restores the masked credentials from the current auth,
+// it fails if the current auth has no credential to restore.
+func (a *Auth) unmask(current Auth) error {
+	fields := current.secretFields()
+	for i, field := range a.secretFields() {
+		if *field != httpclient.SecretMask {
+			continue
+		}
+		if *fields[i] == "" {
+			return errors.New("masked auth credentials can not be restored")
+		}
+		*field = *fields[i]
+	}
+	return nil
+}
+
+// Secrets returns the credentials of the auth, they are masked in logs and dumps
+func (a *Auth) Secrets() []string {
+	switch a.Type {
+	case AuthTypeBasic:
+		return []string{a.Password, basicAuth(a.Username, a.Password)}
+	case AuthTypeBearer:
+		return []string{a.Token}
+	case AuthTypeOAuth2:
+		return []string{a.ClientSecret}
+	case AuthTypeHMAC:
+		return []string{a.Secret}
+	case AuthTypeSigV4:
+		return []string{a.SecretKey, a.SessionToken}
+	default:
+		return nil
+	}
+}
+
+// Authorizer returns the function that authorizes every request of the API.
+// The OAuth2 token is fetched with the client and cached until it expires or is rejected.
+// The returned context carries the credentials to mask.
+func (a *Auth) Authorizer(ctx context.Context, client httpclient.Client) (context.Context, func(req *http.Request) error, error) {
+	ctx = httpclient.ContextWithSecrets(ctx, a.Secrets()...)
+	switch a.Type {
+	case AuthTypeBasic:
+		return ctx, setHeader("Authorization", "Basic "+basicAuth(a.Username, a.Password)), nil
+	case AuthTypeBearer:
+		return ctx, setHeader("Authorization", "Bearer "+a.Token), nil
+	case AuthTypeOAuth2:
+		token, err := a.oauth2Token(ctx, client)
+		if err != nil {
+			return ctx, nil, err
+		}
+		ctx = httpclient.ContextWithSecrets(ctx, token)
+		return ctx, setHeader("Authorization", "Bearer "+token), nil
+	case AuthTypeHMAC:
+		return ctx, a.signHMAC, nil
+	case AuthTypeSigV4:
+		return ctx, a.signSigV4, nil
+	default:
+		return ctx, nil, nil
+	}
+}
+
+func setHeader(key, val string) func(req *http.Request) error {
+	return func(req *http.Request) error {
+		req.Header.Set(key, val)
+		return nil
+	}
+}
+
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+// oauth2TokenResponse is the token response of the client credentials grant
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (a *Auth) oauth2CacheKey() string {
+	h := sha256.New()
+	for _, s := range []string{a.TokenURL, a.ClientID, a.ClientSecret, strings.Join(a.Scopes, " "), a.AuthStyle} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	keys := make([]string, 0, len(a.Params))
+	for k := range a.Params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		h.Write([]byte(k + "=" + a.Params[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (a *Auth) oauth2Token(ctx context.Context, client httpclient.Client) (string, error) {
+	key := a.oauth2CacheKey()
+	if val, err := oauth2TokenCache.Get(ctx, key); err == nil {
+		return val.(string), nil
+	}
+
+	// serialize the token requests, so concurrent calls share the fetched token
+	oauth2TokenLock.Lock()
+	defer oauth2TokenLock.Unlock()
+	if val, err := oauth2TokenCache.Get(ctx, key); err == nil {
+		return val.(string), nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(a.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.Scopes, " "))
+	}
+	for k, v := range a.Params {
+		form.Set(k, v)
+	}
+	if a.AuthStyle == "params" {
+		form.Set("client_id", a.ClientID)
+		form.Set("client_secret", a.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.AuthStyle != "params" {
+		req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+	}
+
+	if client == nil {
+		client = httpclient.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read oauth2 token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch oauth2 token: status code %d", resp.StatusCode)
+	}
+	var token oauth2TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("failed to decode oauth2 token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("oauth2 token response has no access_token")
+	}
+
+	ttl := DefaultTokenTTL
+	if token.ExpiresIn > 0 {
+		ttl = time.Duration(token.ExpiresIn)*time.Second - DefaultTokenExpiryDelta
+	}
+	if ttl > 0 {
+		_ = oauth2TokenCache.SetWithTTL(ctx, key, token.AccessToken, ttl)
+	}
+	return token.AccessToken, nil
+}
+
+// expireOAuth2Token deletes the cached OAuth2 token, e.g. when the upstream rejects it
+func (a *Auth) expireOAuth2Token(ctx context.Context) {
+	if a.Type == AuthTypeOAuth2 {
+		_ = oauth2TokenCache.Delete(ctx, a.oauth2CacheKey())
+	}
+}
+
+// requestBody returns a copy of the request body without consuming it
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return io.ReadAll(body)
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return data, nil
+}
+
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSum(newHash func() hash.Hash, key []byte, data string) []byte {
+	h := hmac.New(newHash, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// signHMAC signs the request with HMAC of the string:
+//
+//	METHOD \n PATH \n QUERY \n TIMESTAMP \n HEX(SHA256(BODY))
+//
+// The hex signature is set to SignatureHeader, the unix timestamp to
+// TimestampHeader and the key id, if any, to KeyIDHeader.
+func (a *Auth) signHMAC(req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	ts := strconv.FormatInt(authNow().Unix(), 10)
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	data := strings.Join([]string{req.Method, path, req.URL.RawQuery, ts, hashHex(body)}, "\n")
+
+	newHash := sha256.New
+	if a.Algorithm == "sha512" {
+		newHash = sha512.New
+	}
+	req.Header.Set(a.TimestampHeader, ts)
+	if a.KeyID != "" {
+		req.Header.Set(a.KeyIDHeader, a.KeyID)
+	}
+	req.Header.Set(a.SignatureHeader, hex.EncodeToString(hmacSum(newHash, []byte(a.Secret), data)))
+	return nil
+}
+
+// signSigV4 signs the request with AWS Signature Version 4,
+// X-Amz-Content-Sha256 is only required by s3.
+func (a *Auth) signSigV4(req *http.Request) error {
+	body, err := requestBody(req)
+	if err != nil {
+		return err
+	}
+	now := authNow().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := hashHex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if a.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	if a.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", a.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-") || lk == "content-type" {
+			headers[lk] = strings.Join(v, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, a.Region, a.Service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hashHex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSum(sha256.New, []byte("AWS4"+a.SecretKey), date)
+	key = hmacSum(sha256.New, key, a.Region)
+	key = hmacSum(sha256.New, key, a.Service)
+	key = hmacSum(sha256.New, key, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(sha256.New, key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		a.AccessKey, scope, signedHeaders, signature))
+	return nil
+}
+
+// canonicalQuery encodes the query sorted by key and value, spaces as %20
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	parts := make([]string, 0, len(query))
+	for _, k := range keys {
+		vals := slices.Clone(query[k])
+		slices.Sort(vals)
+		for _, v := range vals {
+			parts = append(parts, sigV4Escape(k)+"="+sigV4Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sigV4Escape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+func TestAuth_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    Auth
+		wantErr bool
+	}{
+		{name: "none", auth: Auth{}},
+		{name: "basic", auth: Auth{Type: "Basic", Username: "user", Password: "pass"}},
+		{name: "basic without username", auth: Auth{Type: AuthTypeBasic}, wantErr: true},
+		{name: "bearer", auth: Auth{Type: AuthTypeBearer, Token: "token"}},
+		{name: "bearer without token", auth: Auth{Type: AuthTypeBearer}, wantErr: true},
+		{name: "oauth2", auth: Auth{Type: AuthTypeOAuth2, TokenURL: "https://example.com/token", ClientID: "id"}},
+		{name: "oauth2 without client id", auth: Auth{Type: AuthTypeOAuth2, TokenURL: "https://example.com/token"}, wantErr: true},
+		{name: "oauth2 invalid style", auth: Auth{Type: AuthTypeOAuth2, TokenURL: "https://example.com/token", ClientID: "id", AuthStyle: "body"}, wantErr: true},
+		{name: "hmac", auth: Auth{Type: AuthTypeHMAC, Secret: "secret"}},
+		{name: "hmac invalid algorithm", auth: Auth{Type: AuthTypeHMAC, Secret: "secret", Algorithm: "md5"}, wantErr: true},
+		{name: "sigv4", auth: Auth{Type: AuthTypeSigV4, AccessKey: "ak", SecretKey: "sk", Region: "us-east-1", Service: "s3"}},
+		{name: "sigv4 without region", auth: Auth{Type: AuthTypeSigV4, AccessKey: "ak", SecretKey: "sk", Service: "s3"}, wantErr: true},
+		{name: "invalid type", auth: Auth{Type: "digest"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.auth.Parse()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestAPI_DoWithAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name string
+		auth Auth
+		want string
+	}{
+		{name: "basic", auth: Auth{Type: AuthTypeBasic, Username: "user", Password: "pass"}, want: "Basic dXNlcjpwYXNz"},
+		{name: "bearer", auth: Auth{Type: AuthTypeBearer, Token: "token"}, want: "Bearer token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, urls := range [][]string{{server.URL}, {server.URL, server.URL + "/"}} {
+				api := API{Method: http.MethodGet, URLs: urls, Config: Config{Auth: tt.auth}}
+				assert.NoError(t, api.Parse())
+				resp, err := api.Do()
+				assert.NoError(t, err)
+				body, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				assert.Equal(t, tt.want, string(body))
+			}
+		})
+	}
+}
+
+func TestAuth_OAuth2(t *testing.T) {
+	var fetched atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+		assert.Equal(t, "read write", r.PostForm.Get("scope"))
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "my-client" || secret != "my-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := fetched.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	var valid atomic.Value
+	valid.Store("Bearer token-1")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != valid.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	api := API{
+		Method: http.MethodGet,
+		URL:    server.URL,
+		Config: Config{Auth: Auth{
+			Type:         AuthTypeOAuth2,
+			TokenURL:     tokenServer.URL,
+			ClientID:     "my-client",
+			ClientSecret: "my-secret",
+			Scopes:       []string{"read", "write"},
+		}},
+	}
+	assert.NoError(t, api.Parse())
+	for range 3 {
+		resp, err := api.Do()
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, int32(1), fetched.Load(), "token should be cached")
+
+	// a revoked token is rejected once, the next call fetches a new one
+	valid.Store("Bearer token-2")
+	resp, err := api.Do()
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	_ = resp.Body.Close()
+	resp, err = api.Do()
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, int32(2), fetched.Load())
+
+	api.Config.Auth.ClientSecret = "wrong-secret"
+	_, err = api.Do()
+	assert.ErrorContains(t, err, "failed to fetch oauth2 token")
+	assert.NotContains(t, err.Error(), "wrong-secret")
+}
+
+func TestAuth_HMAC(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(body)
+		data := strings.Join([]string{r.Method, r.URL.EscapedPath(), r.URL.RawQuery, r.Header.Get("X-Timestamp"), hex.EncodeToString(sum[:])}, "\n")
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(data))
+		if r.Header.Get("X-Key-ID") != "key-1" || r.Header.Get("X-Signature") != hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	api := API{
+		Method: http.MethodPost,
+		URL:    server.URL + "/orders?id=1",
+		Body:   `{"item":"book"}`,
+		Config: Config{Auth: Auth{Type: AuthTypeHMAC, KeyID: "key-1", Secret: "secret"}},
+	}
+	assert.NoError(t, api.Parse())
+	resp, err := api.Do()
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	assert.Equal(t, `{"item":"book"}`, string(body), "body should be sent after signing")
+}
+
+func TestAuth_HMACRetry(t *testing.T) {
+	var now atomic.Int64
+	now.Store(1700000000)
+	authNow = func() time.Time { return time.Unix(now.Add(1), 0) }
+	defer func() { authNow = time.Now }()
+
+	var timestamps []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamps = append(timestamps, r.Header.Get("X-Timestamp"))
+		if len(timestamps) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	api := API{
+		Method: http.MethodGet,
+		URL:    server.URL,
+		Config: Config{Auth: Auth{Type: AuthTypeHMAC, KeyID: "key-1", Secret: "secret"}},
+	}
+	assert.NoError(t, api.Parse())
+	resp, err := api.Do(WithClient(httpclient.New(httpclient.WithRetry(1, time.Millisecond, time.Millisecond))))
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{"1700000001", "1700000002"}, timestamps, "every attempt should be signed")
+}
+
+func TestAuth_SigV4(t *testing.T) {
+	// the get-vanilla case of the AWS Signature Version 4 test suite
+	authNow = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+	defer func() { authNow = time.Now }()
+
+	auth := Auth{
+		Type:      AuthTypeSigV4,
+		AccessKey: "AKIDEXAMPLE",
+		SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		Region:    "us-east-1",
+		Service:   "service",
+	}
+	assert.NoError(t, auth.Parse())
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, auth.signSigV4(req))
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestTemplate_RenderAuth(t *testing.T) {
+	tmpl := Template{
+		Name:          "auth",
+		API:           API{Method: http.MethodGet, URL: "https://example.com", Config: Config{Auth: Auth{Type: AuthTypeBearer, Token: "{{ token }}"}}},
+		TemplateField: TemplateField{Auth: true},
+		Vars:          []VarRequired{{Name: "token", Secret: true}},
+	}
+	assert.NoError(t, tmpl.Parse())
+	api, err := tmpl.Render(map[string]string{"token": "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", api.Config.Auth.Token)
+	assert.Equal(t, "{{ token }}", tmpl.API.Config.Auth.Token)
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+// BodySource is a request body not inlined in the API, exactly one source is set.
+// The bodies are replayed on each retry and fallback url, the files are streamed.
+type BodySource struct {
+	// File is the path of the file sent as the body
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Form are the fields of the form-urlencoded body
+	Form map[string]string `yaml:"form,omitempty" json:"form,omitempty"`
+	// Multipart are the fields and files of the multipart form body
+	Multipart []httpclient.MultipartPart `yaml:"multipart,omitempty" json:"multipart,omitempty"`
+	// Reader is read once and buffered to be replayed, it can only be set in code
+	Reader io.Reader `yaml:"-" json:"-"`
+	// ContentType is the content type of the file and reader bodies, the Content-Type header overrides it
+	ContentType string `yaml:"content_type,omitempty" json:"content_type,omitempty"`
+
+	once   sync.Once
+	reader *httpclient.Body
+}
+
+// Parse checks the body source
+func (s *BodySource) Parse() error {
+	sources := 0
+	for _, set := range []bool{s.File != "", len(s.Form) > 0, len(s.Multipart) > 0, s.Reader != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return errors.New("body source requires exactly one of file, form, multipart or reader")
+	}
+	for _, p := range s.Multipart {
+		if p.Name == "" {
+			return errors.New("multipart part name is required")
+		}
+	}
+	return nil
+}
+
+// body returns the body of the source
+func (s *BodySource) body() (*httpclient.Body, error) {
+	switch {
+	case s.File != "":
+		return httpclient.FileBody(s.File, s.ContentType)
+	case len(s.Form) > 0:
+		values := make(url.Values, len(s.Form))
+		for k, v := range s.Form {
+			values.Set(k, v)
+		}
+		return httpclient.FormBody(values), nil
+	case len(s.Multipart) > 0:
+		return httpclient.MultipartBody(s.Multipart)
+	case s.Reader != nil:
+		// the reader is consumed once, its buffer is shared by the calls of the API
+		s.once.Do(func() {
+			s.reader = httpclient.ReaderBody(s.Reader, s.ContentType)
+		})
+		return s.reader, nil
+	}
+	return nil, errors.New("body source is empty")
+}
+
+// clone returns a copy of the body source to render, the reader is shared
+func (s *BodySource) clone() *BodySource {
+	c := &BodySource{
+		File:        s.File,
+		Reader:      s.Reader,
+		ContentType: s.ContentType,
+		Multipart:   append([]httpclient.MultipartPart(nil), s.Multipart...),
+	}
+	if s.Reader != nil {
+		// the copies replay the buffer of the reader read once
+		reader, _ := s.body()
+		c.once.Do(func() { c.reader = reader })
+	}
+	if s.Form != nil {
+		c.Form = make(map[string]string, len(s.Form))
+		for k, v := range s.Form {
+			c.Form[k] = v
+		}
+	}
+	return c
+}
+
+// render renders the fields of the body source with the template function
+func (s *BodySource) render(render func(tmpl string) (string, error)) (err error) {
+	if s.File, err = render(s.File); err != nil {
+		return fmt.Errorf("body file template: %w", err)
+	}
+	for k, v := range s.Form {
+		if s.Form[k], err = render(v); err != nil {
+			return fmt.Errorf("body form %s template: %w", k, err)
+		}
+	}
+	for i := range s.Multipart {
+		p := &s.Multipart[i]
+		for name, field := range map[string]*string{"value": &p.Value, "file": &p.File, "file name": &p.FileName} {
+			if *field, err = render(*field); err != nil {
+				return fmt.Errorf("body multipart %s %s template: %w", p.Name, name, err)
+			}
+		}
+	}
+	return nil
+}
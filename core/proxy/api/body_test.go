@@ -0,0 +1,125 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+func TestBodySource_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  *BodySource
+		wantErr bool
+	}{
+		{name: "file", source: &BodySource{File: "a.bin"}},
+		{name: "form", source: &BodySource{Form: map[string]string{"a": "1"}}},
+		{name: "multipart", source: &BodySource{Multipart: []httpclient.MultipartPart{{Name: "a", Value: "1"}}}},
+		{name: "reader", source: &BodySource{Reader: strings.NewReader("a")}},
+		{name: "empty", source: &BodySource{}, wantErr: true},
+		{name: "file and form", source: &BodySource{File: "a.bin", Form: map[string]string{"a": "1"}}, wantErr: true},
+		{name: "part without name", source: &BodySource{Multipart: []httpclient.MultipartPart{{Value: "1"}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.source.Parse()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	api := API{Method: http.MethodPost, URL: "https://example.com", Body: "a", BodySource: &BodySource{File: "a.bin"}}
+	assert.Error(t, api.Parse(), "body and body source are exclusive")
+	api = API{Method: http.MethodGet, URL: "https://example.com", BodySource: &BodySource{File: "a.bin"}}
+	assert.NoError(t, api.Parse())
+	assert.Nil(t, api.BodySource)
+}
+
+func TestAPI_DoWithBodySource(t *testing.T) {
+	var requests []string
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if err := r.ParseMultipartForm(1 << 20); err == nil {
+				f, _, _ := r.FormFile("file")
+				content, _ := io.ReadAll(f)
+				requests = append(requests, r.FormValue("title")+":"+string(content))
+			} else {
+				data, _ := io.ReadAll(r.Body)
+				requests = append(requests, r.Header.Get("Content-Type")+":"+string(data))
+			}
+			w.WriteHeader(status)
+		}
+	}
+	down := httptest.NewServer(handler(http.StatusServiceUnavailable))
+	defer down.Close()
+	up := httptest.NewServer(handler(http.StatusOK))
+	defer up.Close()
+
+	file := filepath.Join(t.TempDir(), "report.csv")
+	require.NoError(t, os.WriteFile(file, []byte("a,b\n1,2\n"), 0o600))
+	api := API{
+		Method: http.MethodPost,
+		URLs:   []string{down.URL, up.URL},
+		BodySource: &BodySource{Multipart: []httpclient.MultipartPart{
+			{Name: "title", Value: "report"},
+			{Name: "file", File: file},
+		}},
+		Config: Config{Timeout: time.Second},
+	}
+	require.NoError(t, api.Parse())
+	resp, err := api.Do()
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{"report:a,b\n1,2\n", "report:a,b\n1,2\n"}, requests)
+
+	requests = nil
+	api = API{
+		Method:     http.MethodPut,
+		URL:        up.URL,
+		BodySource: &BodySource{File: file},
+		Config:     Config{Timeout: time.Second},
+	}
+	require.NoError(t, api.Parse())
+	resp, err = api.Do()
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{"text/csv; charset=utf-8:a,b\n1,2\n"}, requests)
+}
+
+func TestTemplate_RenderBodySource(t *testing.T) {
+	tmpl := Template{
+		Name: "upload",
+		API: API{
+			Method: http.MethodPost,
+			URL:    "https://example.com/upload",
+			BodySource: &BodySource{
+				Form: map[string]string{"user": "{{user}}", "fixed": "1"},
+			},
+		},
+		TemplateField: TemplateField{Body: true},
+		Vars:          []VarRequired{{Name: "user"}},
+	}
+	require.NoError(t, tmpl.Parse())
+
+	api, err := tmpl.Render(map[string]string{"user": "alice"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user": "alice", "fixed": "1"}, api.BodySource.Form)
+	assert.Equal(t, "{{user}}", tmpl.API.BodySource.Form["user"], "the template is not modified")
+
+	tmpl.Engine = "go"
+	tmpl.API.BodySource = &BodySource{Multipart: []httpclient.MultipartPart{{Name: "file", File: "{{ .path "}}}
+	assert.Error(t, tmpl.Parse())
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telepair/telepair/pkg/cache"
+)
+
+const (
+	// CacheStatusHeader is the response header telling how the response cache served the response
+	CacheStatusHeader = "X-Cache"
+	// CacheHit is the cache status of a fresh cached response
+	CacheHit = "HIT"
+	// CacheRevalidated is the cache status of a stale cached response the upstream did not modify
+	CacheRevalidated = "REVALIDATED"
+	// CacheMiss is the cache status of a response fetched from the upstream
+	CacheMiss = "MISS"
+
+	// DefaultCacheMaxBodySize is the max size of a cached response body
+	DefaultCacheMaxBodySize = 1 << 20
+	// DefaultCacheStaleTTL is how long a stale response with a validator is kept to be revalidated
+	DefaultCacheStaleTTL = 10 * time.Minute
+)
+
+// DefaultResponseCache is the backend of the response cache when WithCache is not set
+var DefaultResponseCache cache.Cache = cache.NewMemory("api-response")
+
+// CacheConfig is the response cache of the API.
+// The key of a response is made from the method, the urls, the auth config, the selected headers and the body hash.
+// Example:
+//
+//	cache:
+//	  ttl: 60s
+//	  headers: [Accept-Language]
+type CacheConfig struct {
+	// TTL is the freshness lifetime of the responses without Cache-Control max-age or Expires,
+	// the responses without any of them are not cached if it is 0
+	TTL time.Duration `yaml:"ttl" json:"ttl"`
+	// Headers are the names of the request headers in the cache key
+	Headers []string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	// Methods are the cached methods, GET and HEAD by default
+	Methods []string `yaml:"methods,omitempty" json:"methods,omitempty"`
+	// IgnoreCacheControl caches the responses for the TTL whatever their Cache-Control and Expires
+	IgnoreCacheControl bool `yaml:"ignore_cache_control,omitempty" json:"ignore_cache_control,omitempty"`
+	// StaleTTL is how long a stale response with an ETag or Last-Modified is kept to be revalidated
+	StaleTTL time.Duration `yaml:"stale_ttl,omitempty" json:"stale_ttl,omitempty"`
+	// MaxBodySize is the max size of a cached response body, 1MB by default
+	MaxBodySize int64 `yaml:"max_body_size,omitempty" json:"max_body_size,omitempty"`
+}
+
+// Parse checks the cache config
+func (c *CacheConfig) Parse() error {
+	if c.TTL < 0 || c.StaleTTL < 0 || c.MaxBodySize < 0 {
+		return errors.New("cache ttl, stale_ttl and max_body_size must not be negative")
+	}
+	for _, m := range c.Methods {
+		if !slices.Contains(AllowedMethods, strings.ToUpper(m)) {
+			return fmt.Errorf("cache method %s is invalid", m)
+		}
+	}
+	return nil
+}
+
+// caches checks if the responses of the method are cached
+func (c *CacheConfig) caches(method string) bool {
+	if len(c.Methods) == 0 {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return slices.ContainsFunc(c.Methods, func(m string) bool { return strings.EqualFold(m, method) })
+}
+
+// staleTTL returns how long a stale response with a validator is kept
+func (c *CacheConfig) staleTTL() time.Duration {
+	if c.StaleTTL > 0 {
+		return c.StaleTTL
+	}
+	return DefaultCacheStaleTTL
+}
+
+// maxBodySize returns the max size of a cached response body
+func (c *CacheConfig) maxBodySize() int64 {
+	if c.MaxBodySize > 0 {
+		return c.MaxBodySize
+	}
+	return DefaultCacheMaxBodySize
+}
+
+// cachedResponse is a response stored in the cache backend, encoded in JSON for any backend
+type cachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"stored_at"`
+	Expires    time.Time   `json:"expires"`
+}
+
+// fresh checks if the response can be served without the upstream
+func (r *cachedResponse) fresh(now time.Time) bool {
+	return now.Before(r.Expires)
+}
+
+// validators returns the conditional headers revalidating the response, nil without validator
+func (r *cachedResponse) validators() map[string]string {
+	headers := map[string]string{}
+	if etag := r.Header.Get("ETag"); etag != "" {
+		headers["If-None-Match"] = etag
+	}
+	if modified := r.Header.Get("Last-Modified"); modified != "" {
+		headers["If-Modified-Since"] = modified
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// response returns a new response of the cached one with the cache status
+func (r *cachedResponse) response(req *http.Request, status string) *http.Response {
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(CacheStatusHeader, status)
+	header.Set("Age", strconv.Itoa(int(time.Since(r.StoredAt).Seconds())))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+}
+
+// responseCache is the response cache of an API call
+type responseCache struct {
+	conf    *CacheConfig
+	backend cache.Cache
+	key     string
+}
+
+// cacheable checks if the API call uses the response cache, the streamed responses are not cached
+func (c *API) cacheable(cfg *config) bool {
+	return c.Config.Cache != nil && !cfg.stream && c.Config.Cache.caches(c.Method)
+}
+
+// responseCache returns the response cache of the API call
+func (c *API) responseCache(cfg *config) (*responseCache, error) {
+	backend := cfg.cache
+	if backend == nil {
+		backend = DefaultResponseCache
+	}
+	key, err := c.cacheKey()
+	if err != nil {
+		return nil, err
+	}
+	return &responseCache{conf: c.Config.Cache, backend: backend, key: key}, nil
+}
+
+// cacheKey returns the hash of the method, the urls, the auth config, the selected headers
+// and the body. The auth credentials are hashed, so the callers of different identities
+// do not share the cached responses.
+func (c *API) cacheKey() (string, error) {
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\n%s\n%s\n", c.Method, c.URL, strings.Join(c.URLs, "\n"))
+	if c.Config.Auth.Type != "" {
+		auth, err := json.Marshal(c.Config.Auth)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(h, "%s\n", auth)
+	}
+	for _, name := range c.Config.Cache.Headers {
+		_, _ = fmt.Fprintf(h, "%s: %s\n", http.CanonicalHeaderKey(name), c.header(name))
+	}
+	body, err := c.body()
+	if err != nil {
+		return "", err
+	}
+	r, err := body.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close() //nolint:errcheck
+	bh := sha256.New()
+	if _, err := io.Copy(bh, r); err != nil {
+		return "", fmt.Errorf("failed to hash the body: %w", err)
+	}
+	h.Write(bh.Sum(nil))
+	return "api-response:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// header returns the value of the header of the API, the names are case-insensitive
+func (c *API) header(name string) string {
+	for k, v := range c.Headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// get returns the cached response, nil if there is none
+func (rc *responseCache) get(ctx context.Context) *cachedResponse {
+	val, err := rc.backend.Get(ctx, rc.key)
+	if err != nil {
+		return nil
+	}
+	var data []byte
+	switch v := val.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return nil
+	}
+	entry := &cachedResponse{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil
+	}
+	return entry
+}
+
+// set stores the response, the stale responses with a validator are kept for the revalidation
+func (rc *responseCache) set(ctx context.Context, entry *cachedResponse) {
+	ttl := time.Until(entry.Expires)
+	if entry.validators() != nil {
+		ttl += rc.conf.staleTTL()
+	}
+	if ttl <= 0 {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	_ = rc.backend.SetWithTTL(ctx, rc.key, data, ttl)
+}
+
+// store reads the body of the successful response and caches it if the response allows it.
+// The body is replaced by the data read, followed by the rest of the body if it is too large.
+func (rc *responseCache) store(ctx context.Context, resp *http.Response) {
+	now := time.Now()
+	expires, ok := rc.expires(resp.Header, now)
+	if !ok {
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, rc.conf.maxBodySize()+1))
+	resp.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(data), resp.Body), Closer: resp.Body}
+	if err != nil || int64(len(data)) > rc.conf.maxBodySize() {
+		return
+	}
+	header := resp.Header.Clone()
+	header.Del(CacheStatusHeader)
+	rc.set(ctx, &cachedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     header,
+		Body:       data,
+		StoredAt:   now,
+		Expires:    expires,
+	})
+}
+
+// revalidated refreshes the cached response with the not modified response
+func (rc *responseCache) revalidated(ctx context.Context, entry *cachedResponse, resp *http.Response) {
+	// the not modified response updates the cached headers, except the ones describing the body
+	for k, v := range resp.Header {
+		switch k {
+		case "Content-Length", "Content-Type", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		entry.Header[k] = v
+	}
+	now := time.Now()
+	expires, ok := rc.expires(entry.Header, now)
+	if !ok {
+		expires = now
+	}
+	entry.StoredAt, entry.Expires = now, expires
+	rc.set(ctx, entry)
+}
+
+// expires returns the expiry time of the response, false if it must not be stored.
+// A response with no-cache is stored expired, to be revalidated on each call.
+func (rc *responseCache) expires(header http.Header, now time.Time) (time.Time, bool) {
+	if rc.conf.IgnoreCacheControl {
+		return now.Add(rc.conf.TTL), rc.conf.TTL > 0
+	}
+	directives := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return now, true
+	}
+	if v, ok := directives["max-age"]; ok {
+		if age, err := strconv.Atoi(v); err == nil && age >= 0 {
+			return now.Add(time.Duration(age) * time.Second), true
+		}
+	}
+	if v := header.Get("Expires"); v != "" {
+		// an invalid date, e.g. 0, means already expired
+		t, err := http.ParseTime(v)
+		if err != nil || t.Before(now) {
+			return now, true
+		}
+		return t, true
+	}
+	return now.Add(rc.conf.TTL), rc.conf.TTL > 0
+}
+
+// parseCacheControl returns the lowercase directives of the Cache-Control header with their values
+func parseCacheControl(value string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(val, `"`)
+	}
+	return directives
+}
+
+// readCloser reads the reader and closes the closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
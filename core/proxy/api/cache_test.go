@@ -0,0 +1,174 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/telepair/telepair/pkg/cache"
+)
+
+func doCached(t *testing.T, api *API, backend cache.Cache) (string, string) {
+	t.Helper()
+	resp, err := api.Do(WithCache(backend))
+	if !assert.NoError(t, err) {
+		return "", ""
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.Header.Get(CacheStatusHeader), string(body)
+}
+
+func TestCacheConfig_Parse(t *testing.T) {
+	assert.NoError(t, (&CacheConfig{TTL: time.Minute, Methods: []string{"get", "POST"}}).Parse())
+	assert.Error(t, (&CacheConfig{TTL: -time.Second}).Parse())
+	assert.Error(t, (&CacheConfig{Methods: []string{"FETCH"}}).Parse())
+}
+
+func TestAPI_DoWithCache(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		_, _ = io.WriteString(w, "lang="+r.Header.Get("Accept-Language"))
+	}))
+	defer ts.Close()
+
+	api := &API{
+		Method:  "GET",
+		URL:     ts.URL,
+		Headers: map[string]string{"Accept-Language": "en"},
+		Config:  Config{Cache: &CacheConfig{TTL: time.Minute, Headers: []string{"accept-language"}}},
+	}
+	assert.NoError(t, api.Parse())
+	backend := cache.NewMemory("test-api-response")
+
+	status, body := doCached(t, api, backend)
+	assert.Equal(t, CacheMiss, status)
+	assert.Equal(t, "lang=en", body)
+	status, body = doCached(t, api, backend)
+	assert.Equal(t, CacheHit, status)
+	assert.Equal(t, "lang=en", body)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// the selected headers are in the key
+	api.Headers["Accept-Language"] = "fr"
+	status, body = doCached(t, api, backend)
+	assert.Equal(t, CacheMiss, status)
+	assert.Equal(t, "lang=fr", body)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// the streamed responses bypass the cache
+	resp, err := api.Do(WithCache(backend), WithStream())
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Empty(t, resp.Header.Get(CacheStatusHeader))
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestAPI_DoWithCacheAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "user of "+r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	backend := cache.NewMemory("test-api-response-auth")
+	newAPI := func(token string) *API {
+		api := &API{
+			Method: "GET",
+			URL:    ts.URL,
+			Config: Config{
+				Cache: &CacheConfig{TTL: time.Minute},
+				Auth:  Auth{Type: AuthTypeBearer, Token: token},
+			},
+		}
+		assert.NoError(t, api.Parse())
+		return api
+	}
+
+	// the callers with different tokens of the same url do not share the responses
+	status, body := doCached(t, newAPI("token-a"), backend)
+	assert.Equal(t, CacheMiss, status)
+	assert.Equal(t, "user of Bearer token-a", body)
+	status, body = doCached(t, newAPI("token-b"), backend)
+	assert.Equal(t, CacheMiss, status)
+	assert.Equal(t, "user of Bearer token-b", body)
+	status, body = doCached(t, newAPI("token-a"), backend)
+	assert.Equal(t, CacheHit, status)
+	assert.Equal(t, "user of Bearer token-a", body)
+}
+
+func TestAPI_DoWithCacheControl(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/max-age":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+		_, _ = io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer ts.Close()
+
+	backend := cache.NewMemory("test-api-cache-control")
+	tests := []struct {
+		path       string
+		wantStatus []string
+		wantCalls  int32
+	}{
+		{path: "/no-store", wantStatus: []string{CacheMiss, CacheMiss}, wantCalls: 2},
+		{path: "/etag", wantStatus: []string{CacheMiss, CacheRevalidated, CacheRevalidated}, wantCalls: 3},
+		// the max-age is used without the ttl of the config
+		{path: "/max-age", wantStatus: []string{CacheMiss, CacheHit}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			calls.Store(0)
+			api := &API{Method: "GET", URL: ts.URL + tt.path, Config: Config{Cache: &CacheConfig{}}}
+			assert.NoError(t, api.Parse())
+			for _, want := range tt.wantStatus {
+				status, body := doCached(t, api, backend)
+				assert.Equal(t, want, status)
+				assert.Equal(t, "body of "+tt.path, body)
+			}
+			assert.Equal(t, tt.wantCalls, calls.Load())
+		})
+	}
+}
+
+func TestResponseCache_Expires(t *testing.T) {
+	now := time.Now()
+	rc := &responseCache{conf: &CacheConfig{TTL: time.Minute}}
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Time
+		wantOK bool
+	}{
+		{name: "ttl", header: http.Header{}, want: now.Add(time.Minute), wantOK: true},
+		{name: "max-age", header: http.Header{"Cache-Control": {"max-age=5"}}, want: now.Add(5 * time.Second), wantOK: true},
+		{name: "no-cache", header: http.Header{"Cache-Control": {"No-Cache"}}, want: now, wantOK: true},
+		{name: "no-store", header: http.Header{"Cache-Control": {"private, no-store"}}},
+		{name: "invalid expires", header: http.Header{"Expires": {"0"}}, want: now, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := rc.expires(tt.header, now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.True(t, tt.want.Equal(got), "expires %s, want %s", got, tt.want)
+		})
+	}
+}
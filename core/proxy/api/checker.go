@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"github.com/telepair/telepair/pkg/utils"
+)
+
+// MatchOp is the operator of the body match
+type MatchOp string
+
+const (
+	MatchOpEqual     MatchOp = "eq"
+	MatchOpNotEqual  MatchOp = "ne"
+	MatchOpExists    MatchOp = "exists"
+	MatchOpNotExists MatchOp = "not_exists"
+	MatchOpContains  MatchOp = "contains"
+	MatchOpRegex     MatchOp = "regex"
+	MatchOpGT        MatchOp = "gt"
+	MatchOpGTE       MatchOp = "gte"
+	MatchOpLT        MatchOp = "lt"
+	MatchOpLTE       MatchOp = "lte"
+)
+
+// BodyMatch is a body assertion for API checker.
+// If JSONPath is empty, the operator is applied to the whole body.
+// Example:
+//
+//	body_match:
+//	  - json_path: $.ok
+//	    op: eq
+//	    value: "true"
+//	  - json_path: $.data.count
+//	    op: gt
+//	    value: "0"
+//	  - op: regex
+//	    value: "^\\d+\\.\\d+\\.\\d+\\.\\d+$"
+type BodyMatch struct {
+	JSONPath string  `yaml:"json_path,omitempty" json:"json_path,omitempty"`
+	Op       MatchOp `yaml:"op,omitempty" json:"op,omitempty"`
+	Value    string  `yaml:"value,omitempty" json:"value,omitempty"`
+
+	// re is the compiled regex of the regex op
+	re *regexp.Regexp
+}
+
+// Parse parses the body match
+func (m *BodyMatch) Parse() error {
+	m.Op = MatchOp(strings.ToLower(strings.TrimSpace(string(m.Op))))
+	if m.Op == "" {
+		if m.Value != "" {
+			m.Op = MatchOpEqual
+		} else {
+			m.Op = MatchOpExists
+		}
+	}
+
+	switch m.Op {
+	case MatchOpEqual, MatchOpNotEqual, MatchOpExists, MatchOpNotExists, MatchOpContains:
+	case MatchOpRegex:
+		re, err := regexp.Compile(m.Value)
+		if err != nil {
+			return fmt.Errorf("body match regex %q is invalid: %w", m.Value, err)
+		}
+		m.re = re
+	case MatchOpGT, MatchOpGTE, MatchOpLT, MatchOpLTE:
+		if _, err := strconv.ParseFloat(m.Value, 64); err != nil {
+			return fmt.Errorf("body match value %q is not a number", m.Value)
+		}
+	default:
+		return fmt.Errorf("body match op %s is invalid", m.Op)
+	}
+	return nil
+}
+
+// Match checks if the body matches
+func (m *BodyMatch) Match(body []byte) bool {
+	val, found := m.lookup(body)
+	switch m.Op {
+	case MatchOpExists:
+		return found
+	case MatchOpNotExists:
+		return !found
+	}
+	if !found {
+		return false
+	}
+
+	str := stringify(val)
+	switch m.Op {
+	case MatchOpEqual:
+		return equalValue(val, str, m.Value)
+	case MatchOpNotEqual:
+		return !equalValue(val, str, m.Value)
+	case MatchOpContains:
+		return strings.Contains(str, m.Value)
+	case MatchOpRegex:
+		re := m.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(m.Value); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(str)
+	case MatchOpGT, MatchOpGTE, MatchOpLT, MatchOpLTE:
+		return compareNumber(m.Op, val, m.Value)
+	default:
+		return false
+	}
+}
+
+// lookup returns the value to match, the whole body if JSONPath is empty
+func (m *BodyMatch) lookup(body []byte) (any, bool) {
+	if m.JSONPath == "" {
+		return string(body), len(body) > 0
+	}
+	var data any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, false
+	}
+	val, err := utils.JSONPath(data, m.JSONPath)
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// Parse parses the checker
+func (c *Checker) Parse() error {
+	for i := range c.BodyMatch {
+		if err := c.BodyMatch[i].Parse(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchBody checks if the response body matches all body assertions.
+// The response body is read and replaced, so it can still be consumed by the caller.
+func (c *Checker) MatchBody(resp *http.Response) bool {
+	if len(c.BodyMatch) == 0 {
+		return true
+	}
+	body, err := peekBody(resp)
+	if err != nil {
+		return false
+	}
+	for i := range c.BodyMatch {
+		if !c.BodyMatch[i].Match(body) {
+			return false
+		}
+	}
+	return true
+}
+
+// peekBody reads the response body and resets it for later reads
+func peekBody(resp *http.Response) ([]byte, error) {
+	if resp == nil || resp.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}
+
+// stringify converts a decoded JSON value to string
+func stringify(val any) string {
+	switch v := val.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+func equalValue(val any, str, expected string) bool {
+	if n, ok := val.(float64); ok {
+		if e, err := strconv.ParseFloat(expected, 64); err == nil {
+			return n == e
+		}
+	}
+	return str == expected
+}
+
+func compareNumber(op MatchOp, val any, expected string) bool {
+	n, err := cast.ToFloat64E(val)
+	if err != nil {
+		return false
+	}
+	e, err := strconv.ParseFloat(expected, 64)
+	if err != nil {
+		return false
+	}
+	switch op {
+	case MatchOpGT:
+		return n > e
+	case MatchOpGTE:
+		return n >= e
+	case MatchOpLT:
+		return n < e
+	case MatchOpLTE:
+		return n <= e
+	default:
+		return false
+	}
+}
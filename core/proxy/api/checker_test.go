@@ -0,0 +1,137 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyMatch_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       BodyMatch
+		wantOp  MatchOp
+		wantErr bool
+	}{
+		{name: "default eq", m: BodyMatch{JSONPath: "$.ok", Value: "true"}, wantOp: MatchOpEqual},
+		{name: "default exists", m: BodyMatch{JSONPath: "$.ok"}, wantOp: MatchOpExists},
+		{name: "upper case op", m: BodyMatch{Op: " GT ", Value: "1"}, wantOp: MatchOpGT},
+		{name: "regex", m: BodyMatch{Op: MatchOpRegex, Value: `^\d+$`}, wantOp: MatchOpRegex},
+		{name: "invalid op", m: BodyMatch{Op: "like"}, wantErr: true},
+		{name: "invalid regex", m: BodyMatch{Op: MatchOpRegex, Value: "("}, wantErr: true},
+		{name: "invalid number", m: BodyMatch{Op: MatchOpLT, Value: "abc"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.m.Parse()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOp, tt.m.Op)
+			assert.Equal(t, tt.m.Op == MatchOpRegex, tt.m.re != nil, "the regex is compiled once")
+		})
+	}
+}
+
+func TestBodyMatch_Match(t *testing.T) {
+	body := []byte(`{"ok": true, "count": 3, "msg": "hello world", "data": {"id": "abc"}, "empty": null}`)
+
+	tests := []struct {
+		name string
+		m    BodyMatch
+		want bool
+	}{
+		{name: "eq bool", m: BodyMatch{JSONPath: "$.ok", Op: MatchOpEqual, Value: "true"}, want: true},
+		{name: "eq bool mismatch", m: BodyMatch{JSONPath: "$.ok", Op: MatchOpEqual, Value: "false"}},
+		{name: "eq number", m: BodyMatch{JSONPath: "$.count", Op: MatchOpEqual, Value: "3.0"}, want: true},
+		{name: "ne", m: BodyMatch{JSONPath: "$.data.id", Op: MatchOpNotEqual, Value: "xyz"}, want: true},
+		{name: "eq null", m: BodyMatch{JSONPath: "$.empty", Op: MatchOpEqual, Value: "null"}, want: true},
+		{name: "exists", m: BodyMatch{JSONPath: "$.data.id", Op: MatchOpExists}, want: true},
+		{name: "exists missing", m: BodyMatch{JSONPath: "$.data.name", Op: MatchOpExists}},
+		{name: "not exists", m: BodyMatch{JSONPath: "$.error", Op: MatchOpNotExists}, want: true},
+		{name: "contains path", m: BodyMatch{JSONPath: "$.msg", Op: MatchOpContains, Value: "world"}, want: true},
+		{name: "contains body", m: BodyMatch{Op: MatchOpContains, Value: `"ok": true`}, want: true},
+		{name: "regex", m: BodyMatch{JSONPath: "$.data.id", Op: MatchOpRegex, Value: "^[a-c]+$"}, want: true},
+		{name: "regex mismatch", m: BodyMatch{JSONPath: "$.msg", Op: MatchOpRegex, Value: "^world"}},
+		{name: "gt", m: BodyMatch{JSONPath: "$.count", Op: MatchOpGT, Value: "2"}, want: true},
+		{name: "gte", m: BodyMatch{JSONPath: "$.count", Op: MatchOpGTE, Value: "3"}, want: true},
+		{name: "lt", m: BodyMatch{JSONPath: "$.count", Op: MatchOpLT, Value: "3"}},
+		{name: "lte", m: BodyMatch{JSONPath: "$.count", Op: MatchOpLTE, Value: "3"}, want: true},
+		{name: "compare not a number", m: BodyMatch{JSONPath: "$.msg", Op: MatchOpGT, Value: "1"}},
+		{name: "missing path", m: BodyMatch{JSONPath: "$.missing", Op: MatchOpEqual, Value: "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.m.Match(body))
+		})
+	}
+
+	m := BodyMatch{JSONPath: "$.ok", Op: MatchOpExists}
+	assert.False(t, m.Match([]byte("not json")))
+}
+
+func TestChecker_MatchBody(t *testing.T) {
+	c := Checker{
+		BodyMatch: []BodyMatch{
+			{JSONPath: "$.ok", Value: "true"},
+		},
+	}
+	assert.NoError(t, c.Parse())
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"ok": true}`)),
+	}
+	assert.True(t, c.MatchBody(resp))
+
+	// the body can still be read after matching
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ok": true}`, string(body))
+
+	resp.Body = io.NopCloser(strings.NewReader(`{"ok": false}`))
+	assert.False(t, c.MatchBody(resp))
+
+	assert.True(t, (&Checker{}).MatchBody(&http.Response{}))
+}
+
+func TestAPI_BodyMatchFallback(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": false}`))
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	defer good.Close()
+
+	api := API{
+		Method: http.MethodGet,
+		URLs:   []string{bad.URL, good.URL},
+		Config: Config{
+			Checker: Checker{
+				BodyMatch: []BodyMatch{{JSONPath: "$.ok", Value: "true"}},
+			},
+		},
+	}
+	assert.NoError(t, api.Parse())
+	resp, err := api.Do()
+	assert.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ok": true}`, string(body))
+
+	api.URLs = []string{bad.URL}
+	api.URL = bad.URL
+	resp, err = api.Do()
+	assert.Error(t, err)
+	assert.NotNil(t, resp)
+}
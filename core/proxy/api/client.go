@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/telepair/telepair/pkg/cache"
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+var (
+	// apiClients caches the http clients by the config, shared by the APIs with the same config
+	apiClients = cache.NewMemory("api-client")
+	// apiClientsLock serializes the creation of the clients, so concurrent calls share them
+	apiClientsLock sync.Mutex
+)
+
+// TLSConfig is the TLS config of the API
+type TLSConfig struct {
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify,omitempty" json:"insecure_skip_verify,omitempty"`
+	// CertFile and KeyFile are the PEM client certificate and key for the mutual TLS
+	CertFile string `yaml:"cert_file,omitempty" json:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty" json:"key_file,omitempty"`
+	// CAFiles are the PEM files or directories of the root CAs, instead of the system ones
+	CAFiles []string `yaml:"ca_files,omitempty" json:"ca_files,omitempty"`
+	// ServerName overrides the SNI and the name verified in the server certificate
+	ServerName string `yaml:"server_name,omitempty" json:"server_name,omitempty"`
+	// MinVersion is the minimum TLS version, e.g. 1.2 or 1.3
+	MinVersion string `yaml:"min_version,omitempty" json:"min_version,omitempty"`
+	// PinnedSPKI are the base64 SHA-256 of the accepted server public keys
+	PinnedSPKI []string `yaml:"pinned_spki,omitempty" json:"pinned_spki,omitempty"`
+}
+
+// IsZero reports whether the TLS config is the default one
+func (t TLSConfig) IsZero() bool {
+	return !t.InsecureSkipVerify && t.CertFile == "" && t.KeyFile == "" && len(t.CAFiles) == 0 &&
+		t.ServerName == "" && t.MinVersion == "" && len(t.PinnedSPKI) == 0
+}
+
+// Parse validates the TLS config
+func (t TLSConfig) Parse() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return errors.New("tls cert_file and key_file must be set together")
+	}
+	if t.MinVersion != "" {
+		if _, err := httpclient.ParseTLSVersion(t.MinVersion); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options returns the client options of the TLS config, the certificate files are loaded
+func (t TLSConfig) options() ([]httpclient.Option, error) {
+	opts := []httpclient.Option{
+		httpclient.WithSkipTLSVerify(t.InsecureSkipVerify),
+		httpclient.WithServerName(t.ServerName),
+		httpclient.WithPinnedSPKI(t.PinnedSPKI...),
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		opts = append(opts, httpclient.WithClientCertificates(cert))
+	}
+	if len(t.CAFiles) > 0 {
+		pool, err := httpclient.LoadCertPool(t.CAFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("load root CAs: %w", err)
+		}
+		opts = append(opts, httpclient.WithRootCAs(pool))
+	}
+	if t.MinVersion != "" {
+		version, err := httpclient.ParseTLSVersion(t.MinVersion)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, httpclient.WithMinTLSVersion(version))
+	}
+	return opts, nil
+}
+
+// clientKey is the cache key of the http client, the APIs with the same key share the
+// client, its circuit breakers and its rate limiters per host
+type clientKey struct {
+	NoRetry        bool                        `json:"no_retry,omitempty"`
+	TLS            TLSConfig                   `json:"tls"`
+	CircuitBreaker *httpclient.BreakerConfig   `json:"circuit_breaker,omitempty"`
+	Proxy          *httpclient.ProxyConfig     `json:"proxy,omitempty"`
+	RateLimit      *httpclient.RateLimitConfig `json:"rate_limit,omitempty"`
+}
+
+// client returns the http client for the config, nil for the default client.
+// The client of the fallback urls does not retry, the next url is tried instead.
+func (c *Config) client(fallback bool) (httpclient.Client, error) {
+	rateLimit := c.hostRateLimit()
+	if c.TLS.IsZero() && c.CircuitBreaker == nil && c.Proxy == nil && rateLimit == nil {
+		return nil, nil
+	}
+	key, err := json.Marshal(clientKey{NoRetry: fallback, TLS: c.TLS, CircuitBreaker: c.CircuitBreaker, Proxy: c.Proxy, RateLimit: rateLimit})
+	if err != nil {
+		return nil, err
+	}
+	apiClientsLock.Lock()
+	val, err := apiClients.Get(context.Background(), string(key), cache.WithGetter(func(_ context.Context, _ string) (any, error) {
+		opts, err := c.ClientOptions()
+		if err != nil {
+			return nil, err
+		}
+		if fallback {
+			opts = append(opts, httpclient.WithRetry(0, time.Second, time.Second))
+		}
+		return httpclient.New(opts...), nil
+	}))
+	apiClientsLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return val.(httpclient.Client), nil
+}
+
+// ClientOptions returns the http client options of the config: the TLS options,
+// the circuit breaker, the proxy and the rate limit per host
+func (c *Config) ClientOptions() ([]httpclient.Option, error) {
+	opts, err := c.TLS.options()
+	if err != nil {
+		return nil, err
+	}
+	if c.CircuitBreaker != nil {
+		opts = append(opts, httpclient.WithCircuitBreaker(*c.CircuitBreaker))
+	}
+	if c.Proxy != nil {
+		proxy, err := c.Proxy.ProxyFunc()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, httpclient.WithProxy(proxy))
+	}
+	if rateLimit := c.hostRateLimit(); rateLimit != nil {
+		opts = append(opts, httpclient.WithRateLimit(*rateLimit))
+	}
+	return opts, nil
+}
+
+// hostRateLimit returns the rate limit applied by the client per host, nil if there is none
+func (c *Config) hostRateLimit() *httpclient.RateLimitConfig {
+	if c.RateLimit != nil && c.RateLimit.Scope == RateLimitScopeHost {
+		return &c.RateLimit.RateLimitConfig
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+package api
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+// ParseCurl parses the curl command into the API. The supported options are:
+//
+//	-X, --request          method, POST if there is data, GET otherwise
+//	-H, --header           header, `-H 'Name;'` sets an empty header
+//	-d, --data, --data-ascii, --data-binary, --data-raw, --data-urlencode
+//	                       body, `@file` reads the body from the file except for --data-raw,
+//	                       multiple data are joined with `&`
+//	--json                 JSON body with the JSON content type and accept headers
+//	-F, --form, --form-string
+//	                       multipart form part, `name=value`, `name=@file;type=...;filename=...`
+//	                       streams the file and `name=<file` sends the file content as the value
+//	-T, --upload-file      file streamed as the body, PUT by default
+//	-G, --get              send the data in the query with GET
+//	-I, --head             HEAD method
+//	-u, --user             basic auth, `user:password`
+//	-A, --user-agent       User-Agent header
+//	-e, --referer          Referer header
+//	-b, --cookie           Cookie header
+//	-k, --insecure         skip the TLS verification
+//	-E, --cert, --key      client certificate and key files for the mutual TLS
+//	--cacert, --capath     root CA file and directory
+//	--pinnedpubkey         pinned public keys, `sha256//` hashes separated by `;`
+//	--tlsv1.0 ... --tlsv1.3
+//	                       minimum TLS version
+//	-m, --max-time         timeout in seconds
+//	-x, --proxy            proxy URL, http, https, socks5 or socks5h
+//	--noproxy              hosts connected without the proxy, separated by `,`
+//	--url                  URL
+//
+// --compressed, -L, -s, -S, -v, -i and --location are accepted and ignored, the client
+// negotiates gzip and follows redirects by default.
+func ParseCurl(command string) (API, error) {
+	args, err := splitShellWords(command)
+	if err != nil {
+		return API{}, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return API{}, errors.New("curl command must start with curl")
+	}
+
+	api := API{Name: "curl", Headers: map[string]string{}}
+	var (
+		data    []string
+		form    []httpclient.MultipartPart
+		upload  string
+		getData bool
+		head    bool
+	)
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		name, value, inline := splitCurlOption(arg)
+		if slices.Contains(curlValueOptions, name) && !inline {
+			if i+1 >= len(args) {
+				return api, fmt.Errorf("option %s requires a value", arg)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "-X", "--request":
+			api.Method = value
+		case "-H", "--header":
+			header := value
+			key, val, ok := strings.Cut(header, ":")
+			if !ok {
+				// `Name;` sends the header with an empty value
+				key, ok = strings.CutSuffix(header, ";")
+				if !ok {
+					return api, fmt.Errorf("header %q is invalid", header)
+				}
+			}
+			api.Headers[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		case "-d", "--data", "--data-ascii", "--data-binary", "--data-raw", "--data-urlencode", "--json":
+			val := value
+			if name != "--data-raw" && strings.HasPrefix(val, "@") {
+				content, err := os.ReadFile(strings.TrimPrefix(val, "@"))
+				if err != nil {
+					return api, fmt.Errorf("failed to read data file: %w", err)
+				}
+				val = string(content)
+				if name == "-d" || name == "--data" || name == "--data-ascii" {
+					val = strings.NewReplacer("\r", "", "\n", "").Replace(val)
+				}
+			}
+			if name == "--data-urlencode" {
+				val = urlEncodeData(val)
+			}
+			if name == "--json" {
+				setDefaultHeader(api.Headers, "Content-Type", MediaTypeJSON)
+				setDefaultHeader(api.Headers, "Accept", MediaTypeJSON)
+			}
+			data = append(data, val)
+		case "-F", "--form", "--form-string":
+			part, err := parseCurlFormPart(value, name == "--form-string")
+			if err != nil {
+				return api, err
+			}
+			form = append(form, part)
+		case "-T", "--upload-file":
+			upload = value
+		case "-G", "--get":
+			getData = true
+		case "-I", "--head":
+			head = true
+		case "-u", "--user":
+			username, password, _ := strings.Cut(value, ":")
+			api.Config.Auth = Auth{Type: AuthTypeBasic, Username: username, Password: password}
+		case "-A", "--user-agent":
+			api.Headers["User-Agent"] = value
+		case "-e", "--referer":
+			api.Headers["Referer"] = value
+		case "-b", "--cookie":
+			api.Headers["Cookie"] = value
+		case "-k", "--insecure":
+			api.Config.TLS.InsecureSkipVerify = true
+		case "-E", "--cert":
+			api.Config.TLS.CertFile = value
+		case "--key":
+			api.Config.TLS.KeyFile = value
+		case "--cacert", "--capath":
+			api.Config.TLS.CAFiles = append(api.Config.TLS.CAFiles, value)
+		case "--pinnedpubkey":
+			api.Config.TLS.PinnedSPKI = append(api.Config.TLS.PinnedSPKI, strings.Split(value, ";")...)
+		case "--tlsv1.0", "--tlsv1.1", "--tlsv1.2", "--tlsv1.3":
+			api.Config.TLS.MinVersion = strings.TrimPrefix(name, "--tlsv")
+		case "-m", "--max-time":
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds <= 0 {
+				return api, fmt.Errorf("max time %s is invalid", value)
+			}
+			api.Config.Timeout = time.Duration(seconds * float64(time.Second))
+		case "-x", "--proxy":
+			proxy := value
+			if !strings.Contains(proxy, "://") {
+				proxy = "http://" + proxy
+			}
+			if api.Config.Proxy == nil {
+				api.Config.Proxy = &httpclient.ProxyConfig{}
+			}
+			api.Config.Proxy.URL = proxy
+		case "--noproxy":
+			if api.Config.Proxy == nil {
+				api.Config.Proxy = &httpclient.ProxyConfig{}
+			}
+			api.Config.Proxy.NoProxy = append(api.Config.Proxy.NoProxy, strings.Split(value, ",")...)
+		case "--url":
+			api.URL = value
+		case "--compressed", "-L", "--location", "-s", "--silent", "-S", "--show-error", "-v", "--verbose", "-i", "--include":
+		default:
+			if strings.HasPrefix(arg, "-") && arg != "-" {
+				return api, fmt.Errorf("curl option %s is not supported", arg)
+			}
+			if api.URL != "" {
+				return api, fmt.Errorf("only one url is supported, got %s and %s", api.URL, arg)
+			}
+			api.URL = arg
+		}
+	}
+
+	if api.URL == "" {
+		return api, errors.New("curl url is required")
+	}
+	if !strings.Contains(api.URL, "://") {
+		api.URL = "http://" + api.URL
+	}
+	if (len(form) > 0 || upload != "") && (len(data) > 0 || getData) {
+		return api, errors.New("form and upload file can not be used with data")
+	}
+	body := strings.Join(data, "&")
+	switch {
+	case len(form) > 0:
+		api.BodySource = &BodySource{Multipart: form}
+		if api.Method == "" {
+			api.Method = http.MethodPost
+		}
+	case upload != "":
+		api.BodySource = &BodySource{File: upload}
+		// as curl, the file name is appended to the url ending with a slash
+		if strings.HasSuffix(api.URL, "/") {
+			api.URL += url.PathEscape(filepath.Base(upload))
+		}
+		if api.Method == "" {
+			api.Method = http.MethodPut
+		}
+	case head:
+		api.Method = http.MethodHead
+	case getData:
+		if body != "" {
+			sep := "?"
+			if strings.Contains(api.URL, "?") {
+				sep = "&"
+			}
+			api.URL += sep + body
+		}
+		api.Method = http.MethodGet
+	default:
+		api.Body = body
+		if len(data) > 0 {
+			setDefaultHeader(api.Headers, "Content-Type", "application/x-www-form-urlencoded")
+			if api.Method == "" {
+				api.Method = http.MethodPost
+			}
+		}
+	}
+	if api.Method == "" {
+		api.Method = http.MethodGet
+	}
+	if len(api.Headers) == 0 {
+		api.Headers = nil
+	}
+	return api, api.Parse()
+}
+
+// ToCurl returns the curl command of the API. The first URL is used if the API has
+// fallback URLs. Basic and bearer credentials are included, other auth types are not.
+// The proxy rules and the SNI override have no curl equivalent and are not included.
+func (c *API) ToCurl() string {
+	u := c.URL
+	if u == "" && len(c.URLs) > 0 {
+		u = c.URLs[0]
+	}
+	method := strings.ToUpper(c.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	parts := []string{"curl"}
+	switch {
+	case method == http.MethodHead:
+		parts = append(parts, "-I")
+	case method == http.MethodGet && c.Body == "" && c.BodySource == nil:
+	case method == http.MethodPost && (c.Body != "" || c.BodySource != nil && c.BodySource.File == ""):
+	case method == http.MethodPut && c.BodySource != nil && c.BodySource.File != "":
+	default:
+		parts = append(parts, "-X", method)
+	}
+	parts = append(parts, shellQuote(u))
+
+	keys := make([]string, 0, len(c.Headers))
+	for k := range c.Headers {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		header := k + ": " + c.Headers[k]
+		if c.Headers[k] == "" {
+			header = k + ";"
+		}
+		parts = append(parts, "-H", shellQuote(header))
+	}
+
+	switch c.Config.Auth.Type {
+	case AuthTypeBasic:
+		parts = append(parts, "-u", shellQuote(c.Config.Auth.Username+":"+c.Config.Auth.Password))
+	case AuthTypeBearer:
+		parts = append(parts, "-H", shellQuote("Authorization: Bearer "+c.Config.Auth.Token))
+	}
+	if c.Config.TLS.InsecureSkipVerify {
+		parts = append(parts, "-k")
+	}
+	if c.Config.TLS.CertFile != "" {
+		parts = append(parts, "--cert", shellQuote(c.Config.TLS.CertFile), "--key", shellQuote(c.Config.TLS.KeyFile))
+	}
+	for _, ca := range c.Config.TLS.CAFiles {
+		if info, err := os.Stat(ca); err == nil && info.IsDir() {
+			parts = append(parts, "--capath", shellQuote(ca))
+		} else {
+			parts = append(parts, "--cacert", shellQuote(ca))
+		}
+	}
+	if len(c.Config.TLS.PinnedSPKI) > 0 {
+		pins := make([]string, 0, len(c.Config.TLS.PinnedSPKI))
+		for _, pin := range c.Config.TLS.PinnedSPKI {
+			pins = append(pins, httpclient.SPKIPinPrefix+strings.TrimPrefix(pin, httpclient.SPKIPinPrefix))
+		}
+		parts = append(parts, "--pinnedpubkey", shellQuote(strings.Join(pins, ";")))
+	}
+	if version, err := httpclient.ParseTLSVersion(c.Config.TLS.MinVersion); err == nil {
+		parts = append(parts, "--tlsv"+strings.TrimPrefix(tls.VersionName(version), "TLS "))
+	}
+	if c.Config.Proxy != nil && c.Config.Proxy.URL != "" {
+		parts = append(parts, "-x", shellQuote(c.Config.Proxy.URL))
+		if len(c.Config.Proxy.NoProxy) > 0 {
+			parts = append(parts, "--noproxy", shellQuote(strings.Join(c.Config.Proxy.NoProxy, ",")))
+		}
+	}
+	if c.Config.Timeout > 0 && c.Config.Timeout != DefaultTimeout {
+		parts = append(parts, "-m", strconv.FormatFloat(c.Config.Timeout.Seconds(), 'f', -1, 64))
+	}
+	if c.Body != "" {
+		parts = append(parts, "--data-raw", shellQuote(c.Body))
+	}
+	if c.BodySource != nil {
+		parts = append(parts, c.BodySource.curlOptions()...)
+	}
+	return strings.Join(parts, " ")
+}
+
+// curlOptions returns the curl options of the body source, a reader has none
+func (s *BodySource) curlOptions() []string {
+	var parts []string
+	switch {
+	case s.File != "":
+		parts = append(parts, "-T", shellQuote(s.File))
+	case len(s.Form) > 0:
+		keys := make([]string, 0, len(s.Form))
+		for k := range s.Form {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			parts = append(parts, "--data-urlencode", shellQuote(k+"="+s.Form[k]))
+		}
+	case len(s.Multipart) > 0:
+		for _, p := range s.Multipart {
+			if p.File == "" {
+				parts = append(parts, "--form-string", shellQuote(p.Name+"="+p.Value))
+				continue
+			}
+			part := p.Name + "=@" + p.File
+			if p.ContentType != "" {
+				part += ";type=" + p.ContentType
+			}
+			if p.FileName != "" {
+				part += ";filename=" + p.FileName
+			}
+			parts = append(parts, "-F", shellQuote(part))
+		}
+	}
+	return parts
+}
+
+// parseCurlFormPart parses the -F `name=value`, `name=@file;type=...;filename=...`
+// and `name=<file` parts, the --form-string value is taken literally
+func parseCurlFormPart(val string, literal bool) (httpclient.MultipartPart, error) {
+	name, value, ok := strings.Cut(val, "=")
+	if !ok || name == "" {
+		return httpclient.MultipartPart{}, fmt.Errorf("form part %q is invalid", val)
+	}
+	part := httpclient.MultipartPart{Name: name, Value: value}
+	if literal {
+		return part, nil
+	}
+	switch {
+	case strings.HasPrefix(value, "@"):
+		attrs := strings.Split(strings.TrimPrefix(value, "@"), ";")
+		part.Value, part.File = "", attrs[0]
+		for _, attr := range attrs[1:] {
+			key, v, _ := strings.Cut(attr, "=")
+			switch strings.TrimSpace(key) {
+			case "type":
+				part.ContentType = strings.Trim(v, `"`)
+			case "filename":
+				part.FileName = strings.Trim(v, `"`)
+			}
+		}
+	case strings.HasPrefix(value, "<"):
+		content, err := os.ReadFile(strings.TrimPrefix(value, "<"))
+		if err != nil {
+			return part, fmt.Errorf("failed to read form file: %w", err)
+		}
+		part.Value = string(content)
+	}
+	return part, nil
+}
+
+// curlValueOptions are the options of ParseCurl taking a value
+var curlValueOptions = []string{
+	"-X", "--request", "-H", "--header",
+	"-d", "--data", "--data-ascii", "--data-binary", "--data-raw", "--data-urlencode", "--json",
+	"-F", "--form", "--form-string", "-T", "--upload-file",
+	"-u", "--user", "-A", "--user-agent", "-e", "--referer", "-b", "--cookie",
+	"-E", "--cert", "--key", "--cacert", "--capath", "--pinnedpubkey",
+	"-m", "--max-time", "-x", "--proxy", "--noproxy", "--url",
+}
+
+// splitCurlOption splits `--name=value` options and the short options taking a value, e.g. `-XPOST`
+func splitCurlOption(arg string) (name, value string, inline bool) {
+	if strings.HasPrefix(arg, "--") {
+		if name, value, ok := strings.Cut(arg, "="); ok {
+			return name, value, true
+		}
+		return arg, "", false
+	}
+	if strings.HasPrefix(arg, "-") && len(arg) > 2 && slices.Contains(curlValueOptions, arg[:2]) {
+		return arg[:2], arg[2:], true
+	}
+	return arg, "", false
+}
+
+// urlEncodeData encodes the --data-urlencode value: `content`, `=content` or `name=content`
+func urlEncodeData(val string) string {
+	name, content, ok := strings.Cut(val, "=")
+	if !ok {
+		return url.QueryEscape(val)
+	}
+	if name == "" {
+		return url.QueryEscape(content)
+	}
+	return name + "=" + url.QueryEscape(content)
+}
+
+func setDefaultHeader(headers map[string]string, key, val string) {
+	for k := range headers {
+		if strings.EqualFold(k, key) {
+			return
+		}
+	}
+	headers[key] = val
+}
+
+// splitShellWords splits the command like a POSIX shell, supporting single and
+// double quotes, backslash escapes and line continuations
+func splitShellWords(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+			if r == '\n' {
+				continue
+			}
+			if quote == '"' && !strings.ContainsRune("\"\\$`", r) {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			inWord = true
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			word.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote in command")
+	}
+	if escaped {
+		return nil, errors.New("unterminated escape in command")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// shellQuote quotes the string for POSIX shells
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@%+,", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
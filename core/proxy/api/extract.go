@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/telepair/telepair/pkg/httpclient"
+	"github.com/telepair/telepair/pkg/utils"
+)
+
+// MediaTypeJSON is the media type of the transformed response
+const MediaTypeJSON = "application/json"
+
+// ExtractSource is the source of the extracted value
+type ExtractSource string
+
+const (
+	ExtractSourceBody   ExtractSource = "body"
+	ExtractSourceHeader ExtractSource = "header"
+	ExtractSourceStatus ExtractSource = "status"
+)
+
+// Extract is a value extracted from the API response.
+// Example:
+//
+//	extract:
+//	  - name: ip
+//	    json_path: $.ip
+//	  - name: request_id
+//	    from: header
+//	    header: X-Request-ID
+//	  - name: version
+//	    regex: "version: (\\d+\\.\\d+)"
+type Extract struct {
+	Name     string        `yaml:"name" json:"name"`
+	From     ExtractSource `yaml:"from,omitempty" json:"from,omitempty"`
+	JSONPath string        `yaml:"json_path,omitempty" json:"json_path,omitempty"`
+	Header   string        `yaml:"header,omitempty" json:"header,omitempty"`
+	Regex    string        `yaml:"regex,omitempty" json:"regex,omitempty"`
+	Group    int           `yaml:"group,omitempty" json:"group,omitempty"`
+	Default  string        `yaml:"default,omitempty" json:"default,omitempty"`
+	Required bool          `yaml:"required,omitempty" json:"required,omitempty"`
+
+	// re is the compiled regex
+	re *regexp.Regexp
+}
+
+// Parse parses the extract
+func (e *Extract) Parse() error {
+	if e.Name == "" {
+		return errors.New("extract name is required")
+	}
+	e.From = ExtractSource(strings.ToLower(strings.TrimSpace(string(e.From))))
+	if e.From == "" {
+		if e.Header != "" {
+			e.From = ExtractSourceHeader
+		} else {
+			e.From = ExtractSourceBody
+		}
+	}
+
+	switch e.From {
+	case ExtractSourceBody:
+	case ExtractSourceHeader:
+		if e.Header == "" {
+			return fmt.Errorf("extract %s header is required", e.Name)
+		}
+	case ExtractSourceStatus:
+	default:
+		return fmt.Errorf("extract %s source %s is invalid", e.Name, e.From)
+	}
+
+	if e.Regex != "" {
+		re, err := regexp.Compile(e.Regex)
+		if err != nil {
+			return fmt.Errorf("extract %s regex is invalid: %w", e.Name, err)
+		}
+		if e.Group < 0 || e.Group > re.NumSubexp() {
+			return fmt.Errorf("extract %s regex group %d is out of range", e.Name, e.Group)
+		}
+		if e.Group == 0 && re.NumSubexp() > 0 {
+			e.Group = 1
+		}
+		e.re = re
+	}
+	return nil
+}
+
+// Value returns the extracted value from the response.
+// data is the decoded JSON body, it is only used for JSON path.
+func (e *Extract) Value(resp *http.Response, body []byte, data any) (any, error) {
+	val, found := e.lookup(resp, body, data)
+	if found && e.Regex != "" {
+		re := e.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(e.Regex); err != nil {
+				return nil, err
+			}
+		}
+		matches := re.FindStringSubmatch(stringify(val))
+		if len(matches) > e.Group {
+			val = matches[e.Group]
+		} else {
+			found = false
+		}
+	}
+
+	if !found {
+		if e.Required {
+			return nil, fmt.Errorf("extract %s not found", e.Name)
+		}
+		if e.Default != "" {
+			return e.Default, nil
+		}
+		return nil, nil
+	}
+	return val, nil
+}
+
+func (e *Extract) lookup(resp *http.Response, body []byte, data any) (any, bool) {
+	switch e.From {
+	case ExtractSourceHeader:
+		if _, ok := resp.Header[http.CanonicalHeaderKey(e.Header)]; !ok {
+			return nil, false
+		}
+		return resp.Header.Get(e.Header), true
+	case ExtractSourceStatus:
+		return resp.StatusCode, true
+	default:
+		if e.JSONPath == "" {
+			return string(body), true
+		}
+		if data == nil {
+			return nil, false
+		}
+		val, err := utils.JSONPath(data, e.JSONPath)
+		if err != nil {
+			return nil, false
+		}
+		return val, true
+	}
+}
+
+// ExtractValues extracts the values defined by the template from the response.
+// The response body is read and replaced, so it can still be consumed by the caller.
+func (t *Template) ExtractValues(resp *http.Response) (map[string]any, error) {
+	if resp == nil {
+		return nil, errors.New("response is nil")
+	}
+	body, err := peekBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var data any
+	for _, e := range t.Extract {
+		if e.From == ExtractSourceBody && e.JSONPath != "" {
+			if err := json.Unmarshal(body, &data); err != nil {
+				return nil, fmt.Errorf("failed to decode response body as json: %w", err)
+			}
+			break
+		}
+	}
+
+	values := make(map[string]any, len(t.Extract))
+	for i := range t.Extract {
+		val, err := t.Extract[i].Value(resp, body, data)
+		if err != nil {
+			return nil, err
+		}
+		values[t.Extract[i].Name] = val
+	}
+	return values, nil
+}
+
+// ParseResponse reads the response and returns the media type and body.
+// If the template defines extract, the body is a JSON document of the extracted
+// values, reshaped by the transform template if set.
+func (t *Template) ParseResponse(resp *http.Response) (mediaType string, body []byte, err error) {
+	if len(t.Extract) == 0 {
+		return httpclient.ParseResponse(resp)
+	}
+
+	values, err := t.ExtractValues(resp)
+	_ = resp.Body.Close()
+	if err != nil {
+		return "", nil, err
+	}
+	body, err = t.transform(values, utils.SimpleRender)
+	if err != nil {
+		return "", nil, err
+	}
+	return MediaTypeJSON, body, nil
+}
+
+// transform renders the transform template with the extracted values.
+// With the simple engine every value is encoded as JSON before rendering,
+// with the go engine the raw values are passed and the `json` filter applies.
+// The simple engine renders with simpleRender, e.g. utils.SimpleRender.
+// Example:
+//
+//	transform: '{"address": {{ ip }}, "location": {"city": {{ city }}}}'
+//	transform: '{"address": {{ .ip | json }}, "city": {{ .city | default "unknown" | json }}}'
+func (t *Template) transform(values map[string]any, simpleRender func(string, map[string]string) (string, error)) ([]byte, error) {
+	if t.Transform == "" {
+		return json.Marshal(values)
+	}
+
+	var out string
+	var err error
+	if t.Engine == utils.EngineGo {
+		out, err = utils.GoRender(t.Transform, values)
+	} else {
+		vars := make(map[string]string, len(values))
+		for k, v := range values {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode extract %s: %w", k, err)
+			}
+			vars[k] = string(data)
+		}
+		out, err = simpleRender(t.Transform, vars)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render transform: %w", err)
+	}
+	if !json.Valid([]byte(out)) {
+		return nil, fmt.Errorf("transform result is not valid json: %s", strconv.Quote(out))
+	}
+	return []byte(out), nil
+}
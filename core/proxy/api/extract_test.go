@@ -0,0 +1,206 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtract_Parse(t *testing.T) {
+	tests := []struct {
+		name      string
+		e         Extract
+		wantFrom  ExtractSource
+		wantGroup int
+		wantErr   bool
+	}{
+		{name: "default body", e: Extract{Name: "ip", JSONPath: "$.ip"}, wantFrom: ExtractSourceBody},
+		{name: "default header", e: Extract{Name: "rid", Header: "X-Request-ID"}, wantFrom: ExtractSourceHeader},
+		{name: "status", e: Extract{Name: "code", From: "STATUS"}, wantFrom: ExtractSourceStatus},
+		{name: "regex group", e: Extract{Name: "v", Regex: `v(\d+)`}, wantFrom: ExtractSourceBody, wantGroup: 1},
+		{name: "empty name", e: Extract{JSONPath: "$.ip"}, wantErr: true},
+		{name: "header without name", e: Extract{Name: "rid", From: ExtractSourceHeader}, wantErr: true},
+		{name: "invalid source", e: Extract{Name: "x", From: "cookie"}, wantErr: true},
+		{name: "invalid regex", e: Extract{Name: "x", Regex: "("}, wantErr: true},
+		{name: "group out of range", e: Extract{Name: "x", Regex: `(\d+)`, Group: 2}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.e.Parse()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFrom, tt.e.From)
+			assert.Equal(t, tt.wantGroup, tt.e.Group)
+			assert.Equal(t, tt.e.Regex != "", tt.e.re != nil, "the regex is compiled once")
+		})
+	}
+}
+
+func newExtractResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"X-Request-Id": []string{"rid-1"},
+		},
+		Body: io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestTemplate_ExtractValues(t *testing.T) {
+	tmpl := Template{
+		Name: "test",
+		API:  API{Method: http.MethodGet, URL: "http://example.com"},
+		Extract: []Extract{
+			{Name: "ip", JSONPath: "$.ip"},
+			{Name: "rid", Header: "X-Request-ID"},
+			{Name: "code", From: ExtractSourceStatus},
+			{Name: "major", JSONPath: "$.version", Regex: `^(\d+)\.`},
+			{Name: "city", JSONPath: "$.city", Default: "unknown"},
+			{Name: "country", JSONPath: "$.country"},
+		},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	resp := newExtractResponse(`{"ip": "1.2.3.4", "version": "12.3"}`)
+	values, err := tmpl.ExtractValues(resp)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]any{
+		"ip":      "1.2.3.4",
+		"rid":     "rid-1",
+		"code":    http.StatusOK,
+		"major":   "12",
+		"city":    "unknown",
+		"country": nil,
+	}, values)
+
+	tmpl.Extract = append(tmpl.Extract, Extract{Name: "token", JSONPath: "$.token", Required: true})
+	assert.NoError(t, tmpl.Parse())
+	_, err = tmpl.ExtractValues(newExtractResponse(`{"ip": "1.2.3.4"}`))
+	assert.Error(t, err)
+
+	_, err = tmpl.ExtractValues(newExtractResponse(`not json`))
+	assert.Error(t, err)
+}
+
+func TestTemplate_ParseResponse(t *testing.T) {
+	tests := []struct {
+		name          string
+		t             Template
+		body          string
+		wantMediaType string
+		wantBody      string
+		wantErr       bool
+	}{
+		{
+			name:          "raw body",
+			t:             Template{},
+			body:          `{"ip": "1.2.3.4"}`,
+			wantMediaType: "application/json",
+			wantBody:      `{"ip": "1.2.3.4"}`,
+		},
+		{
+			name: "extract only",
+			t: Template{
+				Extract: []Extract{{Name: "ip", JSONPath: "$.ip"}},
+			},
+			body:          `{"ip": "1.2.3.4", "city": "beijing"}`,
+			wantMediaType: MediaTypeJSON,
+			wantBody:      `{"ip":"1.2.3.4"}`,
+		},
+		{
+			name: "transform",
+			t: Template{
+				Extract: []Extract{
+					{Name: "ip", JSONPath: "$.ip"},
+					{Name: "city", JSONPath: "$.city"},
+				},
+				Transform: `{"address": {{ ip }}, "location": {"city": {{ city }}}}`,
+			},
+			body:          `{"ip": "1.2.3.4", "city": "bei\"jing"}`,
+			wantMediaType: MediaTypeJSON,
+			wantBody:      `{"address": "1.2.3.4", "location": {"city": "bei\"jing"}}`,
+		},
+		{
+			name: "invalid transform",
+			t: Template{
+				Extract:   []Extract{{Name: "ip", JSONPath: "$.ip"}},
+				Transform: `{"address": {{ ip }}`,
+			},
+			body:    `{"ip": "1.2.3.4"}`,
+			wantErr: true,
+		},
+		{
+			name: "transform with unknown value",
+			t: Template{
+				Extract:   []Extract{{Name: "ip", JSONPath: "$.ip"}},
+				Transform: `{"address": {{ addr }}}`,
+			},
+			body:    `{"ip": "1.2.3.4"}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.t.Extract {
+				assert.NoError(t, tt.t.Extract[i].Parse())
+			}
+			mediaType, body, err := tt.t.ParseResponse(newExtractResponse(tt.body))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMediaType, mediaType)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+}
+
+func TestTemplate_ParseExtract(t *testing.T) {
+	tmpl := Template{
+		Name:      "test",
+		API:       API{Method: http.MethodGet, URL: "http://example.com"},
+		Transform: `{"ip": {{ ip }}}`,
+	}
+	assert.Error(t, tmpl.Parse())
+
+	tmpl.Extract = []Extract{{Name: "ip", JSONPath: "$.ip"}, {Name: "ip", JSONPath: "$.addr"}}
+	assert.Error(t, tmpl.Parse())
+
+	tmpl.Extract = tmpl.Extract[:1]
+	assert.NoError(t, tmpl.Parse())
+}
+
+func TestDoTemplateParsed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"ip": "1.2.3.4"}`))
+	}))
+	defer server.Close()
+
+	err := RegisterTemplate(Template{
+		Name:      "test-do-template-parsed",
+		API:       API{Method: http.MethodGet, URL: server.URL},
+		Extract:   []Extract{{Name: "ip", JSONPath: "$.ip"}},
+		Transform: `{"address": {{ ip }}}`,
+	})
+	assert.NoError(t, err)
+
+	mediaType, body, err := DoTemplateParsed("test-do-template-parsed", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, MediaTypeJSON, mediaType)
+	assert.Equal(t, `{"address": "1.2.3.4"}`, string(body))
+
+	_, _, err = DoTemplateParsed("not-exists", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,662 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cast"
+
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+// MaxVarsBodySize is the max size of the json body of the gateway requests
+const MaxVarsBodySize = 1 << 20
+
+// hopHeaders are the hop-by-hop headers not copied from the upstream response
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+}
+
+// secretHeaderWords are the words of the header names whose values are masked in the returned APIs
+var secretHeaderWords = []string{"auth", "token", "key", "secret", "password", "cookie", "session", "signature"}
+
+// Gateway is the http handler that executes and manages the registered APIs and templates:
+//
+//	POST   /apis/{name}       executes the API
+//	POST   /templates/{name}  renders the template with the vars and executes it
+//
+// The management routes are only served with WithGatewayManagement, and the requests
+// must carry the token as `Authorization: Bearer <token>`:
+//
+//	GET    /apis              lists the APIs, and the same for /templates
+//	POST   /apis              registers the API in the json body
+//	GET    /apis/{name}       returns the API
+//	PUT    /apis/{name}       updates the API, `?version=` must be the current version if set
+//	DELETE /apis/{name}       unregisters the API
+//
+// The template vars are read from the query and the json object body,
+// the body wins on conflicts. The upstream response is streamed back,
+// unless the template defines extract, then the transformed document is returned.
+// The auth credentials and secret headers are masked in the returned APIs and templates,
+// the masked values of an update are restored from the current API or template.
+type Gateway struct {
+	mux      *http.ServeMux
+	registry *Registry
+	client   httpclient.Client
+	logger   *slog.Logger
+	token    string
+}
+
+// GatewayOption is a option for the gateway
+type GatewayOption func(*Gateway)
+
+// WithGatewayRegistry sets the registry of the gateway, DefaultRegistry by default
+func WithGatewayRegistry(registry *Registry) GatewayOption {
+	return func(g *Gateway) {
+		if registry != nil {
+			g.registry = registry
+		}
+	}
+}
+
+// WithGatewayClient sets the http client used to execute the APIs
+func WithGatewayClient(client httpclient.Client) GatewayOption {
+	return func(g *Gateway) {
+		g.client = client
+	}
+}
+
+// WithGatewayLogger sets the logger of the gateway
+func WithGatewayLogger(logger *slog.Logger) GatewayOption {
+	return func(g *Gateway) {
+		if logger != nil {
+			g.logger = logger
+		}
+	}
+}
+
+// WithGatewayManagement enables the management routes of the APIs and templates,
+// authorized by the bearer token. They are disabled if the token is empty.
+func WithGatewayManagement(token string) GatewayOption {
+	return func(g *Gateway) {
+		g.token = token
+	}
+}
+
+// NewGateway creates the gateway of the registered APIs and templates
+func NewGateway(opts ...GatewayOption) *Gateway {
+	g := &Gateway{
+		mux:      http.NewServeMux(),
+		registry: DefaultRegistry,
+		logger:   slog.With("component", "api/gateway"),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.mux.HandleFunc("POST /apis/{name}", g.handleAPI)
+	g.mux.HandleFunc("POST /templates/{name}", g.handleTemplate)
+	if g.token == "" {
+		return g
+	}
+
+	g.mux.HandleFunc("GET /apis", g.authorize(g.listAPIs))
+	g.mux.HandleFunc("POST /apis", g.authorize(g.createAPI))
+	g.mux.HandleFunc("GET /apis/{name}", g.authorize(g.getAPI))
+	g.mux.HandleFunc("PUT /apis/{name}", g.authorize(g.updateAPI))
+	g.mux.HandleFunc("DELETE /apis/{name}", g.authorize(g.deleteAPI))
+
+	g.mux.HandleFunc("GET /templates", g.authorize(g.listTemplates))
+	g.mux.HandleFunc("POST /templates", g.authorize(g.createTemplate))
+	g.mux.HandleFunc("GET /templates/{name}", g.authorize(g.getTemplate))
+	g.mux.HandleFunc("PUT /templates/{name}", g.authorize(g.updateTemplate))
+	g.mux.HandleFunc("DELETE /templates/{name}", g.authorize(g.deleteTemplate))
+	return g
+}
+
+// authorize checks the bearer token of the management requests
+func (g *Gateway) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(token), []byte(g.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *Gateway) handleAPI(w http.ResponseWriter, r *http.Request) {
+	entry, err := g.registry.GetAPI(r.PathValue("name"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	resp, err := entry.API.Do(g.options(r.Context())...)
+	g.writeResponse(w, "api", entry.API.Name, resp, err)
+}
+
+func (g *Gateway) handleTemplate(w http.ResponseWriter, r *http.Request) {
+	entry, err := g.registry.GetTemplate(r.PathValue("name"))
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	template := entry.Template
+	name := template.Name
+
+	vars, err := readVars(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	api, err := template.Render(vars)
+	if err != nil {
+		var varErrs VarErrors
+		if errors.As(err, &varErrs) {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error(), "vars": varErrs})
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	resp, err := api.Do(g.options(r.Context())...)
+	if err != nil || len(template.Extract) == 0 {
+		g.writeResponse(w, "api template", name, resp, err)
+		return
+	}
+	mediaType, body, err := template.ParseResponse(resp)
+	if err != nil {
+		g.logger.Error("failed to parse response", "template", name, "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.Header().Set("Content-Type", mediaType)
+	w.WriteHeader(resp.StatusCode)
+	_, _ = w.Write(body)
+}
+
+func (g *Gateway) listAPIs(w http.ResponseWriter, _ *http.Request) {
+	entries := g.registry.ListAPIs()
+	for i := range entries {
+		entries[i].API = maskedAPI(entries[i].API)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (g *Gateway) createAPI(w http.ResponseWriter, r *http.Request) {
+	var api API
+	if err := readJSON(r, &api); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := unmaskAPI(&api, nil); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := g.registry.RegisterAPI(api); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	g.logger.Info("api registered", "name", api.Name)
+	g.writeAPI(w, http.StatusCreated, api.Name)
+}
+
+func (g *Gateway) getAPI(w http.ResponseWriter, r *http.Request) {
+	g.writeAPI(w, http.StatusOK, r.PathValue("name"))
+}
+
+func (g *Gateway) updateAPI(w http.ResponseWriter, r *http.Request) {
+	version, err := readVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var api API
+	if err := readJSON(r, &api); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	api.Name = r.PathValue("name")
+	current, err := g.registry.GetAPI(api.Name)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	if err := unmaskAPI(&api, &current.API); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := g.registry.UpdateAPI(api, version); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	g.logger.Info("api updated", "name", api.Name)
+	g.writeAPI(w, http.StatusOK, api.Name)
+}
+
+func (g *Gateway) deleteAPI(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := g.registry.UnregisterAPI(name); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	g.logger.Info("api unregistered", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) writeAPI(w http.ResponseWriter, code int, name string) {
+	entry, err := g.registry.GetAPI(name)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	entry.API = maskedAPI(entry.API)
+	writeJSON(w, code, entry)
+}
+
+func (g *Gateway) listTemplates(w http.ResponseWriter, _ *http.Request) {
+	entries := g.registry.ListTemplates()
+	for i := range entries {
+		entries[i].Template = maskedTemplate(entries[i].Template)
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (g *Gateway) createTemplate(w http.ResponseWriter, r *http.Request) {
+	var template Template
+	if err := readJSON(r, &template); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := unmaskTemplate(&template, nil); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := g.registry.RegisterTemplate(template); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	g.logger.Info("api template registered", "name", template.Name)
+	g.writeTemplate(w, http.StatusCreated, template.Name)
+}
+
+func (g *Gateway) getTemplate(w http.ResponseWriter, r *http.Request) {
+	g.writeTemplate(w, http.StatusOK, r.PathValue("name"))
+}
+
+func (g *Gateway) updateTemplate(w http.ResponseWriter, r *http.Request) {
+	version, err := readVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var template Template
+	if err := readJSON(r, &template); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	template.Name = r.PathValue("name")
+	current, err := g.registry.GetTemplate(template.Name)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	if err := unmaskTemplate(&template, &current.Template); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := g.registry.UpdateTemplate(template, version); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	g.logger.Info("api template updated", "name", template.Name)
+	g.writeTemplate(w, http.StatusOK, template.Name)
+}
+
+func (g *Gateway) deleteTemplate(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := g.registry.UnregisterTemplate(name); err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	g.logger.Info("api template unregistered", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (g *Gateway) writeTemplate(w http.ResponseWriter, code int, name string) {
+	entry, err := g.registry.GetTemplate(name)
+	if err != nil {
+		writeRegistryError(w, err)
+		return
+	}
+	entry.Template = maskedTemplate(entry.Template)
+	writeJSON(w, code, entry)
+}
+
+// maskedAPI returns the API with the auth credentials and secret headers masked.
+// The secret values in the URLs, body and headers are masked as in the logs,
+// the secrets are those of the auth config, the API and the given ones.
+func maskedAPI(api API, secrets ...string) API {
+	mask := api.secretMasker(secrets...)
+	api.Config.Auth = api.Config.Auth.masked()
+	api.URL = mask(api.URL)
+	if len(api.URLs) > 0 {
+		urls := make([]string, len(api.URLs))
+		for i, url := range api.URLs {
+			urls[i] = mask(url)
+		}
+		api.URLs = urls
+	}
+	if len(api.Config.Fallback.Weights) > 0 {
+		weights := make(map[string]int, len(api.Config.Fallback.Weights))
+		for url, weight := range api.Config.Fallback.Weights {
+			weights[mask(url)] = weight
+		}
+		api.Config.Fallback.Weights = weights
+	}
+	api.Body = mask(api.Body)
+	if len(api.Headers) == 0 {
+		return api
+	}
+	headers := make(map[string]string, len(api.Headers))
+	for k, v := range api.Headers {
+		if v != "" && secretHeader(k) {
+			v = httpclient.SecretMask
+		}
+		headers[k] = mask(v)
+	}
+	api.Headers = headers
+	return api
+}
+
+// secretMasker returns the function masking the secret values of the API
+func (c *API) secretMasker(secrets ...string) func(string) string {
+	secrets = append(append(secrets, c.secrets...), c.Config.Auth.Secrets()...)
+	ctx := httpclient.ContextWithSecrets(context.Background(), secrets...)
+	return func(s string) string {
+		return httpclient.MaskSecrets(ctx, s)
+	}
+}
+
+// unmaskAPI restores the masked values of the API from the current API, so a returned API
+// can be sent back as is. It fails if there is no current value to restore.
+func unmaskAPI(api *API, current *API, secrets ...string) error {
+	if current == nil {
+		current = &API{}
+	}
+	if err := api.Config.Auth.unmask(current.Config.Auth); err != nil {
+		return err
+	}
+	mask := current.secretMasker(secrets...)
+	var ok bool
+	if api.URL, ok = unmaskValue(api.URL, mask, current.URL); !ok {
+		return errors.New("masked url can not be restored")
+	}
+	for i, url := range api.URLs {
+		if api.URLs[i], ok = unmaskValue(url, mask, current.URLs...); !ok {
+			return fmt.Errorf("masked url %s can not be restored", url)
+		}
+	}
+	if weights := api.Config.Fallback.Weights; len(weights) > 0 {
+		currentURLs := make([]string, 0, len(current.Config.Fallback.Weights))
+		for url := range current.Config.Fallback.Weights {
+			currentURLs = append(currentURLs, url)
+		}
+		api.Config.Fallback.Weights = make(map[string]int, len(weights))
+		for url, weight := range weights {
+			restored, ok := unmaskValue(url, mask, currentURLs...)
+			if !ok {
+				return fmt.Errorf("masked weight url %s can not be restored", url)
+			}
+			api.Config.Fallback.Weights[restored] = weight
+		}
+	}
+	if api.Body, ok = unmaskValue(api.Body, mask, current.Body); !ok {
+		return errors.New("masked body can not be restored")
+	}
+	for k, v := range api.Headers {
+		val := current.header(k)
+		if v == httpclient.SecretMask && val != "" {
+			api.Headers[k] = val
+			continue
+		}
+		if api.Headers[k], ok = unmaskValue(v, mask, val); !ok {
+			return fmt.Errorf("masked header %s can not be restored", k)
+		}
+	}
+	return nil
+}
+
+// unmaskValue returns the current value which is masked as the value,
+// the value itself if it has no masked part
+func unmaskValue(val string, mask func(string) string, current ...string) (string, bool) {
+	if !strings.Contains(val, httpclient.SecretMask) {
+		return val, true
+	}
+	for _, cur := range current {
+		if cur != "" && mask(cur) == val {
+			return cur, true
+		}
+	}
+	return val, false
+}
+
+// maskedTemplate returns the template with the API and the defaults of the secret variables masked
+func maskedTemplate(t Template) Template {
+	secrets := t.secretDefaults()
+	if len(secrets) > 0 {
+		vars := make([]VarRequired, len(t.Vars))
+		for i, v := range t.Vars {
+			if v.Secret && v.Default != "" {
+				v.Default = httpclient.SecretMask
+			}
+			vars[i] = v
+		}
+		t.Vars = vars
+	}
+	t.API = maskedAPI(t.API, secrets...)
+	return t
+}
+
+// unmaskTemplate restores the masked values of the template from the current template
+func unmaskTemplate(t *Template, current *Template) error {
+	if current == nil {
+		current = &Template{}
+	}
+	for i, v := range t.Vars {
+		if !v.Secret || v.Default != httpclient.SecretMask {
+			continue
+		}
+		idx := slices.IndexFunc(current.Vars, func(cur VarRequired) bool { return cur.Name == v.Name })
+		if idx < 0 || current.Vars[idx].Default == "" {
+			return fmt.Errorf("masked default of variable %s can not be restored", v.Name)
+		}
+		t.Vars[i].Default = current.Vars[idx].Default
+	}
+	return unmaskAPI(&t.API, &current.API, current.secretDefaults()...)
+}
+
+// secretDefaults returns the default values of the secret variables
+func (t *Template) secretDefaults() []string {
+	var secrets []string
+	for _, v := range t.Vars {
+		if v.Secret && v.Default != "" {
+			secrets = append(secrets, v.Default)
+		}
+	}
+	return secrets
+}
+
+// secretHeader reports whether the value of the header is masked in the returned APIs
+func secretHeader(name string) bool {
+	name = strings.ToLower(name)
+	for _, word := range secretHeaderWords {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Gateway) options(ctx context.Context) []Option {
+	opts := []Option{WithContext(ctx)}
+	if g.client != nil {
+		opts = append(opts, WithClient(g.client))
+	}
+	return opts
+}
+
+// writeRegistryError writes the registry error with the matching status code
+func writeRegistryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrAlreadyExists), errors.Is(err, ErrVersionConflict):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+// writeResponse streams the upstream response back to the client
+func (g *Gateway) writeResponse(w http.ResponseWriter, kind, name string, resp *http.Response, err error) {
+	if err != nil {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		g.logger.Error("failed to execute", "kind", kind, "name", name, "error", err)
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	header := w.Header()
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+	for _, k := range hopHeaders {
+		header.Del(k)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				g.logger.Warn("failed to write response", "kind", kind, "name", name, "error", werr)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if rerr != nil {
+			if rerr != io.EOF {
+				g.logger.Warn("failed to read upstream response", "kind", kind, "name", name, "error", rerr)
+			}
+			return
+		}
+	}
+}
+
+// readVars reads the template vars from the query and the json object body
+func readVars(r *http.Request) (map[string]string, error) {
+	vars := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			vars[k] = v[len(v)-1]
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, MaxVarsBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if len(data) > MaxVarsBodySize {
+		return nil, fmt.Errorf("body is larger than %d bytes", MaxVarsBodySize)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return vars, nil
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, _ := mime.ParseMediaType(ct); mediaType != MediaTypeJSON {
+			return nil, fmt.Errorf("content type %s is not supported, expect %s", ct, MediaTypeJSON)
+		}
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("body must be a json object: %w", err)
+	}
+	for k, v := range body {
+		switch x := v.(type) {
+		case nil:
+			vars[k] = ""
+		case map[string]any, []any:
+			b, _ := json.Marshal(x)
+			vars[k] = string(b)
+		default:
+			vars[k] = cast.ToString(x)
+		}
+	}
+	return vars, nil
+}
+
+// readJSON decodes the json body of the request into v
+func readJSON(r *http.Request, v any) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, MaxVarsBodySize))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("failed to decode json body: %w", err)
+	}
+	return nil
+}
+
+// readVersion reads the expected version in the query, 0 if it is not set
+func readVersion(r *http.Request) (uint64, error) {
+	val := r.URL.Query().Get("version")
+	if val == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("version %s is invalid", val)
+	}
+	return version, nil
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]any{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", MediaTypeJSON)
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGateway(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Upstream", "yes")
+		_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `","q":"` + r.URL.Query().Get("q") + `"}`))
+	}))
+	defer upstream.Close()
+
+	assert.NoError(t, RegisterAPI(API{Name: "gateway-api", Method: http.MethodGet, URL: upstream.URL + "/api"}))
+	assert.NoError(t, RegisterTemplate(Template{
+		Name:          "gateway-template",
+		API:           API{Method: http.MethodGet, URL: upstream.URL + "/users/{{ user }}?q={{ q }}"},
+		TemplateField: TemplateField{URL: true},
+		Vars:          []VarRequired{{Name: "user"}, {Name: "q", CanEmpty: true}},
+	}))
+	assert.NoError(t, RegisterTemplate(Template{
+		Name:    "gateway-extract",
+		API:     API{Method: http.MethodGet, URL: upstream.URL + "/extract"},
+		Extract: []Extract{{Name: "path", JSONPath: "$.path"}},
+	}))
+
+	server := httptest.NewServer(NewGateway())
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantHeader string
+	}{
+		{name: "api", path: "/apis/gateway-api", wantStatus: http.StatusOK, wantBody: `{"path":"/api","q":""}`, wantHeader: "yes"},
+		{name: "api not found", path: "/apis/missing", wantStatus: http.StatusNotFound, wantBody: "not found"},
+		{name: "template query vars", path: "/templates/gateway-template?user=alice&q=x", wantStatus: http.StatusOK, wantBody: `{"path":"/users/alice","q":"x"}`},
+		{name: "template body vars", path: "/templates/gateway-template?user=alice", body: `{"user":"bob","q":1}`, wantStatus: http.StatusOK, wantBody: `{"path":"/users/bob","q":"1"}`},
+		{name: "template missing var", path: "/templates/gateway-template", wantStatus: http.StatusBadRequest, wantBody: "variable user is required"},
+		{name: "template invalid body", path: "/templates/gateway-template", body: `[1]`, wantStatus: http.StatusBadRequest, wantBody: "json object"},
+		{name: "template extract", path: "/templates/gateway-extract", wantStatus: http.StatusOK, wantBody: `{"path":"/extract"}`},
+		{name: "template not found", path: "/templates/missing", wantStatus: http.StatusNotFound, wantBody: "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			assert.Contains(t, string(body), tt.wantBody)
+			if tt.wantHeader != "" {
+				assert.Equal(t, tt.wantHeader, resp.Header.Get("X-Upstream"))
+			}
+		})
+	}
+
+	req, _ := http.NewRequest(http.MethodPatch, server.URL+"/apis/gateway-api", nil)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestGateway_CRUD(t *testing.T) {
+	server := httptest.NewServer(NewGateway(WithGatewayRegistry(NewRegistry()), WithGatewayManagement("admin")))
+	defer server.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	api := `{"name": "a", "method": "GET", "url": "https://example.com", "config": {"auth": {"type": "bearer", "token": "my-token"}}}`
+	code, body := do(http.MethodPost, "/apis", api)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Contains(t, body, `"version":1`)
+	assert.NotContains(t, body, "my-token")
+	code, _ = do(http.MethodPost, "/apis", api)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = do(http.MethodPost, "/apis", `{"name": "b", "method": "INVALID", "url": "https://example.com"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = do(http.MethodPut, "/apis/a?version=1", `{"method": "POST", "url": "https://example.com"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"version":2`)
+	assert.Contains(t, body, `"method":"POST"`)
+	code, _ = do(http.MethodPut, "/apis/a?version=1", `{"method": "GET", "url": "https://example.com"}`)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = do(http.MethodPut, "/apis/a?version=x", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = do(http.MethodGet, "/apis", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"a"`)
+	code, _ = do(http.MethodDelete, "/apis/a", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodGet, "/apis/a", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	template := `{"name": "t", "api": {"method": "GET", "url": "https://example.com/{{ id }}"}, "template_field": {"url": true}, "vars": [{"name": "id"}]}`
+	code, body = do(http.MethodPost, "/templates", template)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Contains(t, body, `"version":1`)
+	code, body = do(http.MethodPut, "/templates/t", template)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"version":2`)
+	code, body = do(http.MethodGet, "/templates/t", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"can_empty":false`)
+	code, body = do(http.MethodGet, "/templates", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"name":"t"`)
+	code, _ = do(http.MethodDelete, "/templates/t", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = do(http.MethodDelete, "/templates/t", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestGateway_Management(t *testing.T) {
+	registry := NewRegistry()
+	assert.NoError(t, registry.RegisterAPI(API{Name: "a", Method: http.MethodGet, URL: "https://example.com"}))
+
+	do := func(server *httptest.Server, method, path, token string) int {
+		req, err := http.NewRequest(method, server.URL+path, nil)
+		assert.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// the management routes are disabled by default
+	disabled := httptest.NewServer(NewGateway(WithGatewayRegistry(registry)))
+	defer disabled.Close()
+	assert.Equal(t, http.StatusNotFound, do(disabled, http.MethodGet, "/apis", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, do(disabled, http.MethodDelete, "/apis/a", ""))
+
+	server := httptest.NewServer(NewGateway(WithGatewayRegistry(registry), WithGatewayManagement("admin")))
+	defer server.Close()
+	assert.Equal(t, http.StatusUnauthorized, do(server, http.MethodGet, "/apis", ""))
+	assert.Equal(t, http.StatusUnauthorized, do(server, http.MethodDelete, "/apis/a", "wrong"))
+	assert.Equal(t, http.StatusOK, do(server, http.MethodGet, "/apis", "admin"))
+}
+
+func TestGateway_MaskedUpdate(t *testing.T) {
+	registry := NewRegistry()
+	assert.NoError(t, registry.RegisterAPI(API{
+		Name:    "a",
+		Method:  http.MethodPost,
+		URL:     "https://example.com/?key=secret-password",
+		Headers: map[string]string{"Authorization": "Bearer secret-token", "X-Api-Key": "secret-key", "Accept": "text/plain"},
+		Body:    `{"password": "secret-password"}`,
+		Config:  Config{Auth: Auth{Type: AuthTypeBasic, Username: "user", Password: "secret-password"}},
+	}))
+	assert.NoError(t, registry.RegisterTemplate(Template{
+		Name:          "t",
+		API:           API{Method: http.MethodGet, URL: "https://example.com/?key={{ key }}&token=secret-default"},
+		TemplateField: TemplateField{URL: true},
+		Vars:          []VarRequired{{Name: "key", Secret: true, Default: "secret-default"}},
+	}))
+	server := httptest.NewServer(NewGateway(WithGatewayRegistry(registry), WithGatewayManagement("admin")))
+	defer server.Close()
+
+	do := func(method, path, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+
+	code, body := do(http.MethodGet, "/apis/a", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "secret")
+	assert.Contains(t, body, "text/plain")
+
+	// the returned API is sent back with a change, the masked values are kept
+	var entry APIEntry
+	assert.NoError(t, json.Unmarshal([]byte(body), &entry))
+	entry.API.Method = http.MethodPut
+	data, _ := json.Marshal(entry.API)
+	code, _ = do(http.MethodPut, "/apis/a", string(data))
+	assert.Equal(t, http.StatusOK, code)
+	updated, err := registry.GetAPI("a")
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPut, updated.API.Method)
+	assert.Equal(t, "Bearer secret-token", updated.API.Headers["Authorization"])
+	assert.Equal(t, "secret-key", updated.API.Headers["X-Api-Key"])
+	assert.Equal(t, "secret-password", updated.API.Config.Auth.Password)
+	assert.Equal(t, "https://example.com/?key=secret-password", updated.API.URL)
+	assert.Equal(t, `{"password": "secret-password"}`, updated.API.Body)
+
+	code, body = do(http.MethodGet, "/templates/t", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, body, "secret-default")
+	var templateEntry TemplateEntry
+	assert.NoError(t, json.Unmarshal([]byte(body), &templateEntry))
+	data, _ = json.Marshal(templateEntry.Template)
+	code, _ = do(http.MethodPut, "/templates/t", string(data))
+	assert.Equal(t, http.StatusOK, code)
+	updatedTemplate, err := registry.GetTemplate("t")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret-default", updatedTemplate.Template.Vars[0].Default)
+	assert.Equal(t, "https://example.com/?key={{ key }}&token=secret-default", updatedTemplate.Template.API.URL)
+
+	// the masked values can not be restored without a current value
+	code, body = do(http.MethodPut, "/apis/a", `{"method": "GET", "url": "https://example.com", "headers": {"X-Token": "******"}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "X-Token")
+	code, _ = do(http.MethodPost, "/apis", `{"name": "b", "method": "GET", "url": "https://example.com", "config": {"auth": {"type": "bearer", "token": "******"}}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = do(http.MethodPost, "/apis", `{"name": "b", "method": "GET", "url": "https://example.com/?key=******"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestReadVars(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/templates/x?a=1&b=2", strings.NewReader(`{"b":"3","c":true,"d":null,"e":{"k":"v"}}`))
+	vars, err := readVars(r)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "1", "b": "3", "c": "true", "d": "", "e": `{"k":"v"}`}, vars)
+
+	r = httptest.NewRequest(http.MethodPost, "/templates/x", strings.NewReader("a=1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	_, err = readVars(r)
+	assert.Error(t, err)
+}
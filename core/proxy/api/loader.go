@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultReloadInterval is the interval to check the template files for changes
+const DefaultReloadInterval = 2 * time.Second
+
+// TemplateLoader loads the API templates from yaml or json files into the registry,
+// and reloads them when the files change. A path can be a file or a directory,
+// the *.yaml, *.yml and *.json files in the directory are loaded.
+// The loaded set replaces all the templates of the registry at once,
+// if any file is invalid, the previous set is kept and the error is logged.
+type TemplateLoader struct {
+	paths    []string
+	registry *Registry
+	interval time.Duration
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	stamp    string // stamp of the names, sizes and modification times of the checked files
+	checksum string // checksum of the loaded files
+	failed   string // checksum of the files failed to load, not retried until changed
+}
+
+// LoaderOption is a option for the template loader
+type LoaderOption func(*TemplateLoader)
+
+// WithLoaderRegistry sets the registry of the loader, DefaultRegistry by default
+func WithLoaderRegistry(registry *Registry) LoaderOption {
+	return func(l *TemplateLoader) {
+		if registry != nil {
+			l.registry = registry
+		}
+	}
+}
+
+// WithReloadInterval sets the interval to check the files for changes
+func WithReloadInterval(interval time.Duration) LoaderOption {
+	return func(l *TemplateLoader) {
+		if interval > 0 {
+			l.interval = interval
+		}
+	}
+}
+
+// WithLoaderLogger sets the logger of the loader
+func WithLoaderLogger(logger *slog.Logger) LoaderOption {
+	return func(l *TemplateLoader) {
+		if logger != nil {
+			l.logger = logger
+		}
+	}
+}
+
+// NewTemplateLoader creates the loader of the template files or directories
+func NewTemplateLoader(paths []string, opts ...LoaderOption) *TemplateLoader {
+	l := &TemplateLoader{
+		paths:    paths,
+		registry: DefaultRegistry,
+		interval: DefaultReloadInterval,
+		logger:   slog.With("component", "api/loader"),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Load loads the templates into the registry if the files changed since the last load.
+// It reports whether the templates were replaced. Files failed to load are not
+// loaded again until they change. The files are read only if their sizes or
+// modification times changed, their content is compared then.
+func (l *TemplateLoader) Load() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := l.files()
+	if err != nil {
+		return false, err
+	}
+	stamp, err := fileStamp(files)
+	if err != nil {
+		return false, err
+	}
+	if stamp == l.stamp {
+		return false, nil
+	}
+
+	data := make(map[string][]byte, len(files))
+	h := sha256.New()
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return false, fmt.Errorf("failed to read file (%s): %w", file, err)
+		}
+		data[file] = content
+		h.Write([]byte(file))
+		h.Write([]byte{0})
+		h.Write(content)
+		h.Write([]byte{0})
+	}
+	checksum := hex.EncodeToString(h.Sum(nil))
+	l.stamp = stamp
+	if checksum == l.checksum || checksum == l.failed {
+		return false, nil
+	}
+	l.failed = checksum
+
+	var templates []Template
+	for _, file := range files {
+		var list []Template
+		if err := unmarshalData(strings.TrimPrefix(filepath.Ext(file), "."), data[file], &list); err != nil {
+			return false, fmt.Errorf("file %s: %w", file, err)
+		}
+		templates = append(templates, list...)
+	}
+	if err := l.registry.ReplaceTemplates(templates); err != nil {
+		return false, err
+	}
+	l.checksum, l.failed = checksum, ""
+	l.logger.Info("api templates loaded", "files", len(files), "templates", len(templates))
+	return true, nil
+}
+
+// Watch reloads the templates on changes until the context is done
+func (l *TemplateLoader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := l.Load(); err != nil {
+				l.logger.Error("failed to reload api templates, keep the previous ones", "error", err)
+			}
+		}
+	}
+}
+
+// files returns the template files of the paths, sorted and deduplicated
+func (l *TemplateLoader) files() ([]string, error) {
+	if len(l.paths) == 0 {
+		return nil, errors.New("template path is required")
+	}
+	var files []string
+	for _, path := range l.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if !isTemplateFile(path) {
+				return nil, fmt.Errorf("unsupported file type: %s", path)
+			}
+			files = append(files, path)
+			continue
+		}
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && isTemplateFile(entry.Name()) {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	slices.Sort(files)
+	return slices.Compact(files), nil
+}
+
+// fileStamp returns the stamp of the names, sizes and modification times of the files
+func fileStamp(files []string) (string, error) {
+	h := sha256.New()
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func isTemplateFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	default:
+		return false
+	}
+}
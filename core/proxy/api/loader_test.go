@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const loaderTemplates = `
+- name: a
+  api:
+    method: GET
+    url: https://example.com/a
+- name: b
+  api:
+    method: GET
+    url: https://example.com/b
+`
+
+func TestTemplateLoader(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "apis.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(loaderTemplates), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "more.json"), []byte(`[{"name": "c", "api": {"method": "GET", "url": "https://example.com/c"}}]`), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("ignored"), 0o600))
+
+	registry := NewRegistry()
+	loader := NewTemplateLoader([]string{dir}, WithLoaderRegistry(registry))
+	loaded, err := loader.Load()
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Len(t, registry.ListTemplates(), 3)
+
+	loaded, err = loader.Load()
+	assert.NoError(t, err)
+	assert.False(t, loaded, "unchanged files should not be reloaded")
+
+	// invalid file keeps the previous templates
+	assert.NoError(t, os.WriteFile(file, []byte(`- name: a
+  api:
+    method: INVALID
+    url: https://example.com/a
+`), 0o600))
+	loaded, err = loader.Load()
+	assert.Error(t, err)
+	assert.False(t, loaded)
+	assert.Len(t, registry.ListTemplates(), 3)
+	loaded, err = loader.Load()
+	assert.NoError(t, err, "the failed files are not loaded again until changed")
+	assert.False(t, loaded)
+
+	// removed templates are unregistered and the kept ones get a new version
+	assert.NoError(t, os.WriteFile(file, []byte(`- name: a
+  api:
+    method: POST
+    url: https://example.com/a
+`), 0o600))
+	loaded, err = loader.Load()
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	entries := registry.ListTemplates()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "a", entries[0].Template.Name)
+	assert.Equal(t, "POST", entries[0].Template.API.Method)
+	assert.Equal(t, uint64(2), entries[0].Version)
+}
+
+func TestTemplateLoader_Stamp(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "apis.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(loaderTemplates), 0o600))
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+
+	registry := NewRegistry()
+	loader := NewTemplateLoader([]string{file}, WithLoaderRegistry(registry))
+	loaded, err := loader.Load()
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+
+	// the files are not read while their sizes and modification times are unchanged
+	changed := strings.Replace(loaderTemplates, "/b", "/x", 1)
+	assert.NoError(t, os.WriteFile(file, []byte(changed), 0o600))
+	assert.NoError(t, os.Chtimes(file, info.ModTime(), info.ModTime()))
+	loaded, err = loader.Load()
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+
+	// a touched file is read, but only reloaded if its content changed
+	later := info.ModTime().Add(time.Second)
+	assert.NoError(t, os.Chtimes(file, later, later))
+	loaded, err = loader.Load()
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	later = later.Add(time.Second)
+	assert.NoError(t, os.Chtimes(file, later, later))
+	loaded, err = loader.Load()
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+}
+
+func TestTemplateLoader_Errors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewTemplateLoader(nil).Load()
+	assert.Error(t, err)
+	_, err = NewTemplateLoader([]string{filepath.Join(dir, "missing.yaml")}).Load()
+	assert.Error(t, err)
+
+	file := filepath.Join(dir, "apis.txt")
+	assert.NoError(t, os.WriteFile(file, []byte(loaderTemplates), 0o600))
+	_, err = NewTemplateLoader([]string{file}).Load()
+	assert.Error(t, err)
+
+	file = filepath.Join(dir, "dup.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(loaderTemplates+loaderTemplates), 0o600))
+	_, err = NewTemplateLoader([]string{file}, WithLoaderRegistry(NewRegistry())).Load()
+	assert.ErrorContains(t, err, "duplicated")
+}
+
+func TestTemplateLoader_Watch(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "apis.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(loaderTemplates), 0o600))
+
+	registry := NewRegistry()
+	loader := NewTemplateLoader([]string{file}, WithLoaderRegistry(registry), WithReloadInterval(10*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go loader.Watch(ctx)
+
+	assert.Eventually(t, func() bool { return len(registry.ListTemplates()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.NoError(t, os.WriteFile(file, []byte(`[]`), 0o600))
+	assert.Eventually(t, func() bool { return len(registry.ListTemplates()) == 0 }, time.Second, 10*time.Millisecond)
+}
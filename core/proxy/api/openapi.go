@@ -0,0 +1,484 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/telepair/telepair/pkg/utils"
+)
+
+// openAPIMethods are the operation methods of an OpenAPI path item, in output order
+var openAPIMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch,
+	http.MethodDelete, http.MethodHead, http.MethodOptions,
+}
+
+var (
+	openAPIPathParam = regexp.MustCompile(`\{([^{}]+)\}`)
+	invalidVarChars  = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+// openAPIDoc is the subset of the OpenAPI 3 document used to generate templates
+type openAPIDoc struct {
+	OpenAPI    string                     `yaml:"openapi"`
+	Servers    []openAPIServer            `yaml:"servers"`
+	Paths      map[string]openAPIPathItem `yaml:"paths"`
+	Components struct {
+		Parameters    map[string]*openAPIParameter   `yaml:"parameters"`
+		RequestBodies map[string]*openAPIRequestBody `yaml:"requestBodies"`
+		Schemas       map[string]*openAPISchema      `yaml:"schemas"`
+	} `yaml:"components"`
+}
+
+type openAPIServer struct {
+	URL       string `yaml:"url"`
+	Variables map[string]struct {
+		Default string `yaml:"default"`
+	} `yaml:"variables"`
+}
+
+type openAPIPathItem struct {
+	Parameters []*openAPIParameter `yaml:"parameters"`
+	Get        *openAPIOperation   `yaml:"get"`
+	Post       *openAPIOperation   `yaml:"post"`
+	Put        *openAPIOperation   `yaml:"put"`
+	Patch      *openAPIOperation   `yaml:"patch"`
+	Delete     *openAPIOperation   `yaml:"delete"`
+	Head       *openAPIOperation   `yaml:"head"`
+	Options    *openAPIOperation   `yaml:"options"`
+}
+
+func (p openAPIPathItem) operation(method string) *openAPIOperation {
+	switch method {
+	case http.MethodGet:
+		return p.Get
+	case http.MethodPost:
+		return p.Post
+	case http.MethodPut:
+		return p.Put
+	case http.MethodPatch:
+		return p.Patch
+	case http.MethodDelete:
+		return p.Delete
+	case http.MethodHead:
+		return p.Head
+	case http.MethodOptions:
+		return p.Options
+	default:
+		return nil
+	}
+}
+
+type openAPIOperation struct {
+	OperationID string              `yaml:"operationId"`
+	Parameters  []*openAPIParameter `yaml:"parameters"`
+	RequestBody *openAPIRequestBody `yaml:"requestBody"`
+	Servers     []openAPIServer     `yaml:"servers"`
+}
+
+type openAPIParameter struct {
+	Ref         string         `yaml:"$ref"`
+	Name        string         `yaml:"name"`
+	In          string         `yaml:"in"`
+	Description string         `yaml:"description"`
+	Required    bool           `yaml:"required"`
+	Schema      *openAPISchema `yaml:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Ref      string                      `yaml:"$ref"`
+	Required bool                        `yaml:"required"`
+	Content  map[string]openAPIMediaType `yaml:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *openAPISchema `yaml:"schema"`
+}
+
+type openAPISchema struct {
+	Ref         string                    `yaml:"$ref"`
+	Type        string                    `yaml:"type"`
+	Format      string                    `yaml:"format"`
+	Description string                    `yaml:"description"`
+	Enum        []any                     `yaml:"enum"`
+	Default     any                       `yaml:"default"`
+	Minimum     *float64                  `yaml:"minimum"`
+	Maximum     *float64                  `yaml:"maximum"`
+	MinLength   *float64                  `yaml:"minLength"`
+	MaxLength   *float64                  `yaml:"maxLength"`
+	Pattern     string                    `yaml:"pattern"`
+	Properties  map[string]*openAPISchema `yaml:"properties"`
+	Required    []string                  `yaml:"required"`
+}
+
+// OpenAPIOption is a option for ImportOpenAPI
+type OpenAPIOption func(*openAPIImporter)
+
+// WithOpenAPIBaseURL overrides the servers of the document
+func WithOpenAPIBaseURL(baseURL string) OpenAPIOption {
+	return func(i *openAPIImporter) {
+		i.baseURL = strings.TrimSpace(baseURL)
+	}
+}
+
+// WithOpenAPIPrefix sets the prefix of the template names
+func WithOpenAPIPrefix(prefix string) OpenAPIOption {
+	return func(i *openAPIImporter) {
+		i.prefix = prefix
+	}
+}
+
+type openAPIImporter struct {
+	doc     *openAPIDoc
+	baseURL string
+	prefix  string
+}
+
+// ImportOpenAPI converts the operations of the OpenAPI 3 document, in yaml or json,
+// into go engine templates named by the operation id:
+//   - path, query and header parameters become variables of the URL and headers
+//   - the properties of a JSON object request body become variables of the body,
+//     any other JSON request body becomes the `body` variable
+//   - parameter schemas set the variable type, options, range, pattern and default
+//
+// Cookie parameters, security schemes and non-JSON request bodies are not imported.
+func ImportOpenAPI(data []byte, opts ...OpenAPIOption) ([]Template, error) {
+	var doc openAPIDoc
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal openapi: %w", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		return nil, fmt.Errorf("openapi version %q is not supported, expect 3.x", doc.OpenAPI)
+	}
+
+	i := &openAPIImporter{doc: &doc}
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	var templates []Template
+	names := make(map[string]struct{})
+	for _, path := range paths {
+		item := doc.Paths[path]
+		for _, method := range openAPIMethods {
+			op := item.operation(method)
+			if op == nil {
+				continue
+			}
+			template, err := i.template(method, path, item, op)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", method, path, err)
+			}
+			if _, ok := names[template.Name]; ok {
+				return nil, fmt.Errorf("%s %s: template %s is duplicated", method, path, template.Name)
+			}
+			names[template.Name] = struct{}{}
+			templates = append(templates, template)
+		}
+	}
+	return templates, nil
+}
+
+func (i *openAPIImporter) template(method, path string, item openAPIPathItem, op *openAPIOperation) (Template, error) {
+	name := op.OperationID
+	if name == "" {
+		name = strings.ToLower(method) + "_" + strings.Trim(invalidVarChars.ReplaceAllString(strings.ToLower(path), "_"), "_")
+	}
+	t := Template{
+		Name:   i.prefix + name,
+		Engine: utils.EngineGo,
+		API: API{
+			Method:  method,
+			Headers: map[string]string{},
+		},
+		TemplateField: TemplateField{URL: true, Headers: map[string]bool{}},
+	}
+
+	baseURL, err := i.serverURL(op)
+	if err != nil {
+		return t, err
+	}
+
+	params, err := i.parameters(item.Parameters, op.Parameters)
+	if err != nil {
+		return t, err
+	}
+	vars := make(varNames)
+	pathVars := make(map[string]string)
+	var query []string
+	for _, p := range params {
+		v := i.variable(vars.add(p.Name, p.In), p.Description, p.Schema, p.Required || p.In == "path")
+		switch p.In {
+		case "path":
+			pathVars[p.Name] = v.Name
+		case "query":
+			query = append(query, strconv.Quote(p.Name), "."+v.Name)
+		case "header":
+			t.API.Headers[p.Name] = "{{ ." + v.Name + " }}"
+			t.TemplateField.Headers[p.Name] = true
+		default:
+			continue
+		}
+		t.Vars = append(t.Vars, v)
+	}
+
+	var missing []string
+	urlPath := openAPIPathParam.ReplaceAllStringFunc(path, func(m string) string {
+		param := m[1 : len(m)-1]
+		name, ok := pathVars[param]
+		if !ok {
+			missing = append(missing, param)
+			return m
+		}
+		return "{{ ." + name + " | urlpath }}"
+	})
+	if len(missing) > 0 {
+		return t, fmt.Errorf("path parameters %v are not defined", missing)
+	}
+	t.API.URL = strings.TrimSuffix(baseURL, "/") + urlPath
+	if len(query) > 0 {
+		t.API.URL += "{{ with query " + strings.Join(query, " ") + " }}?{{ . }}{{ end }}"
+	}
+
+	if err := i.requestBody(&t, vars, op.RequestBody); err != nil {
+		return t, err
+	}
+	if len(t.TemplateField.Headers) == 0 {
+		t.TemplateField.Headers = nil
+	}
+	if len(t.API.Headers) == 0 {
+		t.API.Headers = nil
+	}
+
+	check := t
+	if err := check.Parse(); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// requestBody sets the JSON request body of the template
+func (i *openAPIImporter) requestBody(t *Template, vars varNames, body *openAPIRequestBody) error {
+	if body == nil {
+		return nil
+	}
+	body, err := i.resolveRequestBody(body)
+	if err != nil {
+		return err
+	}
+	mediaTypes := make([]string, 0, len(body.Content))
+	for mediaType := range body.Content {
+		if mediaType == MediaTypeJSON || strings.HasSuffix(mediaType, "+json") {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	if len(mediaTypes) == 0 {
+		return nil
+	}
+	slices.Sort(mediaTypes)
+	schema, err := i.resolveSchema(body.Content[mediaTypes[0]].Schema)
+	if err != nil {
+		return err
+	}
+
+	t.API.Headers["Content-Type"] = mediaTypes[0]
+	t.TemplateField.Body = true
+	if schema == nil || schema.Type != "object" || len(schema.Properties) == 0 {
+		v := i.variable(vars.add("body", "body"), "JSON request body", nil, body.Required)
+		t.Vars = append(t.Vars, v)
+		t.API.Body = "{{ ." + v.Name + " }}"
+		return nil
+	}
+
+	props := make([]string, 0, len(schema.Properties))
+	for prop := range schema.Properties {
+		props = append(props, prop)
+	}
+	slices.Sort(props)
+	pairs := make([]string, 0, 2*len(props))
+	for _, prop := range props {
+		propSchema, err := i.resolveSchema(schema.Properties[prop])
+		if err != nil {
+			return err
+		}
+		v := i.variable(vars.add(prop, "body"), "", propSchema, slices.Contains(schema.Required, prop))
+		t.Vars = append(t.Vars, v)
+		val := "." + v.Name
+		if propSchema != nil && (propSchema.Type == "object" || propSchema.Type == "array") {
+			v.Description = strings.TrimSpace(v.Description + " (JSON value)")
+			t.Vars[len(t.Vars)-1] = v
+			val = "(rawjson " + val + ")"
+		}
+		pairs = append(pairs, strconv.Quote(prop), val)
+	}
+	t.API.Body = "{{ object " + strings.Join(pairs, " ") + " | json }}"
+	return nil
+}
+
+// variable converts the parameter schema to the template variable
+func (i *openAPIImporter) variable(name, description string, schema *openAPISchema, required bool) VarRequired {
+	v := VarRequired{Name: name, Description: description, CanEmpty: !required}
+	schema, err := i.resolveSchema(schema)
+	if err != nil || schema == nil {
+		return v
+	}
+	if v.Description == "" {
+		v.Description = schema.Description
+	}
+	switch schema.Type {
+	case "integer":
+		v.Type, v.Min, v.Max = VarTypeInt, schema.Minimum, schema.Maximum
+	case "number":
+		v.Type, v.Min, v.Max = VarTypeFloat, schema.Minimum, schema.Maximum
+	case "boolean":
+		v.Type = VarTypeBool
+	case "string":
+		v.Min, v.Max, v.Pattern = schema.MinLength, schema.MaxLength, schema.Pattern
+		switch schema.Format {
+		case "email":
+			v.Type = VarTypeEmail
+		case "uri", "url":
+			v.Type = VarTypeURL
+		}
+	}
+	if len(schema.Enum) > 0 {
+		v.Type = VarTypeEnum
+		for _, option := range schema.Enum {
+			v.Options = append(v.Options, fmt.Sprint(option))
+		}
+	}
+	if schema.Default != nil {
+		v.Default = fmt.Sprint(schema.Default)
+	}
+	// drop the constraints the default value does not satisfy, the schema wins at the upstream
+	if v.Default != "" && v.Validate() != nil {
+		v.Default = ""
+	}
+	if v.Validate() != nil {
+		v.Pattern, v.Min, v.Max = "", nil, nil
+	}
+	return v
+}
+
+// parameters merges the path item and operation parameters, the operation wins
+func (i *openAPIImporter) parameters(lists ...[]*openAPIParameter) ([]*openAPIParameter, error) {
+	var params []*openAPIParameter
+	for _, list := range lists {
+		for _, p := range list {
+			p, err := i.resolveParameter(p)
+			if err != nil {
+				return nil, err
+			}
+			if p.Name == "" {
+				return nil, errors.New("parameter name is required")
+			}
+			idx := slices.IndexFunc(params, func(q *openAPIParameter) bool { return q.Name == p.Name && q.In == p.In })
+			if idx >= 0 {
+				params[idx] = p
+				continue
+			}
+			params = append(params, p)
+		}
+	}
+	return params, nil
+}
+
+func (i *openAPIImporter) serverURL(op *openAPIOperation) (string, error) {
+	if i.baseURL != "" {
+		return i.baseURL, nil
+	}
+	servers := op.Servers
+	if len(servers) == 0 {
+		servers = i.doc.Servers
+	}
+	if len(servers) == 0 || servers[0].URL == "" {
+		return "", errors.New("servers is required, or set the base url")
+	}
+	server := servers[0]
+	u := server.URL
+	for name, v := range server.Variables {
+		u = strings.ReplaceAll(u, "{"+name+"}", v.Default)
+	}
+	if !strings.Contains(u, "://") {
+		return "", fmt.Errorf("server url %s is relative, set the base url", u)
+	}
+	return u, nil
+}
+
+func (i *openAPIImporter) resolveParameter(p *openAPIParameter) (*openAPIParameter, error) {
+	for depth := 0; p != nil && p.Ref != ""; depth++ {
+		name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/")
+		if !ok || depth > 10 {
+			return nil, fmt.Errorf("parameter ref %s is not supported", p.Ref)
+		}
+		if p = i.doc.Components.Parameters[name]; p == nil {
+			return nil, fmt.Errorf("parameter ref %s is not found", name)
+		}
+	}
+	if p == nil {
+		return nil, errors.New("parameter is empty")
+	}
+	return p, nil
+}
+
+func (i *openAPIImporter) resolveRequestBody(b *openAPIRequestBody) (*openAPIRequestBody, error) {
+	for depth := 0; b.Ref != ""; depth++ {
+		name, ok := strings.CutPrefix(b.Ref, "#/components/requestBodies/")
+		if !ok || depth > 10 {
+			return nil, fmt.Errorf("request body ref %s is not supported", b.Ref)
+		}
+		if b = i.doc.Components.RequestBodies[name]; b == nil {
+			return nil, fmt.Errorf("request body ref %s is not found", name)
+		}
+	}
+	return b, nil
+}
+
+func (i *openAPIImporter) resolveSchema(s *openAPISchema) (*openAPISchema, error) {
+	for depth := 0; s != nil && s.Ref != ""; depth++ {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		if !ok || depth > 10 {
+			return nil, fmt.Errorf("schema ref %s is not supported", s.Ref)
+		}
+		if s = i.doc.Components.Schemas[name]; s == nil {
+			return nil, fmt.Errorf("schema ref %s is not found", name)
+		}
+	}
+	return s, nil
+}
+
+// varNames generates unique variable names usable in go templates
+type varNames map[string]struct{}
+
+// add returns the variable name of the parameter, suffixed with its location on conflicts
+func (v varNames) add(param, in string) string {
+	name := strings.Trim(invalidVarChars.ReplaceAllString(param, "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "v_" + name
+	}
+	if _, ok := v[name]; ok {
+		name += "_" + in
+	}
+	base := name
+	for n := 2; ; n++ {
+		if _, ok := v[name]; !ok {
+			break
+		}
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+	v[name] = struct{}{}
+	return name
+}
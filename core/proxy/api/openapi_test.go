@@ -0,0 +1,177 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const petstoreSpec = `
+openapi: 3.0.3
+info:
+  title: Petstore
+  version: 1.0.0
+servers:
+  - url: https://{env}.example.com/v1
+    variables:
+      env:
+        default: api
+paths:
+  /pets:
+    get:
+      operationId: listPets
+      parameters:
+        - name: limit
+          in: query
+          schema:
+            type: integer
+            minimum: 1
+            maximum: 100
+            default: 20
+        - name: status
+          in: query
+          required: true
+          schema:
+            type: string
+            enum: [available, sold]
+        - $ref: '#/components/parameters/RequestID'
+    post:
+      operationId: createPet
+      requestBody:
+        $ref: '#/components/requestBodies/Pet'
+  /pets/{petId}:
+    parameters:
+      - name: petId
+        in: path
+        required: true
+        schema:
+          type: string
+    delete:
+      parameters:
+        - name: session
+          in: cookie
+          schema:
+            type: string
+components:
+  parameters:
+    RequestID:
+      name: X-Request-ID
+      in: header
+      description: request id
+      schema:
+        type: string
+  requestBodies:
+    Pet:
+      required: true
+      content:
+        application/json:
+          schema:
+            $ref: '#/components/schemas/Pet'
+  schemas:
+    Pet:
+      type: object
+      required: [name]
+      properties:
+        name:
+          type: string
+          maxLength: 32
+        age:
+          type: integer
+        tags:
+          type: array
+          items:
+            type: string
+`
+
+func TestImportOpenAPI(t *testing.T) {
+	templates, err := ImportOpenAPI([]byte(petstoreSpec), WithOpenAPIPrefix("pet."))
+	assert.NoError(t, err)
+	assert.Len(t, templates, 3)
+
+	list := templates[0]
+	assert.Equal(t, "pet.listPets", list.Name)
+	assert.Equal(t, http.MethodGet, list.API.Method)
+	assert.Equal(t, `https://api.example.com/v1/pets{{ with query "limit" .limit "status" .status }}?{{ . }}{{ end }}`, list.API.URL)
+	assert.Equal(t, "{{ .X_Request_ID }}", list.API.Headers["X-Request-ID"])
+	assert.True(t, list.TemplateField.Headers["X-Request-ID"])
+	assert.Len(t, list.Vars, 3)
+	assert.Equal(t, VarTypeInt, list.Vars[0].Type)
+	assert.Equal(t, "20", list.Vars[0].Default)
+	assert.Equal(t, 100.0, *list.Vars[0].Max)
+	assert.True(t, list.Vars[0].CanEmpty)
+	assert.Equal(t, VarTypeEnum, list.Vars[1].Type)
+	assert.Equal(t, []string{"available", "sold"}, list.Vars[1].Options)
+	assert.False(t, list.Vars[1].CanEmpty)
+	assert.Equal(t, "request id", list.Vars[2].Description)
+
+	create := templates[1]
+	assert.Equal(t, "pet.createPet", create.Name)
+	assert.True(t, create.TemplateField.Body)
+	assert.Equal(t, MediaTypeJSON, create.API.Headers["Content-Type"])
+	assert.Equal(t, `{{ object "age" .age "name" .name "tags" (rawjson .tags) | json }}`, create.API.Body)
+
+	del := templates[2]
+	assert.Equal(t, "pet.delete_pets_petid", del.Name)
+	assert.Equal(t, "https://api.example.com/v1/pets/{{ .petId | urlpath }}", del.API.URL)
+	assert.Len(t, del.Vars, 1, "cookie parameters are not imported")
+}
+
+func TestImportOpenAPI_Render(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(body)))
+	}))
+	defer server.Close()
+
+	templates, err := ImportOpenAPI([]byte(petstoreSpec), WithOpenAPIBaseURL(server.URL))
+	assert.NoError(t, err)
+	registry := NewRegistry()
+	for _, template := range templates {
+		assert.NoError(t, registry.RegisterTemplate(template))
+	}
+
+	tests := []struct {
+		name string
+		vars map[string]string
+		want string
+	}{
+		{name: "listPets", vars: map[string]string{"status": "sold"}, want: "GET /pets?limit=20&status=sold "},
+		{name: "createPet", vars: map[string]string{"name": "kitty", "tags": `["cat"]`}, want: `POST /pets {"name":"kitty","tags":["cat"]}`},
+		{name: "delete_pets_petid", vars: map[string]string{"petId": "a/b"}, want: "DELETE /pets/a%2Fb "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := registry.DoTemplate(tt.name, tt.vars)
+			assert.NoError(t, err)
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			assert.Equal(t, tt.want, string(body))
+		})
+	}
+
+	_, err = registry.DoTemplate("createPet", map[string]string{"name": strings.Repeat("x", 33)})
+	assert.ErrorContains(t, err, "length must be <= 32")
+}
+
+func TestImportOpenAPI_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+	}{
+		{name: "invalid yaml", spec: "openapi: ["},
+		{name: "swagger 2", spec: "swagger: '2.0'"},
+		{name: "no servers", spec: "openapi: 3.0.0\npaths:\n  /a:\n    get: {}"},
+		{name: "undefined path parameter", spec: "openapi: 3.0.0\nservers: [{url: 'https://x.com'}]\npaths:\n  /a/{id}:\n    get: {}"},
+		{name: "missing ref", spec: "openapi: 3.0.0\nservers: [{url: 'https://x.com'}]\npaths:\n  /a:\n    get:\n      parameters: [{$ref: '#/components/parameters/X'}]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ImportOpenAPI([]byte(tt.spec))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/telepair/telepair/pkg/cache"
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+var (
+	// apiRateLimiters caches the rate limiters by the name of the API, or of the template it is rendered from
+	apiRateLimiters = cache.NewMemory("api-rate-limiter")
+	// apiRateLimitersLock serializes the creation of the rate limiters, so concurrent calls share them
+	apiRateLimitersLock sync.Mutex
+)
+
+// RateLimitScope is what the limits of the API are counted by
+type RateLimitScope string
+
+const (
+	// RateLimitScopeAPI counts the calls of the API, or of the template it is rendered from
+	RateLimitScopeAPI RateLimitScope = "api"
+	// RateLimitScopeHost counts the requests per upstream host, including the retries and fallback urls
+	RateLimitScopeHost RateLimitScope = "host"
+	// RateLimitScopeKey counts the calls per key, which the templates render, e.g. per tenant.
+	// The keys are counted per API, two templates rendering the same key do not share its limits.
+	RateLimitScopeKey RateLimitScope = "key"
+)
+
+// RateLimit is the client-side rate limit of the API, a token bucket and a concurrency limit.
+// The calls over the limits wait, within the timeout, or fail fast with a httpclient.RateLimitError.
+// Example: 5 calls per second per tenant
+//
+//	rate_limit:
+//	  requests: 5
+//	  per: 1s
+//	  scope: key
+//	  key: "vendor-{{ .tenant }}"
+type RateLimit struct {
+	httpclient.RateLimitConfig `yaml:",inline"`
+	// Scope is what the limits are counted by, api by default
+	Scope RateLimitScope `yaml:"scope,omitempty" json:"scope,omitempty"`
+	// Key is the key of the key scope, rendered with the template variables
+	Key string `yaml:"key,omitempty" json:"key,omitempty"`
+}
+
+// Parse checks the rate limit
+func (r *RateLimit) Parse() error {
+	switch r.Scope {
+	case "", RateLimitScopeAPI, RateLimitScopeHost:
+	case RateLimitScopeKey:
+		if r.Key == "" {
+			return fmt.Errorf("rate limit key is required for the %s scope", r.Scope)
+		}
+	default:
+		return fmt.Errorf("rate limit scope %s is invalid", r.Scope)
+	}
+	return r.RateLimitConfig.Parse()
+}
+
+// apiRateLimiter are the rate limiters of an API and the limits they are created with
+type apiRateLimiter struct {
+	limits   httpclient.RateLimitConfig
+	limiters *httpclient.RateLimiters
+}
+
+// acquireRateLimit waits until the call is allowed by the limits of the API scope or key scope,
+// the limits of the host scope are applied by the client. The release function must be called
+// once the call is done.
+func (c *API) acquireRateLimit(ctx context.Context) (release func(), err error) {
+	limit := c.Config.RateLimit
+	if limit == nil || limit.Scope == RateLimitScopeHost {
+		return func() {}, nil
+	}
+	name := c.Name
+	if c.template != "" {
+		name = c.template
+	}
+	key := "api:" + name
+	if limit.Scope == RateLimitScopeKey {
+		key = "key:" + limit.Key
+	}
+
+	limits := limit.RateLimitConfig
+	limits.FailFast = false
+	apiRateLimitersLock.Lock()
+	val, _ := apiRateLimiters.Get(ctx, name)
+	limiter, ok := val.(*apiRateLimiter)
+	// the limiters are created again when the limits of the API change, e.g. on a template reload
+	if !ok || limiter.limits != limits {
+		limiter = &apiRateLimiter{limits: limits, limiters: httpclient.NewRateLimiters(limits)}
+		_ = apiRateLimiters.Set(ctx, name, limiter)
+	}
+	apiRateLimitersLock.Unlock()
+	return limiter.limiters.Acquire(ctx, key)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telepair/telepair/pkg/httpclient"
+	"github.com/telepair/telepair/pkg/utils"
+)
+
+func TestRateLimit_Parse(t *testing.T) {
+	limits := httpclient.RateLimitConfig{Requests: 1}
+	assert.NoError(t, (&RateLimit{RateLimitConfig: limits}).Parse())
+	assert.NoError(t, (&RateLimit{RateLimitConfig: limits, Scope: RateLimitScopeHost}).Parse())
+	assert.Error(t, (&RateLimit{RateLimitConfig: limits, Scope: RateLimitScopeKey}).Parse())
+	assert.Error(t, (&RateLimit{RateLimitConfig: limits, Scope: "tenant"}).Parse())
+	assert.Error(t, (&RateLimit{}).Parse())
+}
+
+func TestAPI_DoWithRateLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	limits := httpclient.RateLimitConfig{Requests: 1, Per: time.Hour, FailFast: true}
+	tests := []struct {
+		name  string
+		limit RateLimit
+	}{
+		{name: "api", limit: RateLimit{RateLimitConfig: limits}},
+		{name: "host", limit: RateLimit{RateLimitConfig: limits, Scope: RateLimitScopeHost}},
+		{name: "key", limit: RateLimit{RateLimitConfig: limits, Scope: RateLimitScopeKey, Key: "tenant-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &API{Name: "rate-limit-" + tt.name, Method: "GET", URL: ts.URL, Config: Config{RateLimit: &tt.limit}}
+			require.NoError(t, api.Parse())
+			resp, err := api.Do()
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+
+			_, err = api.Do()
+			var limitErr *httpclient.RateLimitError
+			require.ErrorAs(t, err, &limitErr)
+			assert.Greater(t, limitErr.RetryAfter, time.Duration(0))
+
+			// waiting for the token exceeds the timeout, the call fails without waiting
+			api.Config.Timeout = 10 * time.Millisecond
+			_, err = api.Do(WithRateLimitFailFast(false))
+			assert.ErrorIs(t, err, httpclient.ErrRateLimited)
+		})
+	}
+}
+
+func TestAPI_DoWithRateLimitConcurrency(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	api := &API{
+		Name:   "rate-limit-concurrency",
+		Method: "GET",
+		URL:    ts.URL,
+		Config: Config{RateLimit: &RateLimit{RateLimitConfig: httpclient.RateLimitConfig{Concurrency: 1}}},
+	}
+	require.NoError(t, api.Parse())
+
+	// the slot is held until the response body is closed
+	resp, err := api.Do()
+	require.NoError(t, err)
+	_, err = api.Do(WithRateLimitFailFast(true))
+	assert.ErrorIs(t, err, httpclient.ErrRateLimited)
+	_ = resp.Body.Close()
+
+	resp, err = api.Do(WithRateLimitFailFast(true))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+}
+
+func TestAPI_RateLimitNamespace(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	limit := func(requests int) *RateLimit {
+		return &RateLimit{
+			RateLimitConfig: httpclient.RateLimitConfig{Requests: requests, Per: time.Hour, FailFast: true},
+			Scope:           RateLimitScopeKey,
+			Key:             "tenant-a",
+		}
+	}
+	do := func(api *API) error {
+		resp, err := api.Do()
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+	a := &API{Name: "rate-limit-namespace-a", Method: "GET", URL: ts.URL, Config: Config{RateLimit: limit(1)}}
+	b := &API{Name: "rate-limit-namespace-b", Method: "GET", URL: ts.URL, Config: Config{RateLimit: limit(1)}}
+	require.NoError(t, a.Parse())
+	require.NoError(t, b.Parse())
+
+	// the same key of another API with the same limits is counted apart
+	require.NoError(t, do(a))
+	assert.ErrorIs(t, do(a), httpclient.ErrRateLimited)
+	require.NoError(t, do(b))
+
+	// the new limits of the API apply at once
+	a.Config.RateLimit = limit(2)
+	require.NoError(t, do(a))
+	require.NoError(t, do(a))
+	assert.ErrorIs(t, do(a), httpclient.ErrRateLimited)
+}
+
+func TestTemplate_RenderRateLimitKey(t *testing.T) {
+	tmpl := Template{
+		Name:   "rate-limit",
+		Engine: utils.EngineGo,
+		API: API{
+			Method: "GET",
+			URL:    "http://example.com",
+			Config: Config{RateLimit: &RateLimit{
+				RateLimitConfig: httpclient.RateLimitConfig{Requests: 1},
+				Scope:           RateLimitScopeKey,
+				Key:             "vendor-{{ .tenant }}",
+			}},
+		},
+		Vars: []VarRequired{{Name: "tenant"}},
+	}
+	require.NoError(t, tmpl.Parse())
+	api, err := tmpl.Render(map[string]string{"tenant": "a"})
+	require.NoError(t, err)
+	assert.Equal(t, "vendor-a", api.Config.RateLimit.Key)
+	assert.Equal(t, "rate-limit", api.template)
+	assert.Equal(t, "vendor-{{ .tenant }}", tmpl.API.Config.RateLimit.Key)
+}
@@ -1,124 +1,111 @@
 package api
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
-
-	"gopkg.in/yaml.v2"
-
-	"github.com/telepair/telepair/pkg/cache"
-)
-
-var (
-	apiCache         = cache.NewMemory("api-proxy")
-	apiTemplateCache = cache.NewMemory("api-proxy-template")
 )
 
 // RegisterAPI registers the API
 func RegisterAPI(api API) error {
-	if err := api.Parse(); err != nil {
-		return err
-	}
-	ok, err := apiCache.Exists(context.Background(), api.Name)
-	if err != nil {
-		return err
-	}
-	if ok {
-		return fmt.Errorf("api %s already exists", api.Name)
-	}
-	return apiCache.Set(context.Background(), api.Name, api)
+	return DefaultRegistry.RegisterAPI(api)
+}
+
+// UpdateAPI replaces the registered API, see Registry.UpdateAPI
+func UpdateAPI(api API, version uint64) (uint64, error) {
+	return DefaultRegistry.UpdateAPI(api, version)
+}
+
+// GetAPI returns the registered API
+func GetAPI(name string) (APIEntry, error) {
+	return DefaultRegistry.GetAPI(name)
+}
+
+// ListAPIs returns the registered APIs sorted by name
+func ListAPIs() []APIEntry {
+	return DefaultRegistry.ListAPIs()
+}
+
+// UnregisterAPI removes the registered API
+func UnregisterAPI(name string) error {
+	return DefaultRegistry.UnregisterAPI(name)
 }
 
 // Do returns the response of the API
-func Do(name string) (*http.Response, error) {
-	val, err := apiCache.Get(context.Background(), name)
-	if err != nil {
-		return nil, err
-	}
-	api := val.(API)
-	return api.Do()
+func Do(name string, opts ...Option) (*http.Response, error) {
+	return DefaultRegistry.Do(name, opts...)
 }
 
 // RegisterTemplate registers the API template
 func RegisterTemplate(template Template) error {
-	if err := template.Parse(); err != nil {
-		return err
-	}
-	ok, err := apiTemplateCache.Exists(context.Background(), template.Name)
-	if err != nil {
-		return err
-	}
-	if ok {
-		return fmt.Errorf("api template %s already exists", template.Name)
-	}
-	return apiTemplateCache.Set(context.Background(), template.Name, template)
+	return DefaultRegistry.RegisterTemplate(template)
+}
+
+// UpdateTemplate replaces the registered API template, see Registry.UpdateTemplate
+func UpdateTemplate(template Template, version uint64) (uint64, error) {
+	return DefaultRegistry.UpdateTemplate(template, version)
+}
+
+// GetTemplate returns the registered API template
+func GetTemplate(name string) (TemplateEntry, error) {
+	return DefaultRegistry.GetTemplate(name)
+}
+
+// ListTemplates returns the registered API templates sorted by name
+func ListTemplates() []TemplateEntry {
+	return DefaultRegistry.ListTemplates()
+}
+
+// UnregisterTemplate removes the registered API template
+func UnregisterTemplate(name string) error {
+	return DefaultRegistry.UnregisterTemplate(name)
 }
 
 // DoTemplate renders the API template and returns the response
-func DoTemplate(name string, vars map[string]string) (*http.Response, error) {
-	val, err := apiTemplateCache.Get(context.Background(), name)
-	if err != nil {
-		return nil, err
-	}
-	template := val.(Template)
-	api, err := template.Render(vars)
-	if err != nil {
-		return nil, err
-	}
-	return api.Do()
+func DoTemplate(name string, vars map[string]string, opts ...Option) (*http.Response, error) {
+	return DefaultRegistry.DoTemplate(name, vars, opts...)
+}
+
+// DoTemplateParsed renders the API template, executes it and returns the parsed response.
+// If the template defines extract, the body is the transformed JSON document.
+func DoTemplateParsed(name string, vars map[string]string, opts ...Option) (mediaType string, body []byte, err error) {
+	return DefaultRegistry.DoTemplateParsed(name, vars, opts...)
 }
 
 // RegisterAPIData registers the API data
 func RegisterAPIData(dataType string, data []byte) error {
-	dataType = strings.ToLower(strings.TrimSpace(dataType))
-	var apis []API
-	switch dataType {
-	case "yaml", "yml":
-		err := yaml.Unmarshal(data, &apis)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal yaml: %w", err)
-		}
-	case "json":
-		err := json.Unmarshal(data, &apis)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal json: %w", err)
-		}
-	default:
-		return fmt.Errorf("unsupported data type: %s", dataType)
-	}
-	for _, api := range apis {
-		if err := RegisterAPI(api); err != nil {
-			return fmt.Errorf("failed to register api: %w", err)
-		}
-	}
-	return nil
+	return DefaultRegistry.RegisterAPIData(dataType, data)
 }
 
 // RegisterAPITemplateData registers the API template data
 func RegisterAPITemplateData(dataType string, data []byte) error {
-	dataType = strings.ToLower(strings.TrimSpace(dataType))
-	var templates []Template
-	switch dataType {
-	case "yaml", "yml":
-		err := yaml.Unmarshal(data, &templates)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal yaml: %w", err)
-		}
-	case "json":
-		err := json.Unmarshal(data, &templates)
-		if err != nil {
-			return fmt.Errorf("failed to unmarshal json: %w", err)
-		}
-	default:
-		return fmt.Errorf("unsupported data type: %s", dataType)
-	}
-	for _, template := range templates {
-		if err := RegisterTemplate(template); err != nil {
-			return fmt.Errorf("failed to register api template: %w", err)
-		}
-	}
-	return nil
+	return DefaultRegistry.RegisterAPITemplateData(dataType, data)
+}
+
+// RegisterWorkflow registers the workflow
+func RegisterWorkflow(workflow Workflow) error {
+	return DefaultRegistry.RegisterWorkflow(workflow)
+}
+
+// GetWorkflow returns the registered workflow
+func GetWorkflow(name string) (Workflow, error) {
+	return DefaultRegistry.GetWorkflow(name)
+}
+
+// ListWorkflows returns the registered workflows sorted by name
+func ListWorkflows() []Workflow {
+	return DefaultRegistry.ListWorkflows()
+}
+
+// UnregisterWorkflow removes the registered workflow
+func UnregisterWorkflow(name string) error {
+	return DefaultRegistry.UnregisterWorkflow(name)
+}
+
+// RunWorkflow runs the registered workflow with the registered templates
+func RunWorkflow(name string, vars map[string]string, opts ...Option) (*WorkflowResult, error) {
+	return DefaultRegistry.RunWorkflow(name, vars, opts...)
+}
+
+// RegisterWorkflowData registers the workflow data
+func RegisterWorkflowData(dataType string, data []byte) error {
+	return DefaultRegistry.RegisterWorkflowData(dataType, data)
 }
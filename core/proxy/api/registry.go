@@ -0,0 +1,395 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrNotFound is the error returned when the API or template is not registered
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is the error returned when the API or template is already registered
+	ErrAlreadyExists = errors.New("already exists")
+	// ErrVersionConflict is the error returned when the version to update is not the current one
+	ErrVersionConflict = errors.New("version conflict")
+)
+
+// DefaultRegistry is the registry used by the package level functions
+var DefaultRegistry = NewRegistry()
+
+// APIEntry is a registered API
+type APIEntry struct {
+	API       API       `json:"api"`
+	Version   uint64    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TemplateEntry is a registered API template
+type TemplateEntry struct {
+	Template  Template  `json:"template"`
+	Version   uint64    `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Registry holds the APIs and templates by name.
+// Every registered item has a version starting at 1, incremented on each update,
+// so concurrent updates can be detected with the version read before.
+type Registry struct {
+	mu        sync.RWMutex
+	apis      map[string]APIEntry
+	templates map[string]TemplateEntry
+	workflows map[string]Workflow
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		apis:      make(map[string]APIEntry),
+		templates: make(map[string]TemplateEntry),
+		workflows: make(map[string]Workflow),
+	}
+}
+
+// RegisterAPI registers the API, it fails if the name is already registered
+func (r *Registry) RegisterAPI(api API) error {
+	if err := parseAPI(&api); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.apis[api.Name]; ok {
+		return fmt.Errorf("api %s %w", api.Name, ErrAlreadyExists)
+	}
+	r.apis[api.Name] = APIEntry{API: api, Version: 1, UpdatedAt: time.Now()}
+	return nil
+}
+
+// UpdateAPI replaces the registered API and returns the new version.
+// If version is not 0, it must be the current version of the API.
+func (r *Registry) UpdateAPI(api API, version uint64) (uint64, error) {
+	if err := parseAPI(&api); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.apis[api.Name]
+	if !ok {
+		return 0, fmt.Errorf("api %s %w", api.Name, ErrNotFound)
+	}
+	if version != 0 && version != entry.Version {
+		return 0, fmt.Errorf("api %s %w: current version is %d", api.Name, ErrVersionConflict, entry.Version)
+	}
+	entry = APIEntry{API: api, Version: entry.Version + 1, UpdatedAt: time.Now()}
+	r.apis[api.Name] = entry
+	return entry.Version, nil
+}
+
+// GetAPI returns the registered API
+func (r *Registry) GetAPI(name string) (APIEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.apis[name]
+	if !ok {
+		return APIEntry{}, fmt.Errorf("api %s %w", name, ErrNotFound)
+	}
+	return entry, nil
+}
+
+// ListAPIs returns the registered APIs sorted by name
+func (r *Registry) ListAPIs() []APIEntry {
+	r.mu.RLock()
+	entries := make([]APIEntry, 0, len(r.apis))
+	for _, entry := range r.apis {
+		entries = append(entries, entry)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(entries, func(a, b APIEntry) int {
+		return strings.Compare(a.API.Name, b.API.Name)
+	})
+	return entries
+}
+
+// UnregisterAPI removes the registered API
+func (r *Registry) UnregisterAPI(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.apis[name]; !ok {
+		return fmt.Errorf("api %s %w", name, ErrNotFound)
+	}
+	delete(r.apis, name)
+	return nil
+}
+
+// RegisterTemplate registers the API template, it fails if the name is already registered
+func (r *Registry) RegisterTemplate(template Template) error {
+	if err := template.Parse(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.templates[template.Name]; ok {
+		return fmt.Errorf("api template %s %w", template.Name, ErrAlreadyExists)
+	}
+	r.templates[template.Name] = TemplateEntry{Template: template, Version: 1, UpdatedAt: time.Now()}
+	return nil
+}
+
+// UpdateTemplate replaces the registered API template and returns the new version.
+// If version is not 0, it must be the current version of the template.
+func (r *Registry) UpdateTemplate(template Template, version uint64) (uint64, error) {
+	if err := template.Parse(); err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.templates[template.Name]
+	if !ok {
+		return 0, fmt.Errorf("api template %s %w", template.Name, ErrNotFound)
+	}
+	if version != 0 && version != entry.Version {
+		return 0, fmt.Errorf("api template %s %w: current version is %d", template.Name, ErrVersionConflict, entry.Version)
+	}
+	entry = TemplateEntry{Template: template, Version: entry.Version + 1, UpdatedAt: time.Now()}
+	r.templates[template.Name] = entry
+	return entry.Version, nil
+}
+
+// GetTemplate returns the registered API template
+func (r *Registry) GetTemplate(name string) (TemplateEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.templates[name]
+	if !ok {
+		return TemplateEntry{}, fmt.Errorf("api template %s %w", name, ErrNotFound)
+	}
+	return entry, nil
+}
+
+// ListTemplates returns the registered API templates sorted by name
+func (r *Registry) ListTemplates() []TemplateEntry {
+	r.mu.RLock()
+	entries := make([]TemplateEntry, 0, len(r.templates))
+	for _, entry := range r.templates {
+		entries = append(entries, entry)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(entries, func(a, b TemplateEntry) int {
+		return strings.Compare(a.Template.Name, b.Template.Name)
+	})
+	return entries
+}
+
+// UnregisterTemplate removes the registered API template
+func (r *Registry) UnregisterTemplate(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.templates[name]; !ok {
+		return fmt.Errorf("api template %s %w", name, ErrNotFound)
+	}
+	delete(r.templates, name)
+	return nil
+}
+
+// ReplaceTemplates validates the templates and replaces all the registered templates
+// with them at once. If any template is invalid, the registered templates are kept.
+// The version of a template registered before is incremented.
+func (r *Registry) ReplaceTemplates(templates []Template) error {
+	entries := make(map[string]TemplateEntry, len(templates))
+	now := time.Now()
+	for _, template := range templates {
+		if err := template.Parse(); err != nil {
+			return fmt.Errorf("api template %s is invalid: %w", template.Name, err)
+		}
+		if _, ok := entries[template.Name]; ok {
+			return fmt.Errorf("api template %s is duplicated", template.Name)
+		}
+		entries[template.Name] = TemplateEntry{Template: template, Version: 1, UpdatedAt: now}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, entry := range entries {
+		if old, ok := r.templates[name]; ok {
+			entry.Version = old.Version + 1
+			entries[name] = entry
+		}
+	}
+	r.templates = entries
+	return nil
+}
+
+// Do returns the response of the registered API
+func (r *Registry) Do(name string, opts ...Option) (*http.Response, error) {
+	entry, err := r.GetAPI(name)
+	if err != nil {
+		return nil, err
+	}
+	return entry.API.Do(opts...)
+}
+
+// DoTemplate renders the registered API template and returns the response
+func (r *Registry) DoTemplate(name string, vars map[string]string, opts ...Option) (*http.Response, error) {
+	_, resp, err := r.doTemplate(name, vars, opts...)
+	return resp, err
+}
+
+// DoTemplateParsed renders the registered API template, executes it and returns the parsed response.
+// If the template defines extract, the body is the transformed JSON document.
+func (r *Registry) DoTemplateParsed(name string, vars map[string]string, opts ...Option) (mediaType string, body []byte, err error) {
+	template, resp, err := r.doTemplate(name, vars, opts...)
+	if err != nil {
+		// the response failing the checker is returned with the error
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return "", nil, err
+	}
+	return template.ParseResponse(resp)
+}
+
+func (r *Registry) doTemplate(name string, vars map[string]string, opts ...Option) (Template, *http.Response, error) {
+	entry, err := r.GetTemplate(name)
+	if err != nil {
+		return Template{}, nil, err
+	}
+	api, err := entry.Template.Render(vars)
+	if err != nil {
+		return entry.Template, nil, err
+	}
+	resp, err := api.Do(opts...)
+	return entry.Template, resp, err
+}
+
+// RegisterWorkflow registers the workflow, it fails if the name is already registered.
+// The templates of the steps are looked up when the workflow runs.
+func (r *Registry) RegisterWorkflow(workflow Workflow) error {
+	if err := workflow.Parse(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workflows[workflow.Name]; ok {
+		return fmt.Errorf("workflow %s %w", workflow.Name, ErrAlreadyExists)
+	}
+	r.workflows[workflow.Name] = workflow
+	return nil
+}
+
+// GetWorkflow returns the registered workflow
+func (r *Registry) GetWorkflow(name string) (Workflow, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	workflow, ok := r.workflows[name]
+	if !ok {
+		return Workflow{}, fmt.Errorf("workflow %s %w", name, ErrNotFound)
+	}
+	return workflow, nil
+}
+
+// ListWorkflows returns the registered workflows sorted by name
+func (r *Registry) ListWorkflows() []Workflow {
+	r.mu.RLock()
+	workflows := make([]Workflow, 0, len(r.workflows))
+	for _, workflow := range r.workflows {
+		workflows = append(workflows, workflow)
+	}
+	r.mu.RUnlock()
+	slices.SortFunc(workflows, func(a, b Workflow) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return workflows
+}
+
+// UnregisterWorkflow removes the registered workflow
+func (r *Registry) UnregisterWorkflow(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.workflows[name]; !ok {
+		return fmt.Errorf("workflow %s %w", name, ErrNotFound)
+	}
+	delete(r.workflows, name)
+	return nil
+}
+
+// RunWorkflow runs the registered workflow with the templates of the registry
+func (r *Registry) RunWorkflow(name string, vars map[string]string, opts ...Option) (*WorkflowResult, error) {
+	workflow, err := r.GetWorkflow(name)
+	if err != nil {
+		return nil, err
+	}
+	return workflow.Run(r, vars, opts...)
+}
+
+// RegisterAPIData registers the APIs in the yaml or json data
+func (r *Registry) RegisterAPIData(dataType string, data []byte) error {
+	var apis []API
+	if err := unmarshalData(dataType, data, &apis); err != nil {
+		return err
+	}
+	for _, api := range apis {
+		if err := r.RegisterAPI(api); err != nil {
+			return fmt.Errorf("failed to register api: %w", err)
+		}
+	}
+	return nil
+}
+
+// RegisterAPITemplateData registers the API templates in the yaml or json data
+func (r *Registry) RegisterAPITemplateData(dataType string, data []byte) error {
+	var templates []Template
+	if err := unmarshalData(dataType, data, &templates); err != nil {
+		return err
+	}
+	for _, template := range templates {
+		if err := r.RegisterTemplate(template); err != nil {
+			return fmt.Errorf("failed to register api template: %w", err)
+		}
+	}
+	return nil
+}
+
+// RegisterWorkflowData registers the workflows in the yaml or json data
+func (r *Registry) RegisterWorkflowData(dataType string, data []byte) error {
+	var workflows []Workflow
+	if err := unmarshalData(dataType, data, &workflows); err != nil {
+		return err
+	}
+	for _, workflow := range workflows {
+		if err := r.RegisterWorkflow(workflow); err != nil {
+			return fmt.Errorf("failed to register workflow: %w", err)
+		}
+	}
+	return nil
+}
+
+func parseAPI(api *API) error {
+	api.Name = strings.TrimSpace(api.Name)
+	if api.Name == "" {
+		return errors.New("api name is required")
+	}
+	return api.Parse()
+}
+
+func unmarshalData(dataType string, data []byte, v any) error {
+	switch strings.ToLower(strings.TrimSpace(dataType)) {
+	case "yaml", "yml":
+		if err := yaml.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to unmarshal yaml: %w", err)
+		}
+	case "json":
+		if err := json.Unmarshal(data, v); err != nil {
+			return fmt.Errorf("failed to unmarshal json: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported data type: %s", dataType)
+	}
+	return nil
+}
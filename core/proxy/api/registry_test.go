@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleRegistry() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello " + r.URL.Query().Get("name")))
+	}))
+	defer server.Close()
+
+	registry := NewRegistry()
+	_ = registry.RegisterTemplate(Template{
+		Name:          "hello",
+		API:           API{Method: http.MethodGet, URL: server.URL + "?name={{ name }}"},
+		TemplateField: TemplateField{URL: true},
+		Vars:          []VarRequired{{Name: "name", Default: "world"}},
+	})
+	resp, err := registry.DoTemplate("hello", nil)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	fmt.Println(string(body))
+	// Output: hello world
+}
+
+func TestRegistry_API(t *testing.T) {
+	r := NewRegistry()
+	api := API{Name: "a", Method: http.MethodGet, URL: "https://example.com/a"}
+
+	assert.Error(t, r.RegisterAPI(API{Method: http.MethodGet, URL: "https://example.com"}), "name is required")
+	assert.NoError(t, r.RegisterAPI(api))
+	assert.ErrorIs(t, r.RegisterAPI(api), ErrAlreadyExists)
+	assert.NoError(t, r.RegisterAPI(API{Name: "b", Method: http.MethodGet, URL: "https://example.com/b"}))
+
+	entry, err := r.GetAPI("a")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), entry.Version)
+	assert.Equal(t, DefaultTimeout, entry.API.Config.Timeout)
+
+	api.URL = "https://example.com/a2"
+	version, err := r.UpdateAPI(api, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+	_, err = r.UpdateAPI(api, 1)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	version, err = r.UpdateAPI(api, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), version)
+	_, err = r.UpdateAPI(API{Name: "c", Method: http.MethodGet, URL: "https://example.com"}, 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = r.UpdateAPI(API{Name: "a", Method: "INVALID", URL: "https://example.com"}, 0)
+	assert.Error(t, err)
+
+	entry, err = r.GetAPI("a")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/a2", entry.API.URL)
+
+	entries := r.ListAPIs()
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "a", entries[0].API.Name)
+	assert.Equal(t, "b", entries[1].API.Name)
+
+	assert.NoError(t, r.UnregisterAPI("a"))
+	assert.ErrorIs(t, r.UnregisterAPI("a"), ErrNotFound)
+	_, err = r.GetAPI("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = r.Do("a")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRegistry_Template(t *testing.T) {
+	r := NewRegistry()
+	template := Template{Name: "t", API: API{Method: http.MethodGet, URL: "https://example.com/t"}}
+
+	assert.NoError(t, r.RegisterTemplate(template))
+	assert.ErrorIs(t, r.RegisterTemplate(template), ErrAlreadyExists)
+	assert.Error(t, r.RegisterTemplate(Template{API: API{Method: http.MethodGet, URL: "https://example.com"}}))
+
+	template.API.URL = "https://example.com/t2"
+	version, err := r.UpdateTemplate(template, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), version)
+	_, err = r.UpdateTemplate(template, 1)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	_, err = r.UpdateTemplate(Template{Name: "x", API: API{Method: http.MethodGet, URL: "https://example.com"}}, 0)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	entry, err := r.GetTemplate("t")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/t2", entry.Template.API.URL)
+	assert.Len(t, r.ListTemplates(), 1)
+
+	assert.NoError(t, r.UnregisterTemplate("t"))
+	assert.ErrorIs(t, r.UnregisterTemplate("t"), ErrNotFound)
+	_, err = r.DoTemplate("t", nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, r.ListTemplates())
+}
+
+func TestRegistry_RegisterData(t *testing.T) {
+	r := NewRegistry()
+	assert.NoError(t, r.RegisterAPIData("yaml", []byte(`
+- name: a
+  method: GET
+  url: https://example.com/a
+`)))
+	assert.NoError(t, r.RegisterAPITemplateData("json", []byte(`[{"name": "t", "api": {"method": "GET", "url": "https://example.com/{{ id }}"}, "template_field": {"url": true}, "vars": [{"name": "id", "can_empty": true}]}]`)))
+	assert.Error(t, r.RegisterAPIData("toml", nil))
+	assert.Error(t, r.RegisterAPITemplateData("json", []byte(`{`)))
+
+	entry, err := r.GetTemplate("t")
+	assert.NoError(t, err)
+	assert.True(t, entry.Template.Vars[0].CanEmpty)
+	assert.Len(t, r.ListAPIs(), 1)
+}
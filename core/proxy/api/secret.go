@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrSecretNotFound is the error returned when the secret does not exist
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider resolves the value of a variable source, e.g. `vault:secret/data/token`
+// is resolved by the provider registered with scheme `vault` and key `secret/data/token`.
+type SecretProvider interface {
+	GetSecret(ctx context.Context, key string) (string, error)
+}
+
+// SecretProviderFunc is a function that implements SecretProvider
+type SecretProviderFunc func(ctx context.Context, key string) (string, error)
+
+// GetSecret implements SecretProvider
+func (f SecretProviderFunc) GetSecret(ctx context.Context, key string) (string, error) {
+	return f(ctx, key)
+}
+
+var (
+	secretProviders = map[string]SecretProvider{
+		"env":  SecretProviderFunc(envSecret),
+		"file": SecretProviderFunc(fileSecret),
+	}
+	secretProvidersLock sync.RWMutex
+)
+
+// RegisterSecretProvider registers the secret provider for the scheme,
+// it replaces the provider registered before with the same scheme.
+func RegisterSecretProvider(scheme string, provider SecretProvider) error {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	if scheme == "" {
+		return errors.New("secret provider scheme is required")
+	}
+	if provider == nil {
+		return errors.New("secret provider is nil")
+	}
+	secretProvidersLock.Lock()
+	defer secretProvidersLock.Unlock()
+	secretProviders[scheme] = provider
+	return nil
+}
+
+// ParseSource parses the variable source into scheme and key
+func ParseSource(source string) (scheme, key string, err error) {
+	scheme, key, ok := strings.Cut(strings.TrimSpace(source), ":")
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	key = strings.TrimSpace(key)
+	if !ok || scheme == "" || key == "" {
+		return "", "", fmt.Errorf("source %q is invalid, expect <scheme>:<key>", source)
+	}
+	secretProvidersLock.RLock()
+	_, ok = secretProviders[scheme]
+	secretProvidersLock.RUnlock()
+	if !ok {
+		return "", "", fmt.Errorf("source %q is invalid, unknown scheme %s", source, scheme)
+	}
+	return scheme, key, nil
+}
+
+// ResolveSource returns the value of the variable source
+func ResolveSource(ctx context.Context, source string) (string, error) {
+	scheme, key, err := ParseSource(source)
+	if err != nil {
+		return "", err
+	}
+	secretProvidersLock.RLock()
+	provider := secretProviders[scheme]
+	secretProvidersLock.RUnlock()
+	return provider.GetSecret(ctx, key)
+}
+
+func envSecret(_ context.Context, key string) (string, error) {
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return "", fmt.Errorf("%w: env %s", ErrSecretNotFound, key)
+	}
+	return val, nil
+}
+
+func fileSecret(_ context.Context, key string) (string, error) {
+	data, err := os.ReadFile(key)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%w: file %s", ErrSecretNotFound, key)
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		source     string
+		wantScheme string
+		wantKey    string
+		wantErr    bool
+	}{
+		{source: "env:API_TOKEN", wantScheme: "env", wantKey: "API_TOKEN"},
+		{source: " FILE:/run/secrets/token ", wantScheme: "file", wantKey: "/run/secrets/token"},
+		{source: "API_TOKEN", wantErr: true},
+		{source: "env:", wantErr: true},
+		{source: "unknown:key", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			scheme, key, err := ParseSource(tt.source)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantScheme, scheme)
+			assert.Equal(t, tt.wantKey, key)
+		})
+	}
+}
+
+func TestResolveSource(t *testing.T) {
+	t.Setenv("TELEPAIR_TEST_TOKEN", "env-token")
+	val, err := ResolveSource(context.Background(), "env:TELEPAIR_TEST_TOKEN")
+	assert.NoError(t, err)
+	assert.Equal(t, "env-token", val)
+
+	_, err = ResolveSource(context.Background(), "env:TELEPAIR_TEST_NOT_EXISTS")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+
+	file := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(file, []byte("file-token\n"), 0o600))
+	val, err = ResolveSource(context.Background(), "file:"+file)
+	assert.NoError(t, err)
+	assert.Equal(t, "file-token", val)
+
+	_, err = ResolveSource(context.Background(), "file:"+file+".missing")
+	assert.ErrorIs(t, err, ErrSecretNotFound)
+}
+
+func TestRegisterSecretProvider(t *testing.T) {
+	assert.Error(t, RegisterSecretProvider("", SecretProviderFunc(nil)))
+	assert.Error(t, RegisterSecretProvider("test", nil))
+
+	err := RegisterSecretProvider("test-vault", SecretProviderFunc(func(_ context.Context, key string) (string, error) {
+		if key == "broken" {
+			return "", errors.New("permission denied")
+		}
+		return "vault-" + key, nil
+	}))
+	assert.NoError(t, err)
+
+	val, err := ResolveSource(context.Background(), "test-vault:token")
+	assert.NoError(t, err)
+	assert.Equal(t, "vault-token", val)
+
+	v := VarRequired{Name: "token", Source: "test-vault:broken", Default: "x"}
+	assert.NoError(t, v.Validate())
+	_, _, verr := v.Resolve(context.Background(), nil)
+	assert.NotNil(t, verr)
+}
+
+func TestTemplate_RenderSecrets(t *testing.T) {
+	t.Setenv("TELEPAIR_TEST_TOKEN", "s3cr3t")
+
+	var gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tmpl := Template{
+		Name: "test",
+		API: API{
+			Method:  "GET",
+			URL:     server.URL,
+			Headers: map[string]string{"Authorization": "Bearer {{ token }}"},
+		},
+		TemplateField: TemplateField{Headers: map[string]bool{"Authorization": true}},
+		Vars: []VarRequired{
+			{Name: "token", Source: "env:TELEPAIR_TEST_TOKEN"},
+			{Name: "user", Default: "john"},
+		},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	api, err := tmpl.Render(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"s3cr3t"}, api.secrets)
+	_, err = api.Do()
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer s3cr3t", gotAuth)
+
+	// the given value overrides the source
+	api, err = tmpl.Render(map[string]string{"token": "given"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer given", api.Headers["Authorization"])
+	assert.Empty(t, api.secrets)
+
+	// secret values are masked in validation errors
+	tmpl.Vars[0].Pattern = "^[a-z]+$"
+	_, err = tmpl.Render(nil)
+	var errs VarErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Equal(t, "******", errs[0].Value)
+}
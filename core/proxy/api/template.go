@@ -1,22 +1,27 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"slices"
 	"strings"
 
+	"github.com/telepair/telepair/pkg/httpclient"
 	"github.com/telepair/telepair/pkg/utils"
 )
 
-// Template represents an API template that can be rendered with variables
+// Template represents an API template that can be rendered with variables.
+// The default engine only substitutes `{{ name }}`, the go engine supports
+// filters and conditionals, see utils.TemplateFuncs.
 // Example:
 //
 //	template := Template{
 //	    Name: "my-api",
+//	    Engine: utils.EngineGo,
 //	    API: API{
 //	        Method: "GET",
-//	        URL: "https://api.example.com/users/{{.userId}}",
+//	        URL: "https://api.example.com/users/{{ .userId | urlpath }}",
 //	    },
 //	    TemplateField: TemplateField{
 //	        URL: true,
@@ -27,36 +32,25 @@ import (
 //	}
 type Template struct {
 	Name          string        `yaml:"name" json:"name"`
+	Engine        utils.Engine  `yaml:"engine,omitempty" json:"engine,omitempty"`
 	API           API           `yaml:"api" json:"api"`
 	TemplateField TemplateField `yaml:"template_field" json:"template_field"`
 	Vars          []VarRequired `yaml:"vars" json:"vars"`
-}
+	Extract       []Extract     `yaml:"extract,omitempty" json:"extract,omitempty"`
+	Transform     string        `yaml:"transform,omitempty" json:"transform,omitempty"`
 
-// TemplateField is a field for API template
-type TemplateField struct {
-	Method  bool            `yaml:"method"`
-	URL     bool            `yaml:"url"`
-	Headers map[string]bool `yaml:"headers"`
-	Body    bool            `yaml:"body"`
+	// refs are the variables the go templates reference, see typedVars
+	refs []string
 }
 
-// VarRequired is a variable for API template
-type VarRequired struct {
-	Name     string   `yaml:"name"`
-	Options  []string `yaml:"options"`
-	Default  string   `yaml:"default"`
-	CanEmpty bool     `yaml:"can_empty"`
-}
-
-// Validate validates the variable
-func (v *VarRequired) Validate() error {
-	if v.Name == "" {
-		return errors.New("variable name is required")
-	}
-	if v.Default != "" && len(v.Options) > 0 && !slices.Contains(v.Options, v.Default) {
-		return fmt.Errorf("variable %s default value %s is not in options", v.Name, v.Default)
-	}
-	return nil
+// TemplateField is a field for API template.
+// Auth renders the credentials of config.auth, e.g. `token: "{{ token }}"`.
+type TemplateField struct {
+	Method  bool            `yaml:"method" json:"method"`
+	URL     bool            `yaml:"url" json:"url"`
+	Headers map[string]bool `yaml:"headers" json:"headers"`
+	Body    bool            `yaml:"body" json:"body"`
+	Auth    bool            `yaml:"auth,omitempty" json:"auth,omitempty"`
 }
 
 // Parse parses the API template
@@ -65,8 +59,8 @@ func (t *Template) Parse() error {
 		return errors.New("name is required")
 	}
 
-	for _, v := range t.Vars {
-		if err := v.Validate(); err != nil {
+	for i := range t.Vars {
+		if err := t.Vars[i].Validate(); err != nil {
 			return err
 		}
 	}
@@ -91,24 +85,48 @@ func (t *Template) Parse() error {
 		}
 	}
 
-	if t.TemplateField.Body && t.API.Body == "" {
+	if t.TemplateField.Body && t.API.Body == "" && t.API.BodySource == nil {
 		return errors.New("body template is required")
 	}
 
+	engine, err := utils.ParseEngine(string(t.Engine))
+	if err != nil {
+		return err
+	}
+	t.Engine = engine
+	if err := t.parseGoTemplates(); err != nil {
+		return err
+	}
+
+	names := make(map[string]struct{}, len(t.Extract))
+	for i := range t.Extract {
+		if err := t.Extract[i].Parse(); err != nil {
+			return err
+		}
+		if _, ok := names[t.Extract[i].Name]; ok {
+			return fmt.Errorf("extract %s is duplicated", t.Extract[i].Name)
+		}
+		names[t.Extract[i].Name] = struct{}{}
+	}
+	if t.Transform != "" && len(t.Extract) == 0 {
+		return errors.New("transform requires extract")
+	}
+
 	t.API.Name = ""
 
 	return nil
 }
 
 // Render renders the API template
-func (t *Template) Render(vars map[string]string) (api API, err error) {
-	vars, err = t.MergeVars(vars)
+func (t *Template) Render(values map[string]string) (api API, err error) {
+	merged, secrets, err := t.mergeVars(values)
 	if err != nil {
 		return
 	}
+	vars := renderVars{strs: merged, typed: t.typedVars(merged)}
 	api = t.API
 	if t.TemplateField.Method {
-		api.Method, err = utils.SimpleRender(t.API.Method, vars)
+		api.Method, err = t.render(t.API.Method, vars)
 		if err != nil {
 			return
 		}
@@ -118,69 +136,211 @@ func (t *Template) Render(vars map[string]string) (api API, err error) {
 		return
 	}
 
-	for key, val := range api.Headers {
+	api.Headers = make(map[string]string, len(t.API.Headers))
+	for key, val := range t.API.Headers {
 		if t.TemplateField.Headers[key] {
-			api.Headers[key], err = utils.SimpleRender(val, vars)
+			val, err = t.render(val, vars)
 			if err != nil {
 				return
 			}
 		}
+		api.Headers[key] = val
 	}
 	if t.TemplateField.Body {
-		api.Body, err = utils.SimpleRender(t.API.Body, vars)
+		api.Body, err = t.render(t.API.Body, vars)
 		if err != nil {
 			return
 		}
 	}
+	if t.API.BodySource != nil {
+		// the rendered API gets its own body source, the template one is kept
+		api.BodySource = t.API.BodySource.clone()
+		if t.TemplateField.Body {
+			err = api.BodySource.render(func(tmpl string) (string, error) {
+				return t.render(tmpl, vars)
+			})
+			if err != nil {
+				return
+			}
+		}
+	}
+	if t.TemplateField.Auth {
+		for name, field := range api.Config.Auth.templateFields() {
+			*field, err = t.render(*field, vars)
+			if err != nil {
+				return api, fmt.Errorf("failed to render auth %s template: %w", name, err)
+			}
+		}
+	}
+	if limit := t.API.Config.RateLimit; limit != nil && limit.Key != "" {
+		// the rendered API gets its own rate limit, the template one is kept
+		rendered := *limit
+		if rendered.Key, err = t.render(limit.Key, vars); err != nil {
+			return api, fmt.Errorf("failed to render rate limit key template: %w", err)
+		}
+		api.Config.RateLimit = &rendered
+	}
 	api.Name = fmt.Sprintf("%s::%s", t.Name, utils.UUIDv7().String())
+	api.secrets = secrets
+	api.template = t.Name
 	if err := api.Parse(); err != nil {
 		return api, err
 	}
 	return api, nil
 }
 
-// MergeVars merges the variables
+// MergeVars merges the variables with the sources and defaults and validates them.
+// The returned error is VarErrors with one entry per invalid variable.
 func (t *Template) MergeVars(vars map[string]string) (map[string]string, error) {
-	merged := make(map[string]string)
+	merged, _, err := t.mergeVars(vars)
+	return merged, err
+}
+
+// mergeVars merges the variables and returns the secret values to mask
+func (t *Template) mergeVars(vars map[string]string) (map[string]string, []string, error) {
+	merged := make(map[string]string, len(t.Vars))
+	var secrets []string
+	var errs VarErrors
 	for _, v := range t.Vars {
-		val, ok := vars[v.Name]
-		if !ok {
-			if v.Default != "" {
-				val = v.Default
-			} else if v.CanEmpty {
-				val = ""
-			} else {
-				return nil, fmt.Errorf("variable %s is required", v.Name)
-			}
+		val, secret, err := v.Resolve(context.Background(), vars)
+		if err == nil {
+			err = v.Check(val)
 		}
-
-		if !v.CanEmpty && val == "" {
-			return nil, fmt.Errorf("variable %s is required", v.Name)
+		if err != nil {
+			if secret {
+				err.Value = httpclient.SecretMask
+			}
+			errs = append(errs, err)
+			continue
 		}
-		if len(v.Options) > 0 && !slices.Contains(v.Options, val) {
-			return nil, fmt.Errorf("variable %s is invalid, not in options", v.Name)
+		if secret && val != "" {
+			secrets = append(secrets, val)
 		}
-
 		merged[v.Name] = val
 	}
-	return merged, nil
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	return merged, secrets, nil
+}
+
+// typedVars converts the merged variables to their declared types.
+// The referenced variables that are not set are empty, so `default` applies to them.
+func (t *Template) typedVars(vars map[string]string) map[string]any {
+	data := make(map[string]any, len(vars)+len(t.refs))
+	for _, name := range t.refs {
+		data[name] = ""
+	}
+	for k, v := range vars {
+		data[k] = v
+	}
+	for _, v := range t.Vars {
+		if val, ok := vars[v.Name]; ok && val != "" {
+			if typed, err := v.Convert(val); err == nil {
+				data[v.Name] = typed
+			}
+		}
+	}
+	return data
 }
 
-func (t *Template) renderURL(api *API, vars map[string]string) (err error) {
+func (t *Template) renderURL(api *API, vars renderVars) (err error) {
 	if !t.TemplateField.URL {
 		return nil
 	}
 	if t.API.URL != "" {
-		api.URL, err = utils.SimpleRender(t.API.URL, vars)
+		api.URL, err = t.render(t.API.URL, vars)
 		if err != nil {
 			return errors.Join(err, fmt.Errorf("failed to render URL template %s", t.API.URL))
 		}
 	}
-	for i, url := range api.URLs {
-		api.URLs[i], err = utils.SimpleRender(url, vars)
+	api.URLs = make([]string, len(t.API.URLs))
+	for i, url := range t.API.URLs {
+		api.URLs[i], err = t.render(url, vars)
 		if err != nil {
 			return errors.Join(err, fmt.Errorf("failed to render URL template %s", url))
 		}
 	}
+	// the weights are declared for the url templates
+	if weights := t.API.Config.Fallback.Weights; len(weights) > 0 {
+		api.Config.Fallback.Weights = make(map[string]int, len(weights))
+		for i, url := range t.API.URLs {
+			if weight, ok := weights[url]; ok {
+				api.Config.Fallback.Weights[api.URLs[i]] = weight
+			}
+		}
+	}
+	return nil
+}
+
+// renderVars holds the merged variables, as strings for the simple engine
+// and converted to their declared types for the go engine
+type renderVars struct {
+	strs  map[string]string
+	typed map[string]any
+}
+
+// render renders the field template with the template engine
+func (t *Template) render(tmpl string, vars renderVars) (string, error) {
+	if t.Engine == utils.EngineGo {
+		return utils.GoRender(tmpl, vars.typed)
+	}
+	return utils.SimpleRender(tmpl, vars.strs)
+}
+
+// parseGoTemplates checks the syntax of the templated fields for the go engine
+func (t *Template) parseGoTemplates() error {
+	t.refs = nil
+	if t.Engine != utils.EngineGo {
+		return nil
+	}
+	fields := map[string]string{}
+	if t.TemplateField.Method {
+		fields["method"] = t.API.Method
+	}
+	if t.TemplateField.URL {
+		fields["url"] = t.API.URL
+		for i, url := range t.API.URLs {
+			fields[fmt.Sprintf("urls[%d]", i)] = url
+		}
+	}
+	for key, val := range t.TemplateField.Headers {
+		if val {
+			fields["header "+key] = t.API.Headers[key]
+		}
+	}
+	if t.TemplateField.Body {
+		fields["body"] = t.API.Body
+		if t.API.BodySource != nil {
+			err := t.API.BodySource.clone().render(func(tmpl string) (string, error) {
+				refs, err := utils.GoTemplateVars(tmpl)
+				t.refs = append(t.refs, refs...)
+				return tmpl, err
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if t.TemplateField.Auth {
+		for name, field := range t.API.Config.Auth.templateFields() {
+			fields["auth "+name] = *field
+		}
+	}
+	if t.Transform != "" {
+		fields["transform"] = t.Transform
+	}
+	if limit := t.API.Config.RateLimit; limit != nil && limit.Key != "" {
+		fields["rate limit key"] = limit.Key
+	}
+	for name, tmpl := range fields {
+		refs, err := utils.GoTemplateVars(tmpl)
+		if err != nil {
+			return fmt.Errorf("%s template is invalid: %w", name, err)
+		}
+		t.refs = append(t.refs, refs...)
+	}
+	slices.Sort(t.refs)
+	t.refs = slices.Compact(t.refs)
 	return nil
 }
@@ -345,3 +345,69 @@ func TestTemplate_MergeVars(t *testing.T) {
 		})
 	}
 }
+
+func TestTemplate_RenderGoEngine(t *testing.T) {
+	tmpl := Template{
+		Name:   "test",
+		Engine: "go",
+		API: API{
+			Method: "POST",
+			URL:    "http://example.com/search?q={{ .q | urlquery }}",
+			Headers: map[string]string{
+				"X-Lang": `{{ .lang | default "en" }}`,
+			},
+			Body: `{"q": {{ .q | json }}{{ if .lang }}, "lang": {{ .lang | json }}{{ end }}}`,
+		},
+		TemplateField: TemplateField{
+			URL:     true,
+			Headers: map[string]bool{"X-Lang": true},
+			Body:    true,
+		},
+		Vars: []VarRequired{
+			{Name: "q"},
+			{Name: "lang", CanEmpty: true},
+		},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	api, err := tmpl.Render(map[string]string{"q": `say "hi" & bye`})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/search?q=say+%22hi%22+%26+bye", api.URL)
+	assert.Equal(t, "en", api.Headers["X-Lang"])
+	assert.Equal(t, `{"q": "say \"hi\" & bye"}`, api.Body)
+	// the template itself is not modified by rendering
+	assert.Equal(t, `{{ .lang | default "en" }}`, tmpl.API.Headers["X-Lang"])
+
+	api, err = tmpl.Render(map[string]string{"q": "x", "lang": "zh"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"q": "x", "lang": "zh"}`, api.Body)
+
+	tmpl.API.Body = `{"q": {{ .q | json }`
+	assert.Error(t, tmpl.Parse())
+
+	tmpl.API.Body = `{}`
+	tmpl.Engine = "jinja"
+	assert.Error(t, tmpl.Parse())
+}
+
+func TestTemplate_RenderFallbackWeights(t *testing.T) {
+	tmpl := Template{
+		Name: "test",
+		API: API{
+			Method: "GET",
+			URLs:   []string{"http://a.com/{{ip}}", "http://b.com/{{ip}}"},
+			Config: Config{Fallback: Fallback{
+				Selector: "weighted",
+				Weights:  map[string]int{"http://a.com/{{ip}}": 3},
+			}},
+		},
+		TemplateField: TemplateField{URL: true},
+		Vars:          []VarRequired{{Name: "ip"}},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	api, err := tmpl.Render(map[string]string{"ip": "1.1.1.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"http://a.com/1.1.1.1": 3}, api.Config.Fallback.Weights)
+	assert.Equal(t, map[string]int{"http://a.com/{{ip}}": 3}, tmpl.API.Config.Fallback.Weights)
+}
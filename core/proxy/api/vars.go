@@ -0,0 +1,271 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// VarType is the type of a template variable
+type VarType string
+
+const (
+	VarTypeString   VarType = "string"
+	VarTypeInt      VarType = "int"
+	VarTypeFloat    VarType = "float"
+	VarTypeBool     VarType = "bool"
+	VarTypeEnum     VarType = "enum"
+	VarTypeDuration VarType = "duration"
+	VarTypeEmail    VarType = "email"
+	VarTypeURL      VarType = "url"
+)
+
+// VarRequired is a variable for API template.
+// Min and Max bound the value of int and float variables, the length of
+// string, enum, email and url variables, and the seconds of duration variables.
+// Source resolves the value when it is not given, e.g. `env:API_TOKEN`,
+// `file:/run/secrets/token` or any scheme registered by RegisterSecretProvider.
+// Values resolved from the source, or of a Secret variable, are masked in logs and dumps.
+// Example:
+//
+//	vars:
+//	  - name: count
+//	    type: int
+//	    description: number of items to fetch
+//	    default: "10"
+//	    min: 1
+//	    max: 100
+//	  - name: user
+//	    pattern: "^[a-z][a-z0-9_]*$"
+//	  - name: token
+//	    source: env:API_TOKEN
+type VarRequired struct {
+	Name        string   `yaml:"name" json:"name"`
+	Type        VarType  `yaml:"type,omitempty" json:"type,omitempty"`
+	Description string   `yaml:"description,omitempty" json:"description,omitempty"`
+	Options     []string `yaml:"options" json:"options"`
+	Default     string   `yaml:"default" json:"default"`
+	CanEmpty    bool     `yaml:"can_empty" json:"can_empty"`
+	Min         *float64 `yaml:"min,omitempty" json:"min,omitempty"`
+	Max         *float64 `yaml:"max,omitempty" json:"max,omitempty"`
+	Pattern     string   `yaml:"pattern,omitempty" json:"pattern,omitempty"`
+	Source      string   `yaml:"source,omitempty" json:"source,omitempty"`
+	Secret      bool     `yaml:"secret,omitempty" json:"secret,omitempty"`
+
+	// re is the compiled pattern
+	re *regexp.Regexp
+}
+
+// Validate validates the variable
+func (v *VarRequired) Validate() error {
+	if v.Name == "" {
+		return errors.New("variable name is required")
+	}
+	v.Type = VarType(strings.ToLower(strings.TrimSpace(string(v.Type))))
+	switch v.Type {
+	case "":
+		v.Type = VarTypeString
+	case VarTypeString, VarTypeInt, VarTypeFloat, VarTypeBool, VarTypeDuration, VarTypeEmail, VarTypeURL:
+	case VarTypeEnum:
+		if len(v.Options) == 0 {
+			return fmt.Errorf("variable %s of type enum requires options", v.Name)
+		}
+	default:
+		return fmt.Errorf("variable %s type %s is invalid", v.Name, v.Type)
+	}
+	if v.Pattern != "" {
+		re, err := regexp.Compile(v.Pattern)
+		if err != nil {
+			return fmt.Errorf("variable %s pattern is invalid: %w", v.Name, err)
+		}
+		v.re = re
+	}
+	if v.Source != "" {
+		if _, _, err := ParseSource(v.Source); err != nil {
+			return fmt.Errorf("variable %s %w", v.Name, err)
+		}
+	}
+	if v.Min != nil && v.Max != nil && *v.Min > *v.Max {
+		return fmt.Errorf("variable %s min %v is greater than max %v", v.Name, *v.Min, *v.Max)
+	}
+	if v.Default != "" && len(v.Options) > 0 && !slices.Contains(v.Options, v.Default) {
+		return fmt.Errorf("variable %s default value %s is not in options", v.Name, v.Default)
+	}
+	if v.Default != "" {
+		if err := v.Check(v.Default); err != nil {
+			return fmt.Errorf("variable %s default value is invalid: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value of the variable, the given value first,
+// then the value of the source and the default value at last.
+// secret reports whether the value should be masked.
+func (v *VarRequired) Resolve(ctx context.Context, vars map[string]string) (val string, secret bool, err *VarError) {
+	if val, ok := vars[v.Name]; ok {
+		return val, v.Secret, nil
+	}
+	if v.Source != "" {
+		val, err := ResolveSource(ctx, v.Source)
+		if err == nil {
+			return val, true, nil
+		}
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", true, v.newError("", fmt.Sprintf("failed to resolve source %s: %v", v.Source, err))
+		}
+	}
+	return v.Default, v.Secret, nil
+}
+
+// Check checks the value of the variable
+func (v *VarRequired) Check(val string) *VarError {
+	if val == "" {
+		if v.CanEmpty {
+			return nil
+		}
+		return v.newError(val, "is required")
+	}
+	if len(v.Options) > 0 && !slices.Contains(v.Options, val) {
+		return v.newError(val, fmt.Sprintf("is invalid, not in options %v", v.Options))
+	}
+	if v.Pattern != "" {
+		re := v.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(v.Pattern); err != nil {
+				return v.newError(val, "has an invalid pattern")
+			}
+		}
+		if !re.MatchString(val) {
+			return v.newError(val, fmt.Sprintf("does not match pattern %s", v.Pattern))
+		}
+	}
+
+	typed, err := v.Convert(val)
+	if err != nil {
+		return v.newError(val, err.Error())
+	}
+	return v.checkRange(val, typed)
+}
+
+// Convert converts the value to the type of the variable:
+// int64 for int, float64 for float, bool for bool, time.Duration for duration
+// and string for the others.
+func (v *VarRequired) Convert(val string) (any, error) {
+	switch v.Type {
+	case VarTypeInt:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return n, nil
+	case VarTypeFloat:
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+		return n, nil
+	case VarTypeBool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, errors.New("must be a boolean")
+		}
+		return b, nil
+	case VarTypeDuration:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return nil, errors.New("must be a duration, e.g. 30s")
+		}
+		return d, nil
+	case VarTypeEmail:
+		addr, err := mail.ParseAddress(val)
+		if err != nil || addr.Address != val {
+			return nil, errors.New("must be an email address")
+		}
+		return val, nil
+	case VarTypeURL:
+		u, err := url.Parse(val)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, errors.New("must be an absolute url")
+		}
+		return val, nil
+	default:
+		return val, nil
+	}
+}
+
+func (v *VarRequired) checkRange(val string, typed any) *VarError {
+	if v.Min == nil && v.Max == nil {
+		return nil
+	}
+
+	var n float64
+	unit := "length"
+	switch x := typed.(type) {
+	case int64:
+		n, unit = float64(x), "value"
+	case float64:
+		n, unit = x, "value"
+	case time.Duration:
+		n, unit = x.Seconds(), "seconds"
+	case bool:
+		return nil
+	default:
+		n = float64(utf8.RuneCountInString(val))
+	}
+
+	if v.Min != nil && n < *v.Min {
+		return v.newError(val, fmt.Sprintf("%s must be >= %v", unit, *v.Min))
+	}
+	if v.Max != nil && n > *v.Max {
+		return v.newError(val, fmt.Sprintf("%s must be <= %v", unit, *v.Max))
+	}
+	return nil
+}
+
+func (v *VarRequired) newError(val, reason string) *VarError {
+	return &VarError{
+		Name:        v.Name,
+		Value:       val,
+		Reason:      reason,
+		Description: v.Description,
+	}
+}
+
+// VarError is the validation error of a template variable
+type VarError struct {
+	Name        string `json:"name"`
+	Value       string `json:"value,omitempty"`
+	Reason      string `json:"reason"`
+	Description string `json:"description,omitempty"`
+}
+
+// Error implements the error interface
+func (e *VarError) Error() string {
+	msg := fmt.Sprintf("variable %s %s", e.Name, e.Reason)
+	if e.Description != "" {
+		msg += fmt.Sprintf(" (%s)", e.Description)
+	}
+	return msg
+}
+
+// VarErrors is a list of variable validation errors
+type VarErrors []*VarError
+
+// Error implements the error interface
+func (e VarErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestVarRequired_ValidateTyped(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       VarRequired
+		want    VarType
+		wantErr bool
+	}{
+		{name: "default type", v: VarRequired{Name: "a"}, want: VarTypeString},
+		{name: "pattern", v: VarRequired{Name: "a", Pattern: "^[a-z]+$"}, want: VarTypeString},
+		{name: "upper type", v: VarRequired{Name: "a", Type: "INT", Default: "1"}, want: VarTypeInt},
+		{name: "invalid type", v: VarRequired{Name: "a", Type: "date"}, wantErr: true},
+		{name: "enum without options", v: VarRequired{Name: "a", Type: VarTypeEnum}, wantErr: true},
+		{name: "invalid pattern", v: VarRequired{Name: "a", Pattern: "("}, wantErr: true},
+		{name: "min greater than max", v: VarRequired{Name: "a", Min: float(2), Max: float(1)}, wantErr: true},
+		{name: "invalid default type", v: VarRequired{Name: "a", Type: VarTypeInt, Default: "x"}, wantErr: true},
+		{name: "default out of range", v: VarRequired{Name: "a", Type: VarTypeInt, Default: "0", Min: float(1)}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.v.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, tt.v.Type)
+			assert.Equal(t, tt.v.Pattern != "", tt.v.re != nil, "the pattern is compiled once")
+		})
+	}
+}
+
+func TestVarRequired_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       VarRequired
+		val     string
+		wantErr bool
+	}{
+		{name: "string", v: VarRequired{Name: "a"}, val: "x"},
+		{name: "empty required", v: VarRequired{Name: "a"}, val: "", wantErr: true},
+		{name: "empty allowed", v: VarRequired{Name: "a", Type: VarTypeInt, CanEmpty: true}, val: ""},
+		{name: "string length", v: VarRequired{Name: "a", Max: float(3)}, val: "abcd", wantErr: true},
+		{name: "pattern", v: VarRequired{Name: "a", Pattern: "^[a-z]+$"}, val: "abc"},
+		{name: "pattern mismatch", v: VarRequired{Name: "a", Pattern: "^[a-z]+$"}, val: "ABC", wantErr: true},
+		{name: "int", v: VarRequired{Name: "a", Type: VarTypeInt, Min: float(1), Max: float(10)}, val: "10"},
+		{name: "int invalid", v: VarRequired{Name: "a", Type: VarTypeInt}, val: "1.5", wantErr: true},
+		{name: "int too large", v: VarRequired{Name: "a", Type: VarTypeInt, Max: float(10)}, val: "11", wantErr: true},
+		{name: "float", v: VarRequired{Name: "a", Type: VarTypeFloat, Min: float(0.5)}, val: "0.5"},
+		{name: "float too small", v: VarRequired{Name: "a", Type: VarTypeFloat, Min: float(0.5)}, val: "0.4", wantErr: true},
+		{name: "bool", v: VarRequired{Name: "a", Type: VarTypeBool}, val: "true"},
+		{name: "bool invalid", v: VarRequired{Name: "a", Type: VarTypeBool}, val: "yes", wantErr: true},
+		{name: "enum", v: VarRequired{Name: "a", Type: VarTypeEnum, Options: []string{"x", "y"}}, val: "y"},
+		{name: "enum invalid", v: VarRequired{Name: "a", Type: VarTypeEnum, Options: []string{"x", "y"}}, val: "z", wantErr: true},
+		{name: "duration", v: VarRequired{Name: "a", Type: VarTypeDuration, Max: float(60)}, val: "30s"},
+		{name: "duration too long", v: VarRequired{Name: "a", Type: VarTypeDuration, Max: float(60)}, val: "2m", wantErr: true},
+		{name: "duration invalid", v: VarRequired{Name: "a", Type: VarTypeDuration}, val: "30", wantErr: true},
+		{name: "email", v: VarRequired{Name: "a", Type: VarTypeEmail}, val: "john@example.com"},
+		{name: "email invalid", v: VarRequired{Name: "a", Type: VarTypeEmail}, val: "John <john@example.com>", wantErr: true},
+		{name: "url", v: VarRequired{Name: "a", Type: VarTypeURL}, val: "https://example.com/a"},
+		{name: "url invalid", v: VarRequired{Name: "a", Type: VarTypeURL}, val: "example.com/a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.v.Check(tt.val)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+				assert.Equal(t, tt.v.Name, err.Name)
+				assert.Equal(t, tt.val, err.Value)
+				return
+			}
+			assert.Nil(t, err)
+		})
+	}
+}
+
+func TestVarRequired_Convert(t *testing.T) {
+	tests := []struct {
+		v    VarRequired
+		val  string
+		want any
+	}{
+		{v: VarRequired{Type: VarTypeString}, val: "x", want: "x"},
+		{v: VarRequired{Type: VarTypeInt}, val: "42", want: int64(42)},
+		{v: VarRequired{Type: VarTypeFloat}, val: "1.5", want: 1.5},
+		{v: VarRequired{Type: VarTypeBool}, val: "false", want: false},
+		{v: VarRequired{Type: VarTypeDuration}, val: "1m", want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.v.Type), func(t *testing.T) {
+			got, err := tt.v.Convert(tt.val)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTemplate_MergeVarsErrors(t *testing.T) {
+	tmpl := Template{
+		Name: "test",
+		API:  API{Method: "GET", URL: "http://example.com"},
+		Vars: []VarRequired{
+			{Name: "count", Type: VarTypeInt, Description: "number of items", Max: float(100)},
+			{Name: "user"},
+			{Name: "lang", Default: "en"},
+		},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	_, err := tmpl.MergeVars(map[string]string{"count": "1000"})
+	var errs VarErrors
+	assert.True(t, errors.As(err, &errs))
+	assert.Len(t, errs, 2)
+	assert.Equal(t, "count", errs[0].Name)
+	assert.Equal(t, "value must be <= 100", errs[0].Reason)
+	assert.Equal(t, "user", errs[1].Name)
+	assert.EqualError(t, err, "variable count value must be <= 100 (number of items); variable user is required")
+
+	merged, err := tmpl.MergeVars(map[string]string{"count": "10", "user": "john"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"count": "10", "user": "john", "lang": "en"}, merged)
+}
+
+func TestTemplate_RenderTypedVars(t *testing.T) {
+	tmpl := Template{
+		Name:   "test",
+		Engine: "go",
+		API: API{
+			Method: "POST",
+			URL:    "http://example.com",
+			Body:   `{"count": {{ .count | json }}, "debug": {{ .debug | json }}, "name": {{ .name | json }}}`,
+		},
+		TemplateField: TemplateField{Body: true},
+		Vars: []VarRequired{
+			{Name: "count", Type: VarTypeInt},
+			{Name: "debug", Type: VarTypeBool, Default: "false"},
+			{Name: "name"},
+		},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	api, err := tmpl.Render(map[string]string{"count": "3", "name": "42"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"count": 3, "debug": false, "name": "42"}`, api.Body)
+}
+
+func TestTemplate_RenderUndeclaredDefault(t *testing.T) {
+	tmpl := Template{
+		Name:   "test",
+		Engine: "go",
+		API: API{
+			Method: "GET",
+			URL:    `http://example.com/?lang={{ .lang | default "en" }}&user={{ .user }}`,
+		},
+		TemplateField: TemplateField{URL: true},
+		Vars:          []VarRequired{{Name: "user"}},
+	}
+	assert.NoError(t, tmpl.Parse())
+
+	api, err := tmpl.Render(map[string]string{"user": "john"})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://example.com/?lang=en&user=john", api.URL)
+}
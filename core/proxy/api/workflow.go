@@ -0,0 +1,504 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telepair/telepair/pkg/utils"
+)
+
+// stepNamePattern is the pattern of the step names, referenced as `{{ .name.value }}` in the go templates
+var stepNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// StepStatus is the status of a workflow step
+type StepStatus string
+
+const (
+	StepSucceeded StepStatus = "succeeded"
+	StepFailed    StepStatus = "failed"
+	// StepSkipped is the status of a step whose condition is false, it has no values
+	StepSkipped StepStatus = "skipped"
+	// StepCanceled is the status of a step not run because another step failed
+	StepCanceled StepStatus = "canceled"
+)
+
+// Workflow is a list of API template calls, the later steps use the values extracted
+// from the responses of the earlier ones, e.g. login, then fetch a token, then call.
+// The steps run in order, unless a step declares depends_on: then the steps run as a DAG,
+// each step starting once its dependencies succeeded or were skipped.
+//
+// The step vars, conditions and output are rendered with the workflow variables and the
+// values of the steps: the extracted values of the template, or the body if it has no extract.
+// Example:
+//
+//	name: "user-orders"
+//	engine: go
+//	vars:
+//	  - name: user
+//	steps:
+//	  - name: login
+//	    template: "login"
+//	  - name: orders
+//	    template: "orders"
+//	    when: '{{ ne .login.token "" }}'
+//	    vars:
+//	      token: "{{ .login.token }}"
+//	      user: "{{ .user }}"
+//	    retry:
+//	      max: 2
+//	      delay: 1s
+//	output: '{"user": {{ .user | json }}, "orders": {{ .orders.items | json }}}'
+type Workflow struct {
+	Name   string         `yaml:"name" json:"name"`
+	Engine utils.Engine   `yaml:"engine,omitempty" json:"engine,omitempty"`
+	Vars   []VarRequired  `yaml:"vars,omitempty" json:"vars,omitempty"`
+	Steps  []WorkflowStep `yaml:"steps" json:"steps"`
+	// Output is the JSON document of the result, rendered like the template transform.
+	// The values of the steps keyed by step name by default.
+	Output string `yaml:"output,omitempty" json:"output,omitempty"`
+
+	// refs are the variables the go templates reference, see Template.typedVars
+	refs []string
+}
+
+// WorkflowStep is a call of an API template
+type WorkflowStep struct {
+	Name     string `yaml:"name" json:"name"`
+	Template string `yaml:"template" json:"template"`
+	// Vars are the variables of the template, rendered with the workflow values
+	Vars map[string]string `yaml:"vars,omitempty" json:"vars,omitempty"`
+	// DependsOn are the steps run before the step, it makes the workflow a DAG
+	DependsOn []string `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
+	// When is the condition of the step, it is skipped if it renders empty, false, 0 or no
+	When  string        `yaml:"when,omitempty" json:"when,omitempty"`
+	Retry WorkflowRetry `yaml:"retry,omitempty" json:"retry,omitempty"`
+}
+
+// WorkflowRetry retries a failed step, the retries of the http client are made before
+type WorkflowRetry struct {
+	Max   int           `yaml:"max,omitempty" json:"max,omitempty"`
+	Delay time.Duration `yaml:"delay,omitempty" json:"delay,omitempty"`
+}
+
+// StepResult is the result of a workflow step
+type StepResult struct {
+	Name     string         `json:"name"`
+	Status   StepStatus     `json:"status"`
+	Attempts int            `json:"attempts,omitempty"`
+	Duration time.Duration  `json:"duration,omitempty"`
+	Values   map[string]any `json:"values,omitempty"`
+	Err      error          `json:"-"`
+}
+
+// WorkflowResult is the result of a workflow, the steps are in the declared order
+type WorkflowResult struct {
+	Output []byte       `json:"output,omitempty"`
+	Steps  []StepResult `json:"steps"`
+}
+
+// Parse checks the workflow and its steps
+func (w *Workflow) Parse() error {
+	if w.Name == "" {
+		return errors.New("workflow name is required")
+	}
+	engine, err := utils.ParseEngine(string(w.Engine))
+	if err != nil {
+		return err
+	}
+	w.Engine = engine
+	vars := make(map[string]struct{}, len(w.Vars))
+	for i := range w.Vars {
+		if err := w.Vars[i].Validate(); err != nil {
+			return err
+		}
+		vars[w.Vars[i].Name] = struct{}{}
+	}
+	if len(w.Steps) == 0 {
+		return fmt.Errorf("workflow %s requires steps", w.Name)
+	}
+
+	names := make(map[string]struct{}, len(w.Steps))
+	for _, s := range w.Steps {
+		if !stepNamePattern.MatchString(s.Name) {
+			return fmt.Errorf("workflow step name %q is invalid", s.Name)
+		}
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("workflow step %s is duplicated", s.Name)
+		}
+		if _, ok := vars[s.Name]; ok {
+			return fmt.Errorf("workflow step %s has the name of a variable", s.Name)
+		}
+		names[s.Name] = struct{}{}
+		if s.Template == "" {
+			return fmt.Errorf("workflow step %s template is required", s.Name)
+		}
+		if s.Retry.Max < 0 || s.Retry.Delay < 0 {
+			return fmt.Errorf("workflow step %s retry must not be negative", s.Name)
+		}
+	}
+	for _, s := range w.Steps {
+		for _, dep := range s.DependsOn {
+			if _, ok := names[dep]; !ok {
+				return fmt.Errorf("workflow step %s depends on unknown step %s", s.Name, dep)
+			}
+		}
+	}
+	if err := w.checkCycles(); err != nil {
+		return err
+	}
+	return w.parseGoTemplates()
+}
+
+// checkCycles checks that the dependencies of the steps have no cycle
+func (w *Workflow) checkCycles() error {
+	deps := w.dependencies()
+	indegree := make(map[string]int, len(w.Steps))
+	for _, s := range w.Steps {
+		indegree[s.Name] = len(deps[s.Name])
+	}
+	var ready []string
+	for _, s := range w.Steps {
+		if indegree[s.Name] == 0 {
+			ready = append(ready, s.Name)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, s := range w.Steps {
+			for _, dep := range deps[s.Name] {
+				if dep == name {
+					if indegree[s.Name]--; indegree[s.Name] == 0 {
+						ready = append(ready, s.Name)
+					}
+				}
+			}
+		}
+	}
+	if visited != len(w.Steps) {
+		return fmt.Errorf("workflow %s steps have a dependency cycle", w.Name)
+	}
+	return nil
+}
+
+// dependencies returns the dependencies of the steps, every step depends on the previous one
+// unless a step declares depends_on
+func (w *Workflow) dependencies() map[string][]string {
+	deps := make(map[string][]string, len(w.Steps))
+	dag := false
+	for _, s := range w.Steps {
+		if len(s.DependsOn) > 0 {
+			dag = true
+			break
+		}
+	}
+	for i, s := range w.Steps {
+		switch {
+		case dag:
+			deps[s.Name] = s.DependsOn
+		case i > 0:
+			deps[s.Name] = []string{w.Steps[i-1].Name}
+		}
+	}
+	return deps
+}
+
+// parseGoTemplates checks the syntax of the templated fields for the go engine
+func (w *Workflow) parseGoTemplates() error {
+	w.refs = nil
+	if w.Engine != utils.EngineGo {
+		return nil
+	}
+	fields := map[string]string{"output": w.Output}
+	for _, s := range w.Steps {
+		fields["step "+s.Name+" when"] = s.When
+		for k, v := range s.Vars {
+			fields["step "+s.Name+" var "+k] = v
+		}
+	}
+	for name, tmpl := range fields {
+		refs, err := utils.GoTemplateVars(tmpl)
+		if err != nil {
+			return fmt.Errorf("workflow %s template is invalid: %w", name, err)
+		}
+		w.refs = append(w.refs, refs...)
+	}
+	slices.Sort(w.refs)
+	w.refs = slices.Compact(w.refs)
+	return nil
+}
+
+// Run runs the workflow with the templates of the registry, the default registry if nil.
+// The options apply to every call, the context of WithContext cancels the workflow.
+// The result is returned with the error of the failed step.
+func (w *Workflow) Run(registry *Registry, vars map[string]string, opts ...Option) (*WorkflowResult, error) {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	cfg := &config{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	if cfg.ctx == nil {
+		cfg.ctx = context.Background()
+	}
+	for _, s := range w.Steps {
+		if _, err := registry.GetTemplate(s.Template); err != nil {
+			return nil, fmt.Errorf("workflow %s step %s: %w", w.Name, s.Name, err)
+		}
+	}
+	// the workflow variables are merged and typed like the variables of a template
+	tmpl := &Template{Name: w.Name, Engine: w.Engine, Vars: w.Vars, refs: w.refs}
+	merged, err := tmpl.MergeVars(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	run := &workflowRun{
+		workflow: w,
+		registry: registry,
+		tmpl:     tmpl,
+		opts:     opts,
+		inputs:   merged,
+		values:   make(map[string]map[string]any, len(w.Steps)),
+		results:  make(map[string]*StepResult, len(w.Steps)),
+	}
+	ctx, cancel := context.WithCancelCause(cfg.ctx)
+	defer cancel(nil)
+	run.execute(ctx, cancel)
+
+	result := &WorkflowResult{Steps: make([]StepResult, len(w.Steps))}
+	for i, s := range w.Steps {
+		result.Steps[i] = *run.results[s.Name]
+	}
+	if err := context.Cause(ctx); err != nil {
+		var stepErr *workflowStepError
+		if errors.As(err, &stepErr) {
+			return result, fmt.Errorf("workflow %s step %s failed: %w", w.Name, stepErr.step, stepErr.err)
+		}
+		return result, fmt.Errorf("workflow %s: %w", w.Name, err)
+	}
+
+	if w.Output == "" {
+		result.Output, err = json.Marshal(run.values)
+	} else {
+		output := &Template{Engine: w.Engine, Transform: w.Output}
+		result.Output, err = output.transform(run.outputValues(), utils.SimpleRenderPaths)
+	}
+	if err != nil {
+		return result, fmt.Errorf("workflow %s output: %w", w.Name, err)
+	}
+	return result, nil
+}
+
+// workflowStepError is the cancel cause of the workflow with a failed step
+type workflowStepError struct {
+	step string
+	err  error
+}
+
+func (e *workflowStepError) Error() string {
+	return fmt.Sprintf("step %s failed: %s", e.step, e.err)
+}
+
+// workflowRun is the state of a running workflow
+type workflowRun struct {
+	workflow *Workflow
+	registry *Registry
+	tmpl     *Template
+	opts     []Option
+	inputs   map[string]string
+
+	mu      sync.Mutex
+	values  map[string]map[string]any
+	results map[string]*StepResult
+}
+
+// execute runs every step once its dependencies are done, the first failure cancels the others
+func (r *workflowRun) execute(ctx context.Context, cancel context.CancelCauseFunc) {
+	deps := r.workflow.dependencies()
+	done := make(map[string]chan struct{}, len(r.workflow.Steps))
+	for _, s := range r.workflow.Steps {
+		done[s.Name] = make(chan struct{})
+		r.results[s.Name] = &StepResult{Name: s.Name, Status: StepCanceled}
+	}
+
+	var wg sync.WaitGroup
+	for i := range r.workflow.Steps {
+		step := &r.workflow.Steps[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[step.Name])
+			for _, dep := range deps[step.Name] {
+				select {
+				case <-done[dep]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if err := r.runStep(ctx, step); err != nil {
+				cancel(&workflowStepError{step: step.Name, err: err})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runStep runs the step with its retries and records its result
+func (r *workflowRun) runStep(ctx context.Context, step *WorkflowStep) error {
+	result := &StepResult{Name: step.Name}
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		r.mu.Lock()
+		r.results[step.Name] = result
+		if result.Status == StepSucceeded {
+			r.values[step.Name] = result.Values
+		}
+		r.mu.Unlock()
+	}()
+
+	vars := r.renderValues()
+	if step.When != "" {
+		cond, err := r.render(step.When, vars)
+		if err != nil {
+			result.Status, result.Err = StepFailed, fmt.Errorf("when: %w", err)
+			return result.Err
+		}
+		if !isTruthy(cond) {
+			result.Status = StepSkipped
+			return nil
+		}
+	}
+	stepVars := make(map[string]string, len(step.Vars))
+	for k, v := range step.Vars {
+		val, err := r.render(v, vars)
+		if err != nil {
+			result.Status, result.Err = StepFailed, fmt.Errorf("var %s: %w", k, err)
+			return result.Err
+		}
+		stepVars[k] = val
+	}
+
+	opts := append(append([]Option(nil), r.opts...), WithContext(ctx))
+	for {
+		result.Attempts++
+		values, err := r.call(step.Template, stepVars, opts)
+		if err == nil {
+			result.Status, result.Values = StepSucceeded, values
+			return nil
+		}
+		if result.Attempts > step.Retry.Max || ctx.Err() != nil {
+			result.Status, result.Err = StepFailed, err
+			return err
+		}
+		select {
+		case <-time.After(step.Retry.Delay):
+		case <-ctx.Done():
+			result.Status, result.Err = StepFailed, err
+			return err
+		}
+	}
+}
+
+// call calls the template and returns the extracted values, or the body if the template has no extract
+func (r *workflowRun) call(name string, vars map[string]string, opts []Option) (map[string]any, error) {
+	template, resp, err := r.registry.doTemplate(name, vars, opts...)
+	if err != nil {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		return nil, err
+	}
+	defer resp.Body.Close() //nolint:errcheck
+	if len(template.Extract) > 0 {
+		return template.ExtractValues(resp)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	var data any = string(body)
+	if isJSONResponse(resp) {
+		var decoded any
+		if json.Unmarshal(body, &decoded) == nil {
+			data = decoded
+		}
+	}
+	return map[string]any{"body": data}, nil
+}
+
+// renderValues returns the workflow variables and the values of the succeeded steps.
+// The go engine gets the step values as maps, the simple engine gets `step.name` variables.
+func (r *workflowRun) renderValues() renderVars {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	vars := renderVars{strs: make(map[string]string, len(r.inputs)), typed: r.tmpl.typedVars(r.inputs)}
+	for k, v := range r.inputs {
+		vars.strs[k] = v
+	}
+	for step, values := range r.values {
+		vars.typed[step] = values
+		for k, v := range values {
+			vars.strs[step+"."+k] = stringify(v)
+		}
+	}
+	return vars
+}
+
+// outputValues returns the values of the output, the raw values of renderValues
+func (r *workflowRun) outputValues() map[string]any {
+	vars := r.renderValues()
+	if r.workflow.Engine == utils.EngineGo {
+		return vars.typed
+	}
+	values := make(map[string]any, len(vars.strs))
+	for k, v := range r.inputs {
+		values[k] = v
+	}
+	for step, stepValues := range r.values {
+		for k, v := range stepValues {
+			values[step+"."+k] = v
+		}
+	}
+	return values
+}
+
+// render renders the field of the workflow, the simple engine takes the dotted `step.name` variables
+func (r *workflowRun) render(tmpl string, vars renderVars) (string, error) {
+	if r.tmpl.Engine == utils.EngineGo {
+		return utils.GoRender(tmpl, vars.typed)
+	}
+	return utils.SimpleRenderPaths(tmpl, vars.strs)
+}
+
+// isTruthy checks the rendered condition of a step
+func isTruthy(cond string) bool {
+	switch strings.ToLower(strings.TrimSpace(cond)) {
+	case "", "false", "0", "no", "null", "<no value>":
+		return false
+	}
+	return true
+}
+
+// isJSONResponse checks if the response content type is JSON
+func isJSONResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == MediaTypeJSON || strings.HasSuffix(mediaType, "+json")
+}
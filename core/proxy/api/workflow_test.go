@@ -0,0 +1,170 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telepair/telepair/pkg/utils"
+)
+
+func newWorkflowRegistry(t *testing.T) *Registry {
+	t.Helper()
+	var flaky atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/login":
+			_, _ = io.WriteString(w, `{"token": "abc"}`)
+		case "/orders":
+			if r.Header.Get("Authorization") != "Bearer abc" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = io.WriteString(w, `{"items": [1, 2]}`)
+		case "/flaky":
+			if flaky.Add(1) == 1 {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = io.WriteString(w, `{"ok": true}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	registry := NewRegistry()
+	for _, tmpl := range []Template{
+		{
+			Name:    "login",
+			API:     API{Method: "POST", URL: ts.URL + "/login"},
+			Extract: []Extract{{Name: "token", JSONPath: "$.token", Required: true}},
+		},
+		{
+			Name:          "orders",
+			API:           API{Method: "GET", URL: ts.URL + "/orders", Headers: map[string]string{"Authorization": "Bearer {{ token }}"}},
+			TemplateField: TemplateField{Headers: map[string]bool{"Authorization": true}},
+			Vars:          []VarRequired{{Name: "token"}},
+		},
+		{Name: "flaky", API: API{Method: "GET", URL: ts.URL + "/flaky"}},
+		{Name: "broken", API: API{Method: "GET", URL: ts.URL + "/broken"}},
+	} {
+		require.NoError(t, registry.RegisterTemplate(tmpl))
+	}
+	return registry
+}
+
+func TestWorkflow_Parse(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []WorkflowStep
+		wantErr bool
+	}{
+		{name: "ordered", steps: []WorkflowStep{{Name: "a", Template: "t"}, {Name: "b", Template: "t"}}},
+		{name: "dag", steps: []WorkflowStep{{Name: "a", Template: "t"}, {Name: "b", Template: "t"}, {Name: "c", Template: "t", DependsOn: []string{"a", "b"}}}},
+		{name: "no steps", wantErr: true},
+		{name: "invalid name", steps: []WorkflowStep{{Name: "a-b", Template: "t"}}, wantErr: true},
+		{name: "duplicated name", steps: []WorkflowStep{{Name: "a", Template: "t"}, {Name: "a", Template: "t"}}, wantErr: true},
+		{name: "no template", steps: []WorkflowStep{{Name: "a"}}, wantErr: true},
+		{name: "unknown dependency", steps: []WorkflowStep{{Name: "a", Template: "t", DependsOn: []string{"b"}}}, wantErr: true},
+		{name: "cycle", steps: []WorkflowStep{{Name: "a", Template: "t", DependsOn: []string{"b"}}, {Name: "b", Template: "t", DependsOn: []string{"a"}}}, wantErr: true},
+		{name: "negative retry", steps: []WorkflowStep{{Name: "a", Template: "t", Retry: WorkflowRetry{Max: -1}}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := Workflow{Name: "w", Steps: tt.steps}
+			err := w.Parse()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWorkflow_Run(t *testing.T) {
+	registry := newWorkflowRegistry(t)
+	require.NoError(t, registry.RegisterWorkflow(Workflow{
+		Name:   "orders",
+		Engine: utils.EngineGo,
+		Vars:   []VarRequired{{Name: "fetch", Default: "true"}},
+		Steps: []WorkflowStep{
+			{Name: "login", Template: "login"},
+			{Name: "skipped", Template: "broken", When: "{{ eq .login.token \"other\" }}"},
+			{Name: "orders", Template: "orders", When: "{{ .fetch }}", Vars: map[string]string{"token": "{{ .login.token }}"}},
+		},
+		// a skipped step has no values
+		Output: `{"token": {{ .login.token | json }}, "items": {{ if eq .fetch "true" }}{{ .orders.body.items | json }}{{ else }}[]{{ end }}}`,
+	}))
+
+	result, err := registry.RunWorkflow("orders", nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"token": "abc", "items": [1, 2]}`, string(result.Output))
+	if assert.Len(t, result.Steps, 3) {
+		assert.Equal(t, StepSucceeded, result.Steps[0].Status)
+		assert.Equal(t, StepSkipped, result.Steps[1].Status)
+		assert.Equal(t, StepSucceeded, result.Steps[2].Status)
+		assert.Equal(t, 1, result.Steps[2].Attempts)
+	}
+
+	result, err = registry.RunWorkflow("orders", map[string]string{"fetch": "false"})
+	require.NoError(t, err)
+	assert.Equal(t, StepSkipped, result.Steps[2].Status)
+	assert.JSONEq(t, `{"token": "abc", "items": []}`, string(result.Output))
+}
+
+func TestWorkflow_RunSimpleEngine(t *testing.T) {
+	registry := newWorkflowRegistry(t)
+	w := Workflow{
+		Name: "orders",
+		Steps: []WorkflowStep{
+			{Name: "login", Template: "login"},
+			{Name: "orders", Template: "orders", Vars: map[string]string{"token": "{{ login.token }}"}},
+		},
+	}
+	require.NoError(t, w.Parse())
+	result, err := w.Run(registry, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"login": {"token": "abc"}, "orders": {"body": {"items": [1, 2]}}}`, string(result.Output))
+
+	w.Output = `{"token": {{ login.token }}, "orders": {{ orders.body }}}`
+	result, err = w.Run(registry, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"token": "abc", "orders": {"items": [1, 2]}}`, string(result.Output))
+}
+
+func TestWorkflow_RunRetryAndFailure(t *testing.T) {
+	registry := newWorkflowRegistry(t)
+	w := Workflow{
+		Name: "dag",
+		Steps: []WorkflowStep{
+			{Name: "flaky", Template: "flaky", Retry: WorkflowRetry{Max: 1}},
+			{Name: "login", Template: "login"},
+			{Name: "broken", Template: "broken", DependsOn: []string{"flaky", "login"}},
+			{Name: "after", Template: "login", DependsOn: []string{"broken"}},
+		},
+	}
+	require.NoError(t, w.Parse())
+	result, err := w.Run(registry, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "step broken failed")
+	if assert.Len(t, result.Steps, 4) {
+		assert.Equal(t, StepSucceeded, result.Steps[0].Status)
+		assert.Equal(t, 2, result.Steps[0].Attempts)
+		assert.Equal(t, StepSucceeded, result.Steps[1].Status)
+		assert.Equal(t, StepFailed, result.Steps[2].Status)
+		assert.Error(t, result.Steps[2].Err)
+		assert.Equal(t, StepCanceled, result.Steps[3].Status)
+	}
+
+	// the templates are checked before any step runs
+	w.Steps[3].Template = "missing"
+	_, err = w.Run(registry, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,312 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// MediaTypeForm is the content type of the form-urlencoded bodies
+const MediaTypeForm = "application/x-www-form-urlencoded"
+
+// Body is a request body replayed from the start on each retry and fallback url.
+// The file and multipart bodies are streamed from the disk instead of being buffered.
+type Body struct {
+	// Open returns a new reader of the body from the start,
+	// a reader with a `Size() int64` method has the length of the opened body
+	Open func() (io.ReadCloser, error)
+	// Length is the length of the body, -1 if unknown, the size of the opened reader if any
+	Length int64
+	// ContentType is the content type of the body, empty to keep the request headers
+	ContentType string
+}
+
+// NewRequest creates the request with the body and the headers, the content type
+// of the body is set if the headers have none. A nil body sends no body.
+func (b *Body) NewRequest(ctx context.Context, method, url string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		for _, vv := range v {
+			req.Header.Add(k, vv)
+		}
+	}
+	if b == nil {
+		return req, nil
+	}
+	if req.Body, err = b.Open(); err != nil {
+		return nil, err
+	}
+	req.GetBody = b.Open
+	req.ContentLength = b.Length
+	if sized, ok := req.Body.(interface{ Size() int64 }); ok {
+		// the file may have changed since the body was created
+		req.ContentLength = sized.Size()
+	}
+	if req.ContentLength == 0 {
+		req.Body, req.GetBody = http.NoBody, func() (io.ReadCloser, error) { return http.NoBody, nil }
+	}
+	if b.ContentType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", b.ContentType)
+	}
+	return req, nil
+}
+
+// BytesBody returns the body of the data
+func BytesBody(data []byte, contentType string) *Body {
+	return &Body{
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+		Length:      int64(len(data)),
+		ContentType: contentType,
+	}
+}
+
+// FormBody returns the form-urlencoded body of the values
+func FormBody(values url.Values) *Body {
+	return BytesBody([]byte(values.Encode()), MediaTypeForm)
+}
+
+// FileBody returns the body streaming the file, the content type defaults to the type of the file extension
+func FileBody(path, contentType string) (*Body, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("body file %s is a directory", path)
+	}
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(path))
+	}
+	return &Body{
+		Open: func() (io.ReadCloser, error) {
+			f, err := os.Open(path) //nolint:gosec
+			if err != nil {
+				return nil, err
+			}
+			info, err := f.Stat()
+			if err != nil {
+				_ = f.Close()
+				return nil, err
+			}
+			return &sizedReader{ReadCloser: f, size: info.Size()}, nil
+		},
+		Length:      info.Size(),
+		ContentType: contentType,
+	}, nil
+}
+
+// sizedReader is a body reader with the length of the opened body
+type sizedReader struct {
+	io.ReadCloser
+	size int64
+}
+
+// Size returns the length of the body
+func (r *sizedReader) Size() int64 {
+	return r.size
+}
+
+// ReaderBody returns the body of the reader, read once. The data read is kept in a buffer,
+// so that the body is replayed from the start while the reader is still streamed,
+// the readers of the body may be used concurrently.
+func ReaderBody(r io.Reader, contentType string) *Body {
+	length := int64(-1)
+	if lr, ok := r.(interface{ Len() int }); ok {
+		length = int64(lr.Len())
+	}
+	rw := &rewindable{src: r}
+	return &Body{
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(&rewindReader{r: rw}), nil
+		},
+		Length:      length,
+		ContentType: contentType,
+	}
+}
+
+// rewindable buffers the data read from the source for the rewind readers
+type rewindable struct {
+	mu  sync.Mutex
+	src io.Reader
+	buf []byte
+	err error
+}
+
+// rewindReader reads the rewindable from the start
+type rewindReader struct {
+	r   *rewindable
+	off int
+}
+
+func (rr *rewindReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r := rr.r
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rr.off == len(r.buf) {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.buf = slices.Grow(r.buf, len(p))
+		n, err := r.src.Read(r.buf[len(r.buf) : len(r.buf)+len(p)])
+		r.buf = r.buf[:len(r.buf)+n]
+		r.err = err
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf[rr.off:])
+	rr.off += n
+	return n, nil
+}
+
+// MultipartPart is a part of a multipart form, a field with the value or a file
+type MultipartPart struct {
+	Name  string `yaml:"name" json:"name"`
+	Value string `yaml:"value,omitempty" json:"value,omitempty"`
+	// File is the path of the file sent as the part content
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// FileName is the file name of the part, the base name of the file by default
+	FileName string `yaml:"file_name,omitempty" json:"file_name,omitempty"`
+	// ContentType is the content type of the file part, the type of the file extension by default
+	ContentType string `yaml:"content_type,omitempty" json:"content_type,omitempty"`
+}
+
+// MultipartBody returns the multipart form body of the parts, the files are streamed
+func MultipartBody(parts []MultipartPart) (*Body, error) {
+	for _, p := range parts {
+		if p.Name == "" {
+			return nil, fmt.Errorf("multipart part name is required")
+		}
+	}
+	fileSize, err := multipartFileSize(parts)
+	if err != nil {
+		return nil, err
+	}
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+
+	// the length is the length of the form without the file contents, plus the size of the files
+	counter := &countWriter{}
+	if err := writeMultipart(counter, boundary, parts, false); err != nil {
+		return nil, err
+	}
+	return &Body{
+		Open: func() (io.ReadCloser, error) {
+			// the files may have changed since the body was created
+			fileSize, err := multipartFileSize(parts)
+			if err != nil {
+				return nil, err
+			}
+			pr, pw := io.Pipe()
+			go func() {
+				pw.CloseWithError(writeMultipart(pw, boundary, parts, true))
+			}()
+			return &sizedReader{ReadCloser: pr, size: counter.n + fileSize}, nil
+		},
+		Length:      counter.n + fileSize,
+		ContentType: "multipart/form-data; boundary=" + boundary,
+	}, nil
+}
+
+// multipartFileSize returns the total size of the files of the parts
+func multipartFileSize(parts []MultipartPart) (int64, error) {
+	var size int64
+	for _, p := range parts {
+		if p.File == "" {
+			continue
+		}
+		info, err := os.Stat(p.File)
+		if err != nil {
+			return 0, err
+		}
+		if info.IsDir() {
+			return 0, fmt.Errorf("multipart file %s is a directory", p.File)
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
+
+// writeMultipart writes the multipart form, the file contents are skipped without files
+func writeMultipart(w io.Writer, boundary string, parts []MultipartPart, files bool) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		if p.File == "" {
+			if err := mw.WriteField(p.Name, p.Value); err != nil {
+				return err
+			}
+			continue
+		}
+		fileName := p.FileName
+		if fileName == "" {
+			fileName = filepath.Base(p.File)
+		}
+		contentType := p.ContentType
+		if contentType == "" {
+			contentType = mime.TypeByExtension(filepath.Ext(p.File))
+		}
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+			escapeQuotes(p.Name), escapeQuotes(fileName)))
+		h.Set("Content-Type", contentType)
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if files {
+			if err := copyFile(pw, p.File); err != nil {
+				return err
+			}
+		}
+	}
+	return mw.Close()
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path) //nolint:gosec
+	if err != nil {
+		return err
+	}
+	defer f.Close() //nolint:errcheck
+	_, err = io.Copy(w, f)
+	return err
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,190 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readBody(t *testing.T, b *Body) string {
+	t.Helper()
+	r, err := b.Open()
+	require.NoError(t, err)
+	defer r.Close() //nolint:errcheck
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestBodies(t *testing.T) {
+	b := BytesBody([]byte("data"), "text/plain")
+	assert.Equal(t, "data", readBody(t, b))
+	assert.Equal(t, "data", readBody(t, b))
+	assert.Equal(t, int64(4), b.Length)
+
+	b = FormBody(url.Values{"a": {"1"}, "b": {"x y"}})
+	assert.Equal(t, "a=1&b=x+y", readBody(t, b))
+	assert.Equal(t, MediaTypeForm, b.ContentType)
+
+	file := filepath.Join(t.TempDir(), "data.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"a":1}`), 0o600))
+	b, err := FileBody(file, "")
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, readBody(t, b))
+	assert.Equal(t, `{"a":1}`, readBody(t, b))
+	assert.Equal(t, int64(7), b.Length)
+	assert.Equal(t, "application/json", b.ContentType)
+
+	_, err = FileBody(filepath.Join(t.TempDir(), "missing"), "")
+	assert.Error(t, err)
+	_, err = FileBody(t.TempDir(), "")
+	assert.Error(t, err)
+}
+
+func TestReaderBody(t *testing.T) {
+	data := strings.Repeat("0123456789", 10000)
+	b := ReaderBody(io.MultiReader(strings.NewReader(data)), "")
+	assert.Equal(t, int64(-1), b.Length)
+
+	// a partial read is replayed from the start
+	r, err := b.Open()
+	require.NoError(t, err)
+	_, err = io.ReadFull(r, make([]byte, 100))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, data, readBody(t, b))
+		}()
+	}
+	wg.Wait()
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data[100:], string(rest))
+
+	assert.Equal(t, int64(3), ReaderBody(strings.NewReader("abc"), "").Length)
+}
+
+func TestMultipartBody(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "photo.png")
+	require.NoError(t, os.WriteFile(file, []byte("png data"), 0o600))
+
+	b, err := MultipartBody([]MultipartPart{
+		{Name: "title", Value: "holiday"},
+		{Name: "photo", File: file},
+		{Name: "raw", File: file, FileName: "raw.bin", ContentType: "application/x-raw"},
+	})
+	require.NoError(t, err)
+	for range 2 {
+		data := readBody(t, b)
+		assert.Equal(t, int64(len(data)), b.Length)
+
+		mediaType, params, err := mime.ParseMediaType(b.ContentType)
+		require.NoError(t, err)
+		assert.Equal(t, "multipart/form-data", mediaType)
+		form, err := multipart.NewReader(strings.NewReader(data), params["boundary"]).ReadForm(1 << 20)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"holiday"}, form.Value["title"])
+		assert.Equal(t, "photo.png", form.File["photo"][0].Filename)
+		assert.Equal(t, "image/png", form.File["photo"][0].Header.Get("Content-Type"))
+		assert.Equal(t, "raw.bin", form.File["raw"][0].Filename)
+		assert.Equal(t, "application/x-raw", form.File["raw"][0].Header.Get("Content-Type"))
+		f, err := form.File["raw"][0].Open()
+		require.NoError(t, err)
+		content, _ := io.ReadAll(f)
+		assert.Equal(t, "png data", string(content))
+	}
+
+	_, err = MultipartBody([]MultipartPart{{Name: "file", File: filepath.Join(t.TempDir(), "missing")}})
+	assert.Error(t, err)
+	_, err = MultipartBody([]MultipartPart{{Value: "no name"}})
+	assert.Error(t, err)
+}
+
+func TestBodyChangedFile(t *testing.T) {
+	var received []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		received = append(received, string(data))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "data.txt")
+	require.NoError(t, os.WriteFile(file, []byte("short"), 0o600))
+	fileBody, err := FileBody(file, "")
+	require.NoError(t, err)
+	multipartBody, err := MultipartBody([]MultipartPart{{Name: "file", File: file}})
+	require.NoError(t, err)
+
+	// the length is the size of the file when the request is created, not when the body was
+	require.NoError(t, os.WriteFile(file, []byte("a longer content"), 0o600))
+	c := New(WithRetry(0, time.Millisecond, time.Millisecond))
+	for _, b := range []*Body{fileBody, multipartBody} {
+		req, err := b.NewRequest(context.Background(), http.MethodPost, ts.URL, nil)
+		require.NoError(t, err)
+		resp, err := c.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	require.Len(t, received, 2)
+	assert.Equal(t, "a longer content", received[0])
+	assert.Contains(t, received[1], "a longer content")
+}
+
+func TestClientReplaysBody(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+		length []int64
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(data))
+		length = append(length, r.ContentLength)
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "upload.txt")
+	content := strings.Repeat("upload ", 1000)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+	b, err := FileBody(file, "")
+	require.NoError(t, err)
+	req, err := b.NewRequest(context.Background(), http.MethodPut, ts.URL, http.Header{"X-Test": {"1"}})
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", req.Header.Get("Content-Type"))
+
+	resp, err := New(WithRetry(3, time.Millisecond, time.Millisecond)).Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{content, content, content}, bodies)
+	assert.Equal(t, []int64{int64(len(content)), int64(len(content)), int64(len(content))}, length)
+
+	// the content type of the headers is kept
+	req, err = b.NewRequest(context.Background(), http.MethodPut, ts.URL, http.Header{"Content-Type": {"text/csv"}})
+	require.NoError(t, err)
+	assert.Equal(t, "text/csv", req.Header.Get("Content-Type"))
+}
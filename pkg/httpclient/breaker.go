@@ -0,0 +1,318 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerConsecutiveFailures = 5
+	DefaultBreakerFailureRatio        = 0.5
+	DefaultBreakerMinRequests         = 10
+	DefaultBreakerWindow              = 60 * time.Second
+	DefaultBreakerOpenTimeout         = 30 * time.Second
+	DefaultBreakerHalfOpenRequests    = 1
+	// DefaultBreakerIdleTTL is the time a closed breaker of a host is kept after its last request,
+	// the window if it is longer
+	DefaultBreakerIdleTTL = 10 * time.Minute
+)
+
+// ErrCircuitOpen is the error of the requests rejected by an open circuit breaker
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is returned when the circuit breaker of the host is open,
+// it matches ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+	Host string
+	// Until is the time the breaker becomes half-open
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s for %s until %s", ErrCircuitOpen, e.Host, e.Until.Format(time.RFC3339))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// BreakerResult is the result of a request allowed by a circuit breaker
+type BreakerResult int
+
+const (
+	BreakerSuccess BreakerResult = iota
+	BreakerFailure
+	// BreakerCanceled is the result of a canceled request, neither a success nor a failure of the host
+	BreakerCanceled
+)
+
+// BreakerConfig is the config of the circuit breakers, the zero values use the defaults.
+type BreakerConfig struct {
+	// ConsecutiveFailures opens the breaker after the consecutive failures, -1 disables it
+	ConsecutiveFailures int `yaml:"consecutive_failures,omitempty" json:"consecutive_failures,omitempty"`
+	// FailureRatio opens the breaker when the failure ratio of the window is reached, -1 disables it
+	FailureRatio float64 `yaml:"failure_ratio,omitempty" json:"failure_ratio,omitempty"`
+	// MinRequests is the requests of the window required before checking the failure ratio
+	MinRequests int `yaml:"min_requests,omitempty" json:"min_requests,omitempty"`
+	// Window is the interval the counts of a closed breaker are reset
+	Window time.Duration `yaml:"window,omitempty" json:"window,omitempty"`
+	// OpenTimeout is the time an open breaker waits before becoming half-open
+	OpenTimeout time.Duration `yaml:"open_timeout,omitempty" json:"open_timeout,omitempty"`
+	// HalfOpenRequests is the successful probes of a half-open breaker required to close it
+	HalfOpenRequests int `yaml:"half_open_requests,omitempty" json:"half_open_requests,omitempty"`
+}
+
+// IsZero reports whether the breaker config is the default one
+func (c BreakerConfig) IsZero() bool {
+	return c == BreakerConfig{}
+}
+
+func (c BreakerConfig) withDefaults() BreakerConfig {
+	if c.ConsecutiveFailures == 0 {
+		c.ConsecutiveFailures = DefaultBreakerConsecutiveFailures
+	}
+	if c.FailureRatio == 0 {
+		c.FailureRatio = DefaultBreakerFailureRatio
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = DefaultBreakerMinRequests
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultBreakerWindow
+	}
+	if c.OpenTimeout <= 0 {
+		c.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if c.HalfOpenRequests <= 0 {
+		c.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	return c
+}
+
+// CircuitBreakers are the circuit breakers of the hosts.
+// A request fails when it returns an error or a 5xx status code.
+// The closed breakers are evicted after DefaultBreakerIdleTTL without a request.
+type CircuitBreakers struct {
+	cfg       BreakerConfig
+	mu        sync.Mutex
+	circuits  map[string]*circuit
+	lastEvict time.Time
+	now       func() time.Time
+}
+
+// NewCircuitBreakers creates the circuit breakers of the hosts
+func NewCircuitBreakers(cfg BreakerConfig) *CircuitBreakers {
+	return &CircuitBreakers{
+		cfg:      cfg.withDefaults(),
+		circuits: make(map[string]*circuit),
+		now:      time.Now,
+	}
+}
+
+// State returns the state of the breaker of the host
+func (b *CircuitBreakers) State(host string) CircuitState {
+	c := b.circuit(host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.currentState(b.now())
+}
+
+// Allow checks whether a request to the host is allowed, the done function
+// must be called with the result of the allowed request.
+func (b *CircuitBreakers) Allow(host string) (done func(result BreakerResult), err error) {
+	c := b.circuit(host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := b.now()
+	switch c.currentState(now) {
+	case CircuitOpen:
+		return nil, &CircuitOpenError{Host: host, Until: c.openedAt.Add(b.cfg.OpenTimeout)}
+	case CircuitHalfOpen:
+		if c.probes >= b.cfg.HalfOpenRequests {
+			return nil, &CircuitOpenError{Host: host, Until: now}
+		}
+		c.probes++
+	}
+	generation := c.generation
+	return func(result BreakerResult) { b.done(c, generation, result) }, nil
+}
+
+// Middleware returns the middleware rejecting the requests to the hosts with an open breaker
+func (b *CircuitBreakers) Middleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			done, err := b.Allow(req.URL.Host)
+			if err != nil {
+				closeBody(req)
+				return nil, err
+			}
+			resp, err := next.RoundTrip(req)
+			done(breakerResult(resp, err))
+			return resp, err
+		})
+	}
+}
+
+func (b *CircuitBreakers) circuit(host string) *circuit {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.evict(now)
+	c, ok := b.circuits[host]
+	if !ok {
+		c = &circuit{state: CircuitClosed, openTimeout: b.cfg.OpenTimeout, windowStart: now}
+		b.circuits[host] = c
+	}
+	c.usedAt = now
+	return c
+}
+
+// evict removes the closed breakers idle for the TTL, at most once per TTL.
+// The open and half-open ones are kept, they would let the requests through otherwise.
+func (b *CircuitBreakers) evict(now time.Time) {
+	ttl := max(DefaultBreakerIdleTTL, b.cfg.Window)
+	if now.Sub(b.lastEvict) < ttl {
+		return
+	}
+	b.lastEvict = now
+	for host, c := range b.circuits {
+		if now.Sub(c.usedAt) < ttl {
+			continue
+		}
+		c.mu.Lock()
+		closed := c.currentState(now) == CircuitClosed
+		c.mu.Unlock()
+		if closed {
+			delete(b.circuits, host)
+		}
+	}
+}
+
+func (b *CircuitBreakers) done(c *circuit, generation uint64, result BreakerResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := b.now()
+	// the result of a request allowed before the last state change is outdated
+	if generation != c.generation {
+		return
+	}
+	failed := result == BreakerFailure
+	switch c.currentState(now) {
+	case CircuitClosed:
+		if now.Sub(c.windowStart) >= b.cfg.Window {
+			c.requests, c.failures = 0, 0
+			c.windowStart = now
+		}
+		if result == BreakerCanceled {
+			return
+		}
+		c.requests++
+		if !failed {
+			c.consecutive = 0
+			return
+		}
+		c.failures++
+		c.consecutive++
+		if b.cfg.ConsecutiveFailures > 0 && c.consecutive >= b.cfg.ConsecutiveFailures ||
+			b.cfg.FailureRatio > 0 && c.requests >= b.cfg.MinRequests &&
+				float64(c.failures)/float64(c.requests) >= b.cfg.FailureRatio {
+			c.setState(CircuitOpen, now)
+		}
+	case CircuitHalfOpen:
+		// the slot of a canceled probe is freed for the next probe
+		if result == BreakerCanceled {
+			c.probes--
+			return
+		}
+		if failed {
+			c.setState(CircuitOpen, now)
+			return
+		}
+		c.successes++
+		if c.successes >= b.cfg.HalfOpenRequests {
+			c.setState(CircuitClosed, now)
+		}
+	}
+}
+
+// circuit is the circuit breaker of a host
+type circuit struct {
+	mu          sync.Mutex
+	state       CircuitState
+	generation  uint64
+	openedAt    time.Time
+	openTimeout time.Duration
+	// usedAt is the time of the last request, guarded by the mutex of the breakers
+	usedAt time.Time
+
+	// counts of the closed state
+	windowStart time.Time
+	requests    int
+	failures    int
+	consecutive int
+
+	// counts of the half-open state
+	probes    int
+	successes int
+}
+
+// currentState moves an open breaker to half-open once its timeout is expired
+func (c *circuit) currentState(now time.Time) CircuitState {
+	if c.state == CircuitOpen && !now.Before(c.openedAt.Add(c.openTimeout)) {
+		c.setState(CircuitHalfOpen, now)
+	}
+	return c.state
+}
+
+func (c *circuit) setState(state CircuitState, now time.Time) {
+	c.state = state
+	c.generation++
+	c.requests, c.failures, c.consecutive = 0, 0, 0
+	c.probes, c.successes = 0, 0
+	c.windowStart = now
+	if state == CircuitOpen {
+		c.openedAt = now
+	}
+}
+
+// breakerResult returns the result of the request for the breaker of the host,
+// the canceled requests, e.g. the losers of a race, are neither successes nor failures.
+func breakerResult(resp *http.Response, err error) BreakerResult {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return BreakerCanceled
+	case err != nil, resp.StatusCode >= http.StatusInternalServerError:
+		return BreakerFailure
+	default:
+		return BreakerSuccess
+	}
+}
+
+// IsCircuitOpen reports whether the client rejects the requests to the url with an open circuit breaker
+func IsCircuitOpen(c Client, rawURL string) bool {
+	hc, ok := c.(*client)
+	if !ok || hc.breakers == nil {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return hc.breakers.State(u.Host) == CircuitOpen
+}
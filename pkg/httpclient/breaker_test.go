@@ -0,0 +1,160 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakersConsecutiveFailures(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreakers(BreakerConfig{ConsecutiveFailures: 2, FailureRatio: -1, OpenTimeout: time.Minute})
+	b.now = func() time.Time { return now }
+
+	for _, result := range []BreakerResult{BreakerFailure, BreakerSuccess, BreakerFailure, BreakerFailure} {
+		done, err := b.Allow("a")
+		require.NoError(t, err)
+		done(result)
+	}
+	assert.Equal(t, CircuitOpen, b.State("a"))
+	assert.Equal(t, CircuitClosed, b.State("b"))
+
+	_, err := b.Allow("a")
+	var openErr *CircuitOpenError
+	require.ErrorAs(t, err, &openErr)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, "a", openErr.Host)
+	assert.Equal(t, now.Add(time.Minute), openErr.Until)
+
+	// a single probe is allowed once the breaker is half-open
+	now = now.Add(time.Minute)
+	assert.Equal(t, CircuitHalfOpen, b.State("a"))
+	probe, err := b.Allow("a")
+	require.NoError(t, err)
+	_, err = b.Allow("a")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// a failed probe opens the breaker again
+	probe(BreakerFailure)
+	assert.Equal(t, CircuitOpen, b.State("a"))
+
+	now = now.Add(time.Minute)
+	probe, err = b.Allow("a")
+	require.NoError(t, err)
+	probe(BreakerSuccess)
+	assert.Equal(t, CircuitClosed, b.State("a"))
+}
+
+func TestCircuitBreakersEvict(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreakers(BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	b.now = func() time.Time { return now }
+
+	for _, host := range []string{"closed", "open"} {
+		done, err := b.Allow(host)
+		require.NoError(t, err)
+		if host == "open" {
+			done(BreakerFailure)
+		} else {
+			done(BreakerSuccess)
+		}
+	}
+	assert.Len(t, b.circuits, 2)
+
+	// the idle closed breakers are evicted, the open ones are kept
+	now = now.Add(DefaultBreakerIdleTTL)
+	assert.Equal(t, CircuitClosed, b.State("other"))
+	assert.Len(t, b.circuits, 2)
+	assert.NotContains(t, b.circuits, "closed")
+	assert.Equal(t, CircuitOpen, b.State("open"))
+}
+
+func TestCircuitBreakersCanceled(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreakers(BreakerConfig{ConsecutiveFailures: 2, FailureRatio: -1, OpenTimeout: time.Minute})
+	b.now = func() time.Time { return now }
+
+	// a canceled request does not reset the consecutive failures
+	for _, result := range []BreakerResult{BreakerFailure, BreakerCanceled, BreakerFailure} {
+		done, err := b.Allow("a")
+		require.NoError(t, err)
+		done(result)
+	}
+	assert.Equal(t, CircuitOpen, b.State("a"))
+
+	// a canceled probe keeps the breaker half-open and frees the probe slot
+	now = now.Add(time.Minute)
+	probe, err := b.Allow("a")
+	require.NoError(t, err)
+	probe(BreakerCanceled)
+	assert.Equal(t, CircuitHalfOpen, b.State("a"))
+	probe, err = b.Allow("a")
+	require.NoError(t, err)
+	_, err = b.Allow("a")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	probe(BreakerFailure)
+	assert.Equal(t, CircuitOpen, b.State("a"))
+
+	assert.Equal(t, BreakerCanceled, breakerResult(nil, fmt.Errorf("get: %w", context.Canceled)))
+	assert.Equal(t, BreakerFailure, breakerResult(nil, context.DeadlineExceeded))
+	assert.Equal(t, BreakerFailure, breakerResult(&http.Response{StatusCode: http.StatusBadGateway}, nil))
+	assert.Equal(t, BreakerSuccess, breakerResult(&http.Response{StatusCode: http.StatusNotFound}, nil))
+}
+
+func TestCircuitBreakersFailureRatio(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreakers(BreakerConfig{ConsecutiveFailures: -1, FailureRatio: 0.5, MinRequests: 4, Window: time.Minute})
+	b.now = func() time.Time { return now }
+
+	record := func(results ...BreakerResult) {
+		for _, result := range results {
+			done, err := b.Allow("a")
+			require.NoError(t, err)
+			done(result)
+		}
+	}
+	record(BreakerFailure, BreakerSuccess, BreakerFailure)
+	assert.Equal(t, CircuitClosed, b.State("a"))
+
+	// the counts are reset with the window
+	now = now.Add(time.Minute)
+	record(BreakerSuccess, BreakerSuccess, BreakerFailure)
+	assert.Equal(t, CircuitClosed, b.State("a"))
+	record(BreakerFailure)
+	assert.Equal(t, CircuitOpen, b.State("a"))
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	c := New(WithRetry(5, time.Millisecond, time.Millisecond), WithCircuitBreaker(BreakerConfig{ConsecutiveFailures: 2}))
+
+	// the retries stop once the breaker is open
+	_, err := c.Get(ts.URL)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+	assert.True(t, IsCircuitOpen(c, ts.URL+"/path"))
+
+	// the requests fail fast while the breaker is open
+	_, err = c.Get(ts.URL)
+	var openErr *CircuitOpenError
+	require.True(t, errors.As(err, &openErr))
+	u, _ := url.Parse(ts.URL)
+	assert.Equal(t, u.Host, openErr.Host)
+	assert.Equal(t, 2, calls)
+
+	assert.False(t, IsCircuitOpen(New(), ts.URL))
+}
@@ -2,13 +2,15 @@ package httpclient
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"mime"
 	"net/http"
-	"net/http/httputil"
+	"net/url"
+	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 )
@@ -28,8 +30,8 @@ type Client interface {
 type client struct {
 	cfg      *clientConfig
 	c        *retryablehttp.Client
+	breakers *CircuitBreakers
 	logger   *slog.Logger
-	dumpChan chan []byte
 }
 
 // New creates a new http client
@@ -46,7 +48,7 @@ func New(opts ...Option) Client {
 		logger: cfg.logger,
 	}
 	hc.setupClient()
-	hc.setupDump()
+	hc.setupLogHooks()
 
 	return hc
 }
@@ -56,75 +58,115 @@ func (c *client) setupClient() {
 		c.c = retryablehttp.NewClient()
 	}
 
-	if c.cfg.retryWaitMin > 0 {
-		c.c.RetryWaitMin = c.cfg.retryWaitMin
+	c.c.RetryMax = c.cfg.retry.Max
+	c.c.RetryWaitMin = c.cfg.retry.WaitMin
+	c.c.RetryWaitMax = c.cfg.retry.WaitMax
+	c.c.CheckRetry = c.checkRetry
+	c.c.Backoff = c.backoff
+	if c.cfg.transport != nil {
+		c.c.HTTPClient.Transport = c.cfg.transport
 	}
-	if c.cfg.retryWaitMax > 0 {
-		c.c.RetryWaitMax = c.cfg.retryWaitMax
+	if tlsConfig, proxy := c.cfg.tlsConfig(), c.cfg.proxy; tlsConfig != nil || proxy != nil {
+		if t, ok := c.c.HTTPClient.Transport.(*http.Transport); ok {
+			t = t.Clone()
+			if tlsConfig != nil {
+				t.TLSClientConfig = tlsConfig
+			}
+			if proxy != nil {
+				t.Proxy = proxy
+			}
+			c.c.HTTPClient.Transport = t
+		} else {
+			c.logger.Warn("the TLS and proxy options are ignored with a custom transport")
+		}
+	}
+	// the recorder wraps the transport, so that every retry attempt is recorded
+	if c.cfg.recorder != nil {
+		c.c.HTTPClient.Transport = &recordTransport{
+			next:     c.c.HTTPClient.Transport,
+			recorder: c.cfg.recorder,
+			logger:   c.logger,
+		}
 	}
-	if c.cfg.retryMax >= 0 {
-		c.c.RetryMax = c.cfg.retryMax
+	// the requests are authorized on every attempt, the recorder records them as signed
+	c.c.HTTPClient.Transport = contextAuthMiddleware(c.c.HTTPClient.Transport)
+	// the breaker wraps the recorder, so that the rejected requests are not recorded
+	if c.cfg.breaker != nil {
+		c.breakers = NewCircuitBreakers(*c.cfg.breaker)
+		c.c.HTTPClient.Transport = c.breakers.Middleware()(c.c.HTTPClient.Transport)
 	}
-	if c.cfg.skipTLSVerify {
-		c.c.HTTPClient.Transport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+	// the rate limiter wraps the breaker, so that its rejections are not failures of the host
+	if c.cfg.rateLimit != nil {
+		c.c.HTTPClient.Transport = NewRateLimiters(*c.cfg.rateLimit).Middleware()(c.c.HTTPClient.Transport)
 	}
-	if c.logger != nil {
-		c.c.Logger = c.logger
+	if len(c.cfg.middlewares) > 0 {
+		c.c.HTTPClient.Transport = Chain(c.c.HTTPClient.Transport, c.cfg.middlewares...)
 	}
+	c.c.Logger = leveledLogger{c.logger}
 }
 
-func (c *client) setupDump() {
-	if c.cfg.dumpRequest && c.dumpChan != nil {
-		c.c.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, retryNumber int) {
-			rid := GenRequestID(req, c.cfg.requestIDKey)
-			url := req.URL.String()
-			method := req.Method
-			c.logger.Debug("request", "retry", retryNumber, "request_id", rid, "url", url, "method", method)
+// leveledLogger logs the retries of retryablehttp with the client logger.
+// It has no request context to mask the secret values, so only the host of the
+// URLs is logged, the masked requests and responses are logged by the hooks.
+type leveledLogger struct {
+	logger *slog.Logger
+}
+
+func (l leveledLogger) Error(msg string, keysAndValues ...any) {
+	l.logger.Error(msg, redactLogValues(keysAndValues)...)
+}
+
+func (l leveledLogger) Warn(msg string, keysAndValues ...any) {
+	l.logger.Warn(msg, redactLogValues(keysAndValues)...)
+}
 
-			data, err := httputil.DumpRequest(req, c.cfg.dumpRequestBody)
+func (l leveledLogger) Info(msg string, keysAndValues ...any) {
+	l.logger.Info(msg, redactLogValues(keysAndValues)...)
+}
+
+func (l leveledLogger) Debug(msg string, keysAndValues ...any) {
+	l.logger.Debug(msg, redactLogValues(keysAndValues)...)
+}
+
+// redactLogValues replaces the URLs with their host and drops the request descriptions
+func redactLogValues(keysAndValues []any) []any {
+	values := make([]any, 0, len(keysAndValues))
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key, val := keysAndValues[i], keysAndValues[i+1]
+		switch key {
+		case "url":
+			u, err := url.Parse(fmt.Sprint(val))
 			if err != nil {
-				slog.Warn("dump request", "error", err)
-			} else {
-				c.dumpChan <- data
+				continue
 			}
+			key, val = "host", u.Host
+		case "request":
+			continue
 		}
-	} else {
-		c.c.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, retryNumber int) {
-			rid := GenRequestID(req, c.cfg.requestIDKey)
-			url := req.URL.String()
-			method := req.Method
-			c.logger.Debug("request", "retry", retryNumber, "request_id", rid, "url", url, "method", method)
+		if err, ok := val.(*url.Error); ok {
+			val = err.Err
 		}
+		values = append(values, key, val)
 	}
+	return values
+}
 
-	if c.cfg.dumpResponse && c.dumpChan != nil {
-		c.c.ResponseLogHook = func(_ retryablehttp.Logger, resp *http.Response) {
-			rid := GenRequestID(resp.Request, c.cfg.requestIDKey)
-			url := resp.Request.URL.String()
-			method := resp.Request.Method
-			if resp.StatusCode >= http.StatusBadRequest {
-				c.logger.Warn("response", "request_id", rid, "url", url, "method", method, "status", resp.Status)
-			} else {
-				c.logger.Debug("response", "request_id", rid, "url", url, "method", method, "status", resp.Status)
-			}
+func (c *client) setupLogHooks() {
+	c.c.RequestLogHook = func(_ retryablehttp.Logger, req *http.Request, retryNumber int) {
+		rid := GenRequestID(req, c.cfg.requestIDKey)
+		url := MaskSecrets(req.Context(), req.URL.String())
+		method := req.Method
+		c.logger.Debug("request", "retry", retryNumber, "request_id", rid, "url", url, "method", method)
+	}
 
-			data, err := httputil.DumpResponse(resp, c.cfg.dumpResponseBody)
-			if err != nil {
-				c.logger.Warn("dump response", "request_id", rid, "url", url, "method", method, "error", err)
-			} else {
-				c.dumpChan <- data
-			}
-		}
-	} else {
-		c.c.ResponseLogHook = func(_ retryablehttp.Logger, resp *http.Response) {
-			rid := GenRequestID(resp.Request, c.cfg.requestIDKey)
-			url := resp.Request.URL.String()
-			method := resp.Request.Method
-			if resp.StatusCode >= http.StatusBadRequest {
-				c.logger.Warn("response", "request_id", rid, "url", url, "method", method, "status", resp.Status)
-			} else {
-				c.logger.Debug("response", "request_id", rid, "url", url, "method", method, "status", resp.Status)
-			}
+	c.c.ResponseLogHook = func(_ retryablehttp.Logger, resp *http.Response) {
+		rid := GenRequestID(resp.Request, c.cfg.requestIDKey)
+		url := MaskSecrets(resp.Request.Context(), resp.Request.URL.String())
+		method := resp.Request.Method
+		if resp.StatusCode >= http.StatusBadRequest {
+			c.logger.Warn("response", "request_id", rid, "url", url, "method", method, "status", resp.Status)
+		} else {
+			c.logger.Debug("response", "request_id", rid, "url", url, "method", method, "status", resp.Status)
 		}
 	}
 }
@@ -199,9 +241,19 @@ func (c *client) Do(req *http.Request, opts ...RequestOption) (*http.Response, e
 		opt(r)
 	}
 
+	ctx := req.Context()
 	if r.ctx != nil {
-		req = req.WithContext(r.ctx)
+		ctx = r.ctx
+	}
+	state := &retryState{
+		started:   time.Now(),
+		budget:    c.cfg.retry.Budget,
+		retryable: c.cfg.retry.RetryNonIdempotent,
 	}
+	if r.retryBudget > 0 {
+		state.budget = r.retryBudget
+	}
+	req = req.WithContext(context.WithValue(ctx, retryStateKey{}, state))
 	for k, v := range c.cfg.defaultHeader {
 		for _, vv := range v {
 			req.Header.Add(k, vv)
@@ -213,11 +265,43 @@ func (c *client) Do(req *http.Request, opts ...RequestOption) (*http.Response, e
 		}
 	}
 
+	state.retryable = state.retryable || IsIdempotent(req)
+
+	if c.breakers != nil && c.breakers.State(req.URL.Host) == CircuitOpen {
+		_, err := c.breakers.Allow(req.URL.Host)
+		closeBody(req)
+		return nil, err
+	}
+
+	// a body which can be opened again is streamed on each attempt instead of being buffered
+	getBody, length := req.GetBody, req.ContentLength
+	if getBody != nil && req.Body != nil && req.Body != http.NoBody {
+		_ = req.Body.Close()
+		req.Body = nil
+	} else {
+		getBody = nil
+	}
 	rreq, err := retryablehttp.FromRequest(req)
 	if err != nil {
 		return nil, err
 	}
-	return c.c.Do(rreq)
+	if getBody != nil {
+		err = rreq.SetBody(retryablehttp.ReaderFunc(func() (io.Reader, error) { return getBody() }))
+		if err != nil {
+			return nil, err
+		}
+		rreq.ContentLength = length
+	}
+	resp, err := c.c.Do(rreq)
+	if err != nil {
+		if state.status != 0 {
+			err = &StatusError{StatusCode: state.status, Err: err}
+		}
+		err = MaskError(req.Context(), err)
+		c.logger.Warn("request failed", "url", MaskSecrets(req.Context(), req.URL.String()), "method", req.Method, "error", err)
+		return nil, err
+	}
+	return resp, nil
 }
 
 // ParseResponse is a helper function that reads the response body and returns the bytes
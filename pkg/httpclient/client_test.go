@@ -5,8 +5,10 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -181,6 +183,35 @@ func TestClientWithOptions(t *testing.T) {
 	assert.NotNil(t, client)
 }
 
+func TestClientRetryLogs(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := New(WithLogger(logger), WithRetry(1, time.Millisecond, time.Millisecond))
+
+	req, err := http.NewRequestWithContext(ContextWithSecrets(context.Background(), "secret-token"),
+		http.MethodGet, server.URL+"/users?token=secret-token", nil)
+	assert.NoError(t, err)
+	resp, err := client.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	logs := buf.String()
+	assert.Contains(t, logs, `msg="retrying request"`)
+	assert.Contains(t, logs, "host="+strings.TrimPrefix(server.URL, "http://"))
+	assert.NotContains(t, logs, "secret-token")
+}
+
 func TestInvalidRequest(t *testing.T) {
 	client := New()
 
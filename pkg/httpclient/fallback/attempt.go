@@ -0,0 +1,67 @@
+package fallback
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrAllFailed is wrapped by the AttemptError returned when no url responds acceptably
+var ErrAllFailed = errors.New("all urls failed")
+
+// Attempt is the outcome of the request to a url, the secrets of the url and error are masked
+type Attempt struct {
+	URL string `json:"url"`
+	// StatusCode is the status of the last response, 0 if the request failed without a response
+	StatusCode int           `json:"status_code,omitempty"`
+	Err        error         `json:"-"`
+	Latency    time.Duration `json:"latency"`
+	// Accepted is true for the response returned by the fallback
+	Accepted bool `json:"accepted"`
+}
+
+// AttemptObserver is called after each attempt
+type AttemptObserver func(attempt Attempt)
+
+func (a Attempt) String() string {
+	var outcome string
+	switch {
+	case a.Err != nil && a.StatusCode != 0:
+		outcome = fmt.Sprintf("status %d, %s", a.StatusCode, a.Err)
+	case a.Err != nil:
+		outcome = a.Err.Error()
+	case a.Accepted:
+		outcome = fmt.Sprintf("status %d, accepted", a.StatusCode)
+	default:
+		outcome = fmt.Sprintf("status %d", a.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s (%s)", a.URL, outcome, a.Latency.Round(time.Millisecond))
+}
+
+// AttemptError is returned when all the urls failed, it lists the attempts in order
+type AttemptError struct {
+	Attempts []Attempt
+}
+
+func (e *AttemptError) Error() string {
+	if len(e.Attempts) == 0 {
+		return ErrAllFailed.Error()
+	}
+	trail := make([]string, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		trail = append(trail, a.String())
+	}
+	return ErrAllFailed.Error() + ": " + strings.Join(trail, "; ")
+}
+
+// Unwrap returns ErrAllFailed and the errors of the attempts
+func (e *AttemptError) Unwrap() []error {
+	errs := []error{ErrAllFailed}
+	for _, a := range e.Attempts {
+		if a.Err != nil {
+			errs = append(errs, a.Err)
+		}
+	}
+	return errs
+}
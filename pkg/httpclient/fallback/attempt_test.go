@@ -0,0 +1,74 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepair/telepair/pkg/httpclient"
+)
+
+func TestDo_Attempts(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+
+	var observed []Attempt
+	observer := WithAttemptObserver(func(a Attempt) { observed = append(observed, a) })
+	resp, err := Get([]string{down.URL, up.URL}, observer, WithTracker(NewTracker()))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Len(t, observed, 2)
+	assert.Equal(t, down.URL, observed[0].URL)
+	assert.Equal(t, http.StatusBadGateway, observed[0].StatusCode)
+	assert.Error(t, observed[0].Err)
+	assert.False(t, observed[0].Accepted)
+	assert.Equal(t, Attempt{URL: up.URL, StatusCode: http.StatusOK, Latency: observed[1].Latency, Accepted: true}, observed[1])
+
+	observed = nil
+	_, err = Get([]string{down.URL, "http://127.0.0.1:1"}, observer, WithTracker(NewTracker()))
+	var attemptErr *AttemptError
+	require.ErrorAs(t, err, &attemptErr)
+	assert.ErrorIs(t, err, ErrAllFailed)
+	assert.Equal(t, observed, attemptErr.Attempts)
+	require.Len(t, attemptErr.Attempts, 2)
+	assert.Equal(t, http.StatusBadGateway, attemptErr.Attempts[0].StatusCode)
+	assert.Error(t, attemptErr.Attempts[1].Err)
+	assert.Contains(t, err.Error(), down.URL+": status 502, ")
+	assert.Contains(t, err.Error(), "http://127.0.0.1:1: ")
+}
+
+func TestDo_AttemptsMaskSecrets(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	ctx := httpclient.ContextWithSecrets(context.Background(), "s3cr3t")
+	_, err := Get([]string{ts.URL + "/?key=s3cr3t"}, WithContext(ctx), WithTracker(NewTracker()))
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "s3cr3t")
+}
+
+func TestAttemptError(t *testing.T) {
+	err := &AttemptError{}
+	assert.Equal(t, "all urls failed", err.Error())
+
+	err = &AttemptError{Attempts: []Attempt{
+		{URL: "http://a", Err: httpclient.ErrCircuitOpen},
+		{URL: "http://b", StatusCode: http.StatusServiceUnavailable, Latency: 1500 * time.Microsecond},
+	}}
+	assert.True(t, errors.Is(err, httpclient.ErrCircuitOpen))
+	assert.Equal(t, "all urls failed: http://a: "+httpclient.ErrCircuitOpen.Error()+" (0s); http://b: status 503 (2ms)", err.Error())
+}
@@ -1,8 +1,12 @@
 package fallback
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/telepair/telepair/pkg/httpclient"
 )
@@ -43,53 +47,224 @@ func Delete(urls []string, opts ...Option) (*http.Response, error) {
 }
 
 // Do is the fallback do method
-func Do(method string, urls []string, body []byte, opts ...Option) (resp *http.Response, err error) {
+func Do(method string, urls []string, body []byte, opts ...Option) (*http.Response, error) {
+	var b *httpclient.Body
+	if body != nil {
+		b = httpclient.BytesBody(body, "")
+	}
+	return DoBody(method, urls, b, opts...)
+}
+
+// DoBody is the fallback do method with a body replayed on each url
+func DoBody(method string, urls []string, body *httpclient.Body, opts ...Option) (resp *http.Response, err error) {
 	f := defaultFallback()
 	for _, opt := range opts {
 		opt(f)
 	}
 
-	copts := make([]httpclient.RequestOption, 0, 2)
-	if f.ctx != nil {
-		copts = append(copts, httpclient.WithContext(f.ctx))
-	}
-	if f.header != nil {
-		copts = append(copts, httpclient.WithHeader(f.header))
-	}
-
-	for _, url := range handleURLs(urls, f.selector) {
-		switch method {
-		case http.MethodGet:
-			resp, err = f.client.Get(url, copts...)
-		case http.MethodHead:
-			resp, err = f.client.Head(url, copts...)
-		case http.MethodOptions:
-			resp, err = f.client.Options(url, copts...)
-		case http.MethodPost:
-			resp, err = f.client.Post(url, body, copts...)
-		case http.MethodPut:
-			resp, err = f.client.Put(url, body, copts...)
-		case http.MethodPatch:
-			resp, err = f.client.Patch(url, body, copts...)
-		case http.MethodDelete:
-			resp, err = f.client.Delete(url, copts...)
-		default:
-			f.logger.Error("unsupported method", "method", method)
-			return nil, errors.New("unsupported method: " + method)
-		}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+		body = nil
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		f.logger.Error("unsupported method", "method", method)
+		return nil, errors.New("unsupported method: " + method)
+	}
+
+	if (f.selector == SelectStrategyRace || f.selector == SelectStrategyHedged) && !IsParallelSafe(method, f.header) {
+		return nil, fmt.Errorf("%s strategy duplicates the %s requests without the %s header", f.selector, method, httpclient.IdempotencyKeyHeader)
+	}
 
-		if err != nil {
-			f.logger.Error("request url failed", "url", url, "error", err)
+	targets := f.tracker.order(handleURLs(urls, f.selector), f.selector, f.weights)
+	available := make([]string, 0, len(targets))
+	for _, url := range targets {
+		if httpclient.IsCircuitOpen(f.client, url) {
+			f.logger.Warn("skip url with open circuit breaker", "url", httpclient.MaskSecrets(f.ctx, url))
+			f.observe(url, nil, httpclient.ErrCircuitOpen, 0, false)
 			continue
 		}
+		available = append(available, url)
+	}
 
-		if f.retry(resp) {
-			f.logger.Error("get url failed", "url", url, "status", resp.StatusCode)
-			continue
+	switch f.selector {
+	case SelectStrategyRace:
+		return f.doParallel(method, available, body, 0)
+	case SelectStrategyHedged:
+		return f.doParallel(method, available, body, f.hedgeDelay)
+	}
+
+	for _, url := range available {
+		start := time.Now()
+		resp, err = f.do(f.context(), method, url, body)
+		if f.accept(url, resp, err, time.Since(start)) {
+			return resp, nil
 		}
+	}
+	return nil, &AttemptError{Attempts: f.attempts}
+}
 
-		return resp, nil
+// IsParallelSafe reports whether the request can be sent to the urls at once by the race and
+// hedged strategies, the other methods are duplicated unless the request has an idempotency key.
+func IsParallelSafe(method string, header http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut:
+		return true
 	}
+	return header.Get(httpclient.IdempotencyKeyHeader) != ""
+}
+
+// accept checks the response of the url and records its outcome in the tracker,
+// the body of a rejected response is closed
+func (f *fallback) accept(url string, resp *http.Response, err error, latency time.Duration) bool {
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			f.tracker.Observe(url, latency, true)
+		}
+		f.logger.Error("request url failed", "url", httpclient.MaskSecrets(f.ctx, url), "error", err)
+		f.observe(url, nil, err, latency, false)
+		return false
+	}
+	if f.retry(resp) {
+		f.logger.Error("get url failed", "url", httpclient.MaskSecrets(f.ctx, url), "status", resp.StatusCode)
+		_ = resp.Body.Close()
+		f.tracker.Observe(url, latency, true)
+		f.observe(url, resp, nil, latency, false)
+		return false
+	}
+	f.tracker.Observe(url, latency, false)
+	f.observe(url, resp, nil, latency, true)
+	return true
+}
 
-	return nil, errors.New("all urls failed")
+// observe records the attempt of the url and calls the attempt observer
+func (f *fallback) observe(url string, resp *http.Response, err error, latency time.Duration, accepted bool) {
+	a := Attempt{
+		URL:      httpclient.MaskSecrets(f.ctx, url),
+		Err:      httpclient.MaskError(f.ctx, err),
+		Latency:  latency,
+		Accepted: accepted,
+	}
+	var statusErr *httpclient.StatusError
+	if resp != nil {
+		a.StatusCode = resp.StatusCode
+	} else if errors.As(err, &statusErr) {
+		a.StatusCode = statusErr.StatusCode
+	}
+	f.attempts = append(f.attempts, a)
+	if f.observer != nil {
+		f.observer(a)
+	}
+}
+
+// result is the response of a url sent in parallel
+type result struct {
+	index   int
+	url     string
+	resp    *http.Response
+	err     error
+	latency time.Duration
+}
+
+// doParallel starts the urls one after another every delay, or all at once if the delay is 0,
+// the next url is started at once when a request fails. The first acceptable response is
+// returned, the other requests are cancelled and their bodies closed.
+func (f *fallback) doParallel(method string, urls []string, body *httpclient.Body, delay time.Duration) (*http.Response, error) {
+	if len(urls) == 0 {
+		return nil, &AttemptError{Attempts: f.attempts}
+	}
+	parent := f.context()
+	results := make(chan result, len(urls))
+	cancels := make([]context.CancelFunc, 0, len(urls))
+	start := func() {
+		i, url := len(cancels), urls[len(cancels)]
+		ctx, cancel := context.WithCancel(parent)
+		cancels = append(cancels, cancel)
+		go func() {
+			begin := time.Now()
+			resp, err := f.do(ctx, method, url, body)
+			results <- result{index: i, url: url, resp: resp, err: err, latency: time.Since(begin)}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	if delay <= 0 {
+		for len(cancels) < len(urls) {
+			start()
+		}
+	}
+	pending := len(cancels)
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(cancels) < len(urls) {
+				start()
+				pending++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if !f.accept(r.url, r.resp, r.err, r.latency) {
+				cancels[r.index]()
+				if len(cancels) < len(urls) {
+					start()
+					pending++
+					timer.Reset(delay)
+				}
+				continue
+			}
+			for i, cancel := range cancels {
+				if i != r.index {
+					cancel()
+				}
+			}
+			go drainResults(results, pending)
+			r.resp.Body = &cancelBody{ReadCloser: r.resp.Body, cancel: cancels[r.index]}
+			return r.resp, nil
+		}
+	}
+	return nil, &AttemptError{Attempts: f.attempts}
+}
+
+// drainResults closes the bodies of the cancelled requests
+func drainResults(results <-chan result, pending int) {
+	for range pending {
+		if r := <-results; r.resp != nil {
+			_ = r.resp.Body.Close()
+		}
+	}
+}
+
+// cancelBody cancels the request context when the response body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (f *fallback) context() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+// do sends the request to the url, the request hook runs after the headers are set
+func (f *fallback) do(ctx context.Context, method, url string, body *httpclient.Body) (*http.Response, error) {
+	req, err := body.NewRequest(ctx, method, url, f.header)
+	if err != nil {
+		return nil, err
+	}
+	if f.hook != nil {
+		if err := f.hook(req); err != nil {
+			return nil, err
+		}
+	}
+	return f.client.Do(req)
 }
@@ -1,11 +1,17 @@
 package fallback
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/telepair/telepair/pkg/httpclient"
 )
 
 func Example_fallback() {
@@ -183,3 +189,233 @@ func TestHelperMethods(t *testing.T) {
 		})
 	}
 }
+
+func TestDo_RequestHook(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Signature") != r.Header.Get("X-Test")+"-signed" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	hook := func(req *http.Request) error {
+		req.Header.Set("X-Signature", req.Header.Get("X-Test")+"-signed")
+		return nil
+	}
+	resp, err := Get([]string{server.URL}, WithHeader(http.Header{"X-Test": []string{"test"}}), WithRequestHook(hook))
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Get() status = %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	failed := func(*http.Request) error { return fmt.Errorf("sign failed") }
+	if _, err := Get([]string{server.URL}, WithRequestHook(failed)); err == nil {
+		t.Error("Get() expected error when the request hook fails")
+	}
+}
+
+func TestDo_CircuitBreaker(t *testing.T) {
+	downCalls := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		downCalls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+
+	client := httpclient.New(httpclient.WithRetry(0, time.Millisecond, time.Millisecond),
+		httpclient.WithCircuitBreaker(httpclient.BreakerConfig{ConsecutiveFailures: 1}))
+	for range 3 {
+		resp, err := Get([]string{down.URL, up.URL}, WithRetryClient(client))
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Get() status = %v, want %v", resp.StatusCode, http.StatusOK)
+		}
+	}
+	if downCalls != 1 {
+		t.Errorf("down server calls = %d, want 1", downCalls)
+	}
+
+	if _, err := Get([]string{down.URL}, WithRetryClient(client)); !errors.Is(err, httpclient.ErrCircuitOpen) {
+		t.Errorf("Get() error = %v, want %v", err, httpclient.ErrCircuitOpen)
+	}
+}
+
+func TestDo_ParallelStrategies(t *testing.T) {
+	newServer := func(name string, delay time.Duration, status int, calls, cancelled *atomic.Int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				cancelled.Add(1)
+				return
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(name))
+		}))
+	}
+
+	tests := []struct {
+		name          string
+		strategy      SelectStrategy
+		delays        []time.Duration
+		statuses      []int
+		want          string
+		wantCalls     int32
+		wantCancelled int32
+	}{
+		{
+			name:          "race returns the fastest",
+			strategy:      SelectStrategyRace,
+			delays:        []time.Duration{time.Second, 10 * time.Millisecond, time.Second},
+			statuses:      []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			want:          "1",
+			wantCalls:     3,
+			wantCancelled: 2,
+		},
+		{
+			name:          "race skips the failed responses",
+			strategy:      SelectStrategyRace,
+			delays:        []time.Duration{0, 50 * time.Millisecond},
+			statuses:      []int{http.StatusBadGateway, http.StatusOK},
+			want:          "1",
+			wantCalls:     2,
+			wantCancelled: 0,
+		},
+		{
+			name:          "hedged starts the next url after the delay",
+			strategy:      SelectStrategyHedged,
+			delays:        []time.Duration{time.Second, 10 * time.Millisecond, 10 * time.Millisecond},
+			statuses:      []int{http.StatusOK, http.StatusOK, http.StatusOK},
+			want:          "1",
+			wantCalls:     2,
+			wantCancelled: 1,
+		},
+		{
+			name:          "hedged does not start the next url if the first responds",
+			strategy:      SelectStrategyHedged,
+			delays:        []time.Duration{0, 0},
+			statuses:      []int{http.StatusOK, http.StatusOK},
+			want:          "0",
+			wantCalls:     1,
+			wantCancelled: 0,
+		},
+		{
+			name:          "hedged starts the next url at once on failure",
+			strategy:      SelectStrategyHedged,
+			delays:        []time.Duration{0, 0},
+			statuses:      []int{http.StatusServiceUnavailable, http.StatusOK},
+			want:          "1",
+			wantCalls:     2,
+			wantCancelled: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls, cancelled atomic.Int32
+			urls := make([]string, len(tt.delays))
+			for i := range tt.delays {
+				server := newServer(fmt.Sprint(i), tt.delays[i], tt.statuses[i], &calls, &cancelled)
+				defer server.Close()
+				urls[i] = server.URL
+			}
+
+			started := time.Now()
+			resp, err := Get(urls, WithSelectStrategy(tt.strategy), WithHedgeDelay(100*time.Millisecond))
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+			if string(body) != tt.want {
+				t.Errorf("Get() body = %s, want %s", body, tt.want)
+			}
+			if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+				t.Errorf("Get() took %v, the slow requests are not cancelled", elapsed)
+			}
+
+			// the cancelled requests are seen by the servers asynchronously
+			deadline := time.Now().Add(time.Second)
+			for cancelled.Load() < tt.wantCancelled && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if got := calls.Load(); got != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", got, tt.wantCalls)
+			}
+			if got := cancelled.Load(); got != tt.wantCancelled {
+				t.Errorf("cancelled = %d, want %d", got, tt.wantCancelled)
+			}
+		})
+	}
+
+	if _, err := Get([]string{"http://127.0.0.1:1"}, WithSelectStrategy(SelectStrategyRace)); err == nil {
+		t.Error("Get() expected error when all urls fail")
+	}
+}
+
+func TestDo_ParallelStrategiesNonIdempotent(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	urls := []string{server.URL + "/a", server.URL + "/b"}
+
+	for _, strategy := range []SelectStrategy{SelectStrategyRace, SelectStrategyHedged} {
+		if _, err := Post(urls, []byte("{}"), WithSelectStrategy(strategy)); err == nil {
+			t.Errorf("Post() with %s expected error without an idempotency key", strategy)
+		}
+	}
+	if got := calls.Load(); got != 0 {
+		t.Errorf("calls = %d, want 0", got)
+	}
+
+	header := http.Header{httpclient.IdempotencyKeyHeader: {"key"}}
+	resp, err := Post(urls, []byte("{}"), WithSelectStrategy(SelectStrategyRace), WithHeader(header))
+	if err != nil {
+		t.Fatalf("Post() with an idempotency key error = %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if !IsParallelSafe(http.MethodPut, nil) || IsParallelSafe(http.MethodDelete, nil) || IsParallelSafe(http.MethodPatch, nil) {
+		t.Error("IsParallelSafe() is wrong")
+	}
+}
+
+func TestDoBody_ReplaysBody(t *testing.T) {
+	var bodies []string
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(data)+" "+r.Header.Get("Content-Type"))
+			w.WriteHeader(status)
+		}
+	}
+	down := httptest.NewServer(handler(http.StatusBadGateway))
+	defer down.Close()
+	up := httptest.NewServer(handler(http.StatusOK))
+	defer up.Close()
+
+	body := httpclient.ReaderBody(io.MultiReader(strings.NewReader("streamed body")), "text/plain")
+	resp, err := DoBody(http.MethodPost, []string{down.URL, up.URL}, body, WithTracker(NewTracker()))
+	if err != nil {
+		t.Fatalf("DoBody() error = %v", err)
+	}
+	_ = resp.Body.Close()
+	want := []string{"streamed body text/plain", "streamed body text/plain"}
+	if fmt.Sprint(bodies) != fmt.Sprint(want) {
+		t.Errorf("bodies = %q, want %q", bodies, want)
+	}
+}
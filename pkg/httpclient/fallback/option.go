@@ -6,6 +6,7 @@ import (
 	"math/rand/v2"
 	"net/http"
 	"slices"
+	"time"
 
 	"github.com/telepair/telepair/pkg/httpclient"
 )
@@ -14,20 +15,33 @@ import (
 type Option func(*fallback)
 
 type fallback struct {
-	ctx      context.Context
-	header   http.Header
-	client   httpclient.Client
-	selector SelectStrategy
-	retry    RetryChecker
-	logger   *slog.Logger
+	ctx        context.Context
+	header     http.Header
+	client     httpclient.Client
+	selector   SelectStrategy
+	hedgeDelay time.Duration
+	tracker    *Tracker
+	weights    map[string]int
+	retry      RetryChecker
+	hook       RequestHook
+	observer   AttemptObserver
+	logger     *slog.Logger
+
+	// attempts are the attempts of the current call
+	attempts []Attempt
 }
 
+// DefaultHedgeDelay is the default delay before the hedged strategy starts the next url
+const DefaultHedgeDelay = 300 * time.Millisecond
+
 func defaultFallback() *fallback {
 	return &fallback{
-		client:   httpclient.NoRetryClient,
-		selector: SelectStrategyRoundRobin,
-		retry:    DefaultRetry,
-		logger:   slog.With("component", "httpclient/fallback"),
+		client:     httpclient.NoRetryClient,
+		selector:   SelectStrategyRoundRobin,
+		hedgeDelay: DefaultHedgeDelay,
+		tracker:    DefaultTracker,
+		retry:      DefaultRetry,
+		logger:     slog.With("component", "httpclient/fallback"),
 	}
 }
 
@@ -35,13 +49,33 @@ func defaultFallback() *fallback {
 type SelectStrategy string
 
 const (
+	// SelectStrategyRoundRobin tries the urls in the declared order
 	SelectStrategyRoundRobin SelectStrategy = "round_robin"
 	SelectStrategyRandom     SelectStrategy = "random"
+	// SelectStrategyLatency tries the urls by the moving average of their latency,
+	// the urls without a request are tried first
+	SelectStrategyLatency SelectStrategy = "latency"
+	// SelectStrategyLeastFailures tries the urls by the moving average of their failures
+	SelectStrategyLeastFailures SelectStrategy = "least_failures"
+	// SelectStrategyWeighted tries the urls in a random order weighted by the url weights
+	SelectStrategyWeighted SelectStrategy = "weighted"
+	// SelectStrategyRace sends the request to all the urls at once,
+	// the first acceptable response wins and the other requests are cancelled
+	SelectStrategyRace SelectStrategy = "race"
+	// SelectStrategyHedged starts the next url when the previous ones have not responded
+	// after the hedge delay, or have failed. The first acceptable response wins and
+	// the other requests are cancelled. Like race, it requires a method safe to duplicate,
+	// or an idempotency key, see IsParallelSafe.
+	SelectStrategyHedged SelectStrategy = "hedged"
 )
 
 // RetryChecker is a function that checks if the response should be retried
 type RetryChecker func(resp *http.Response) bool
 
+// RequestHook is a function that modifies the request before it is sent to each url,
+// e.g. to sign the request
+type RequestHook func(req *http.Request) error
+
 // DefaultRetry is a default retry checker that retries on internal server error
 var DefaultRetry = func(resp *http.Response) bool {
 	if resp == nil {
@@ -64,7 +98,8 @@ var DefaultRetry = func(resp *http.Response) bool {
 func WithSelectStrategy(strategy SelectStrategy) Option {
 	return func(f *fallback) {
 		switch strategy {
-		case SelectStrategyRoundRobin, SelectStrategyRandom:
+		case SelectStrategyRoundRobin, SelectStrategyRandom, SelectStrategyRace, SelectStrategyHedged,
+			SelectStrategyLatency, SelectStrategyLeastFailures, SelectStrategyWeighted:
 			f.selector = strategy
 		default:
 			slog.Warn("invalid select strategy, use round_robin instead", "strategy", strategy)
@@ -73,6 +108,38 @@ func WithSelectStrategy(strategy SelectStrategy) Option {
 	}
 }
 
+// WithHedgeDelay sets the delay before the hedged strategy starts the next url
+func WithHedgeDelay(delay time.Duration) Option {
+	return func(f *fallback) {
+		if delay > 0 {
+			f.hedgeDelay = delay
+		} else {
+			slog.Warn("invalid hedge delay, use default delay instead", "delay", delay)
+			f.hedgeDelay = DefaultHedgeDelay
+		}
+	}
+}
+
+// WithTracker sets the tracker of the url outcomes, the default tracker is shared by the fallbacks
+func WithTracker(tracker *Tracker) Option {
+	return func(f *fallback) {
+		if tracker != nil {
+			f.tracker = tracker
+		} else {
+			slog.Warn("tracker is nil, use default tracker instead")
+			f.tracker = DefaultTracker
+		}
+	}
+}
+
+// WithWeights sets the weights of the urls for the weighted strategy, the default weight is 1
+// and the urls with a zero weight are not tried
+func WithWeights(weights map[string]int) Option {
+	return func(f *fallback) {
+		f.weights = weights
+	}
+}
+
 // WithRetryClient sets the retry client for the fallback
 func WithRetryClient(client httpclient.Client) Option {
 	return func(f *fallback) {
@@ -127,6 +194,21 @@ func WithHeader(header http.Header) Option {
 	}
 }
 
+// WithRequestHook sets the hook called on the request to each url.
+func WithRequestHook(hook RequestHook) Option {
+	return func(f *fallback) {
+		f.hook = hook
+	}
+}
+
+// WithAttemptObserver sets the observer called after the request to each url,
+// the requests cancelled after a parallel strategy accepted a response are not observed
+func WithAttemptObserver(observer AttemptObserver) Option {
+	return func(f *fallback) {
+		f.observer = observer
+	}
+}
+
 // handleURLs handles the urls
 func handleURLs(urls []string, selector SelectStrategy) []string {
 	if len(urls) == 0 {
@@ -4,7 +4,9 @@ import (
 	"context"
 	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -27,6 +29,31 @@ func TestWithSelectStrategy(t *testing.T) {
 			strategy: SelectStrategyRandom,
 			want:     SelectStrategyRandom,
 		},
+		{
+			name:     "race strategy",
+			strategy: SelectStrategyRace,
+			want:     SelectStrategyRace,
+		},
+		{
+			name:     "hedged strategy",
+			strategy: SelectStrategyHedged,
+			want:     SelectStrategyHedged,
+		},
+		{
+			name:     "latency strategy",
+			strategy: SelectStrategyLatency,
+			want:     SelectStrategyLatency,
+		},
+		{
+			name:     "least failures strategy",
+			strategy: SelectStrategyLeastFailures,
+			want:     SelectStrategyLeastFailures,
+		},
+		{
+			name:     "weighted strategy",
+			strategy: SelectStrategyWeighted,
+			want:     SelectStrategyWeighted,
+		},
 		{
 			name:     "invalid strategy",
 			strategy: "invalid",
@@ -43,6 +70,26 @@ func TestWithSelectStrategy(t *testing.T) {
 	}
 }
 
+func TestWithHedgeDelay(t *testing.T) {
+	f := &fallback{}
+	WithHedgeDelay(time.Second)(f)
+	assert.Equal(t, time.Second, f.hedgeDelay)
+	WithHedgeDelay(0)(f)
+	assert.Equal(t, DefaultHedgeDelay, f.hedgeDelay)
+}
+
+func TestWithTracker(t *testing.T) {
+	f := &fallback{}
+	tracker := NewTracker()
+	WithTracker(tracker)(f)
+	assert.Same(t, tracker, f.tracker)
+	WithTracker(nil)(f)
+	assert.Same(t, DefaultTracker, f.tracker)
+
+	WithWeights(map[string]int{"a": 2})(f)
+	assert.Equal(t, map[string]int{"a": 2}, f.weights)
+}
+
 func TestWithClient(t *testing.T) {
 	c := httpclient.New()
 	tests := []struct {
@@ -176,6 +223,17 @@ func TestWithHeader(t *testing.T) {
 	assert.Equal(t, header, f.header)
 }
 
+func TestWithRequestHook(t *testing.T) {
+	f := &fallback{}
+	WithRequestHook(func(req *http.Request) error {
+		req.Header.Set("X-Test", "test")
+		return nil
+	})(f)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	assert.NoError(t, f.hook(req))
+	assert.Equal(t, "test", req.Header.Get("X-Test"))
+}
+
 func TestHandleURLs(t *testing.T) {
 	urls := []string{"https://example.com", "https://example.org", "https://example.com"}
 	urls1 := handleURLs(urls, SelectStrategyRoundRobin)
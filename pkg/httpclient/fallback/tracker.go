@@ -0,0 +1,264 @@
+package fallback
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultEWMAAlpha is the weight of the last sample in the latency and failure averages
+	DefaultEWMAAlpha = 0.3
+	// DefaultEjectAfter is the consecutive failures ejecting a url
+	DefaultEjectAfter = 3
+	// DefaultEjectCooldown is the time an ejected url is tried last
+	DefaultEjectCooldown = 30 * time.Second
+	// DefaultStatsTTL is the time the stats of a host are kept after its last request
+	DefaultStatsTTL = 10 * time.Minute
+)
+
+// DefaultTracker is the tracker shared by the fallbacks without a tracker option
+var DefaultTracker = NewTracker()
+
+// URLStats is the outcome of the requests to a url
+type URLStats struct {
+	Requests int
+	Failures int
+	// ConsecutiveFailures is reset by a successful request
+	ConsecutiveFailures int
+	// Latency is the moving average of the latency of the requests
+	Latency time.Duration
+	// FailureRate is the moving average of the failures, between 0 and 1
+	FailureRate float64
+	// EjectedUntil is the end of the cooldown of an ejected url
+	EjectedUntil time.Time
+
+	// updatedAt is the time of the last request
+	updatedAt time.Time
+}
+
+// Ejected reports whether the url is ejected at the time
+func (s URLStats) Ejected(now time.Time) bool {
+	return now.Before(s.EjectedUntil)
+}
+
+// Tracker tracks the outcome of the requests to the urls across the calls.
+// The stats are kept by the host of the urls, so the rendered urls of a template
+// share them, and they are evicted after the stats TTL without a request.
+// A url is ejected for a cooldown after consecutive failures, the ejected urls
+// are tried last by the latency and least failures strategies.
+type Tracker struct {
+	alpha      float64
+	ejectAfter int
+	cooldown   time.Duration
+	ttl        time.Duration
+
+	mu        sync.Mutex
+	stats     map[string]*URLStats
+	lastEvict time.Time
+	now       func() time.Time
+}
+
+// TrackerOption is an option for the tracker
+type TrackerOption func(*Tracker)
+
+// WithEjection sets the consecutive failures ejecting a url and the cooldown of the ejection,
+// a non positive count disables the ejection
+func WithEjection(after int, cooldown time.Duration) TrackerOption {
+	return func(t *Tracker) {
+		t.ejectAfter = after
+		if cooldown > 0 {
+			t.cooldown = cooldown
+		}
+	}
+}
+
+// WithEWMAAlpha sets the weight of the last sample in the moving averages, between 0 and 1
+func WithEWMAAlpha(alpha float64) TrackerOption {
+	return func(t *Tracker) {
+		if alpha > 0 && alpha <= 1 {
+			t.alpha = alpha
+		}
+	}
+}
+
+// WithStatsTTL sets the time the stats of a host are kept after its last request
+func WithStatsTTL(ttl time.Duration) TrackerOption {
+	return func(t *Tracker) {
+		if ttl > 0 {
+			t.ttl = ttl
+		}
+	}
+}
+
+// NewTracker creates a tracker
+func NewTracker(opts ...TrackerOption) *Tracker {
+	t := &Tracker{
+		alpha:      DefaultEWMAAlpha,
+		ejectAfter: DefaultEjectAfter,
+		cooldown:   DefaultEjectCooldown,
+		ttl:        DefaultStatsTTL,
+		stats:      make(map[string]*URLStats),
+		now:        time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Observe records the outcome of a request to the url
+func (t *Tracker) Observe(url string, latency time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.evict(now)
+	key := statsKey(url)
+	s, ok := t.stats[key]
+	if !ok {
+		s = &URLStats{Latency: latency}
+		if failed {
+			s.FailureRate = 1
+		}
+		t.stats[key] = s
+	} else {
+		s.Latency = time.Duration(t.alpha*float64(latency) + (1-t.alpha)*float64(s.Latency))
+		sample := 0.0
+		if failed {
+			sample = 1
+		}
+		s.FailureRate = t.alpha*sample + (1-t.alpha)*s.FailureRate
+	}
+	s.Requests++
+	s.updatedAt = now
+	if !failed {
+		s.ConsecutiveFailures = 0
+		s.EjectedUntil = time.Time{}
+		return
+	}
+	s.Failures++
+	s.ConsecutiveFailures++
+	if t.ejectAfter > 0 && s.ConsecutiveFailures >= t.ejectAfter {
+		s.EjectedUntil = now.Add(t.cooldown)
+	}
+}
+
+// Stats returns the stats of the host of the url
+func (t *Tracker) Stats(url string) URLStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.get(url, t.now())
+}
+
+// get returns the stats of the host of the url, the expired stats are zero
+func (t *Tracker) get(url string, now time.Time) URLStats {
+	if s, ok := t.stats[statsKey(url)]; ok && now.Sub(s.updatedAt) < t.ttl {
+		return *s
+	}
+	return URLStats{}
+}
+
+// evict removes the expired stats, at most once per TTL
+func (t *Tracker) evict(now time.Time) {
+	if now.Sub(t.lastEvict) < t.ttl {
+		return
+	}
+	t.lastEvict = now
+	for key, s := range t.stats {
+		if now.Sub(s.updatedAt) >= t.ttl {
+			delete(t.stats, key)
+		}
+	}
+}
+
+// statsKey returns the key of the stats of the url, its host, or the url itself without a host
+func statsKey(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
+
+// order returns the urls in the order they are tried with the strategy, the other strategies
+// keep the order of the urls
+func (t *Tracker) order(urls []string, strategy SelectStrategy, weights map[string]int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	urls = slices.Clone(urls)
+	stats := func(url string) URLStats {
+		return t.get(url, now)
+	}
+	switch strategy {
+	case SelectStrategyLatency:
+		// the urls without a sample have a zero latency, so that they are tried
+		slices.SortStableFunc(urls, func(a, b string) int {
+			return int(stats(a).Latency - stats(b).Latency)
+		})
+	case SelectStrategyLeastFailures:
+		slices.SortStableFunc(urls, func(a, b string) int {
+			sa, sb := stats(a), stats(b)
+			switch {
+			case sa.FailureRate < sb.FailureRate:
+				return -1
+			case sa.FailureRate > sb.FailureRate:
+				return 1
+			}
+			return int(sa.Latency - sb.Latency)
+		})
+	case SelectStrategyWeighted:
+		return weightedOrder(urls, weights)
+	default:
+		return urls
+	}
+
+	// the ejected urls are tried last, in the order of the end of their cooldown
+	slices.SortStableFunc(urls, func(a, b string) int {
+		ea, eb := stats(a).Ejected(now), stats(b).Ejected(now)
+		switch {
+		case ea && eb:
+			return stats(a).EjectedUntil.Compare(stats(b).EjectedUntil)
+		case ea:
+			return 1
+		case eb:
+			return -1
+		}
+		return 0
+	})
+	return urls
+}
+
+// weightedOrder orders the urls by a weighted random choice without replacement,
+// the urls without a weight have a weight of 1, and the urls with a zero weight are excluded
+func weightedOrder(urls []string, weights map[string]int) []string {
+	weight := func(url string) int {
+		if w, ok := weights[url]; ok {
+			return w
+		}
+		return 1
+	}
+	urls = slices.DeleteFunc(slices.Clone(urls), func(url string) bool {
+		return weight(url) <= 0
+	})
+	total := 0
+	for _, url := range urls {
+		total += weight(url)
+	}
+	ordered := make([]string, 0, len(urls))
+	for len(urls) > 0 {
+		n := rand.IntN(total) //nolint:gosec
+		for i, url := range urls {
+			if n -= weight(url); n < 0 {
+				ordered = append(ordered, url)
+				total -= weight(url)
+				urls = slices.Delete(urls, i, i+1)
+				break
+			}
+		}
+	}
+	return ordered
+}
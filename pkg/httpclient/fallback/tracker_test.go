@@ -0,0 +1,129 @@
+package fallback
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrackerObserve(t *testing.T) {
+	tracker := NewTracker(WithEWMAAlpha(0.5), WithEjection(2, time.Minute))
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	tracker.Observe("a", 100*time.Millisecond, false)
+	tracker.Observe("a", 200*time.Millisecond, true)
+	s := tracker.Stats("a")
+	assert.Equal(t, 2, s.Requests)
+	assert.Equal(t, 1, s.Failures)
+	assert.Equal(t, 1, s.ConsecutiveFailures)
+	assert.Equal(t, 150*time.Millisecond, s.Latency)
+	assert.InDelta(t, 0.5, s.FailureRate, 0.001)
+	assert.False(t, s.Ejected(now))
+
+	tracker.Observe("a", 200*time.Millisecond, true)
+	s = tracker.Stats("a")
+	assert.True(t, s.Ejected(now))
+	assert.False(t, s.Ejected(now.Add(time.Minute)))
+
+	tracker.Observe("a", 100*time.Millisecond, false)
+	s = tracker.Stats("a")
+	assert.Zero(t, s.ConsecutiveFailures)
+	assert.False(t, s.Ejected(now))
+
+	assert.Equal(t, URLStats{}, tracker.Stats("unknown"))
+}
+
+func TestTrackerOrder(t *testing.T) {
+	tracker := NewTracker()
+	tracker.Observe("slow", time.Second, false)
+	tracker.Observe("fast", 10*time.Millisecond, false)
+	tracker.Observe("flaky", 5*time.Millisecond, true)
+	urls := []string{"slow", "flaky", "fast", "new"}
+
+	assert.Equal(t, urls, tracker.order(urls, SelectStrategyRoundRobin, nil))
+	assert.Equal(t, []string{"new", "flaky", "fast", "slow"}, tracker.order(urls, SelectStrategyLatency, nil))
+	assert.Equal(t, []string{"new", "fast", "slow", "flaky"}, tracker.order(urls, SelectStrategyLeastFailures, nil))
+
+	// the ejected urls are tried last by the latency and least failures strategies
+	tracker.Observe("flaky", 5*time.Millisecond, true)
+	tracker.Observe("flaky", 5*time.Millisecond, true)
+	assert.Equal(t, urls, tracker.order(urls, SelectStrategyRoundRobin, nil))
+	assert.Equal(t, []string{"new", "fast", "slow", "flaky"}, tracker.order(urls, SelectStrategyLatency, nil))
+	assert.Equal(t, []string{"slow", "flaky", "fast", "new"}, urls)
+}
+
+func TestTrackerStatsByHost(t *testing.T) {
+	tracker := NewTracker(WithStatsTTL(time.Minute))
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+
+	// the rendered urls of a host share the stats
+	tracker.Observe("https://a.example.com/users/1", time.Second, false)
+	tracker.Observe("https://a.example.com/users/2?q=x", time.Second, true)
+	assert.Equal(t, 2, tracker.Stats("https://a.example.com/").Requests)
+	assert.Zero(t, tracker.Stats("https://b.example.com/users/1").Requests)
+
+	// the stats expire without a request, and are evicted on the next observation
+	now = now.Add(time.Minute)
+	assert.Equal(t, URLStats{}, tracker.Stats("https://a.example.com/"))
+	tracker.Observe("https://b.example.com/", time.Second, false)
+	tracker.mu.Lock()
+	assert.Len(t, tracker.stats, 1)
+	tracker.mu.Unlock()
+}
+
+func TestWeightedOrder(t *testing.T) {
+	urls := []string{"a", "b", "c"}
+	first := map[string]int{}
+	for range 1000 {
+		ordered := weightedOrder(urls, map[string]int{"a": 8, "c": 0})
+		assert.Equal(t, []string{"a", "b"}, slices.Sorted(slices.Values(ordered)), "the zero weight urls are excluded")
+		first[ordered[0]]++
+	}
+	assert.Greater(t, first["a"], 800)
+	assert.Positive(t, first["b"])
+	assert.Zero(t, first["c"])
+}
+
+func TestDo_EjectsFailingURL(t *testing.T) {
+	var badCalls int
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		badCalls++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	tracker := NewTracker(WithEjection(2, time.Minute))
+	for range 3 {
+		resp, err := Get([]string{bad.URL, good.URL}, WithTracker(tracker), WithSelectStrategy(SelectStrategyLeastFailures))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+	assert.Equal(t, 1, badCalls, "the failing url is tried last")
+
+	// the round robin strategy keeps the declared order of the ejected urls
+	for range 2 {
+		resp, err := Get([]string{bad.URL, good.URL}, WithTracker(tracker))
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+	assert.Equal(t, 3, badCalls)
+	assert.True(t, tracker.Stats(bad.URL).Ejected(time.Now()))
+	assert.Equal(t, 5, tracker.Stats(good.URL).Requests)
+
+	resp, err := Get([]string{bad.URL, good.URL}, WithTracker(tracker), WithSelectStrategy(SelectStrategyLatency))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 3, badCalls, "the ejected url is tried last")
+}
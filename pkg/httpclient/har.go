@@ -0,0 +1,383 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/telepair/telepair/pkg/version"
+)
+
+// HARVersion is the version of the HAR files written by the HARRecorder
+const HARVersion = "1.2"
+
+// ErrNoRecordedResponse is returned by the ReplayTransport when no recorded response matches the request
+var ErrNoRecordedResponse = errors.New("no recorded response")
+
+// HAR is a HTTP Archive, see http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the root of the exported data
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+// HARCreator is the application that created the log
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a recorded request and response
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+}
+
+// HARRequest is a recorded request
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse is a recorded response
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a header, cookie or query parameter
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is the body of a recorded request
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent is the body of a recorded response, the binary bodies are base64 encoded
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings is the timings of a recorded exchange in milliseconds
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAREntry converts a recorded exchange to a HAR entry
+func NewHAREntry(exchange *Exchange) *HAREntry {
+	entry := &HAREntry{
+		StartedDateTime: exchange.StartedAt,
+		Time:            milliseconds(exchange.Duration),
+		Request: HARRequest{
+			Method:      exchange.Method,
+			URL:         exchange.URL,
+			HTTPVersion: exchange.Proto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(exchange.RequestHeader),
+			QueryString: []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(exchange.RequestBody),
+		},
+		Response: HARResponse{
+			Status:      exchange.Status,
+			StatusText:  exchange.StatusText,
+			HTTPVersion: exchange.ResponseProto,
+			Cookies:     []HARNameValue{},
+			Headers:     harHeaders(exchange.ResponseHeader),
+			Content: HARContent{
+				Size:     len(exchange.ResponseBody),
+				MimeType: exchange.ResponseHeader.Get("Content-Type"),
+			},
+			RedirectURL: exchange.ResponseHeader.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    len(exchange.ResponseBody),
+		},
+		Timings: HARTimings{
+			Wait:    milliseconds(exchange.Wait),
+			Receive: milliseconds(exchange.Duration - exchange.Wait),
+		},
+	}
+	if u, err := url.Parse(exchange.URL); err == nil {
+		for k, v := range u.Query() {
+			for _, vv := range v {
+				entry.Request.QueryString = append(entry.Request.QueryString, HARNameValue{Name: k, Value: vv})
+			}
+		}
+	}
+	if len(exchange.RequestBody) > 0 {
+		entry.Request.PostData = &HARPostData{
+			MimeType: exchange.RequestHeader.Get("Content-Type"),
+			Text:     string(exchange.RequestBody),
+		}
+		if exchange.RequestBodyTruncated {
+			entry.Request.BodySize = -1
+			entry.Request.PostData.Comment = fmt.Sprintf("truncated to the first %d bytes", len(exchange.RequestBody))
+		}
+	}
+	if utf8.Valid(exchange.ResponseBody) {
+		entry.Response.Content.Text = string(exchange.ResponseBody)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(exchange.ResponseBody)
+		entry.Response.Content.Encoding = "base64"
+	}
+	if exchange.ResponseBodyTruncated {
+		entry.Response.BodySize = -1
+		entry.Response.Content.Comment = fmt.Sprintf("truncated to the first %d bytes", len(exchange.ResponseBody))
+	}
+	return entry
+}
+
+// Body returns the decoded body of the recorded response
+func (c HARContent) Body() ([]byte, error) {
+	if c.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(c.Text)
+	}
+	return []byte(c.Text), nil
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	headers := make([]HARNameValue, 0, len(header))
+	for k, v := range header {
+		for _, vv := range v {
+			headers = append(headers, HARNameValue{Name: k, Value: vv})
+		}
+	}
+	return headers
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// LoadHAR reads a HAR file
+func LoadHAR(path string) (*HAR, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	har := &HAR{}
+	if err := json.Unmarshal(data, har); err != nil {
+		return nil, fmt.Errorf("invalid HAR file %s: %w", path, err)
+	}
+	return har, nil
+}
+
+// WriteFile writes the HAR to a file, the file is replaced atomically
+func (h *HAR) WriteFile(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint:errcheck
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// HARRecorder records the exchanges as HAR entries, the HAR file is written
+// by Flush and Close when the path is not empty.
+type HARRecorder struct {
+	path  string
+	mu    sync.Mutex
+	har   *HAR
+	dirty bool
+}
+
+// NewHARRecorder creates a HAR recorder writing to the path, an empty path keeps the HAR in memory
+func NewHARRecorder(path string) *HARRecorder {
+	return &HARRecorder{
+		path: path,
+		har: &HAR{Log: HARLog{
+			Version: HARVersion,
+			Creator: HARCreator{Name: "telepair", Version: version.GetInfo().Version},
+			Entries: []*HAREntry{},
+		}},
+	}
+}
+
+// Record appends the exchange to the HAR
+func (r *HARRecorder) Record(exchange *Exchange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.har.Log.Entries = append(r.har.Log.Entries, NewHAREntry(exchange))
+	r.dirty = true
+	return nil
+}
+
+// Flush writes the HAR file if exchanges were recorded since the last write
+func (r *HARRecorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.path == "" || !r.dirty {
+		return nil
+	}
+	if err := r.har.WriteFile(r.path); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// Close writes the HAR file, the exchanges recorded after it are written by the next Flush
+func (r *HARRecorder) Close() error {
+	return r.Flush()
+}
+
+// HAR returns a copy of the recorded HAR
+func (r *HARRecorder) HAR() *HAR {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	har := *r.har
+	har.Log.Entries = append([]*HAREntry(nil), r.har.Log.Entries...)
+	return &har
+}
+
+// ReplayTransport serves the recorded responses of a HAR without sending the requests.
+// A request matches the entries with the same method and URL, the secret values of the
+// request context are masked before matching. The matching entries are served in order,
+// the last one is served again once all of them are used.
+type ReplayTransport struct {
+	mu      sync.Mutex
+	entries []*HAREntry
+	served  map[string]int
+}
+
+// NewReplayTransport creates a transport replaying the HAR entries
+func NewReplayTransport(har *HAR) *ReplayTransport {
+	t := &ReplayTransport{served: make(map[string]int)}
+	if har != nil {
+		t.entries = har.Log.Entries
+	}
+	return t
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	u := MaskSecrets(req.Context(), req.URL.String())
+
+	entry := t.next(method, u)
+	if entry == nil {
+		return nil, fmt.Errorf("%w for %s %s", ErrNoRecordedResponse, method, u)
+	}
+	body, err := entry.Response.Content.Body()
+	if err != nil {
+		return nil, fmt.Errorf("invalid recorded response body for %s %s: %w", method, u, err)
+	}
+
+	header := make(http.Header, len(entry.Response.Headers))
+	for _, h := range entry.Response.Headers {
+		header.Add(h.Name, h.Value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	if header.Get("Content-Type") == "" && entry.Response.Content.MimeType != "" {
+		if _, _, err := mime.ParseMediaType(entry.Response.Content.MimeType); err == nil {
+			header.Set("Content-Type", entry.Response.Content.MimeType)
+		}
+	}
+
+	proto := entry.Response.HTTPVersion
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	major, minor, ok := http.ParseHTTPVersion(strings.ToUpper(proto))
+	if !ok {
+		major, minor = 1, 1
+	}
+	statusText := entry.Response.StatusText
+	if statusText == "" {
+		statusText = http.StatusText(entry.Response.Status)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, statusText),
+		StatusCode:    entry.Response.Status,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// next returns the next entry matching the method and URL
+func (t *ReplayTransport) next(method, u string) *HAREntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := method + " " + u
+	var matched []*HAREntry
+	for _, entry := range t.entries {
+		if strings.EqualFold(entry.Request.Method, method) && entry.Request.URL == u {
+			matched = append(matched, entry)
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	i := min(t.served[key], len(matched)-1)
+	t.served[key] = i + 1
+	return matched[i]
+}
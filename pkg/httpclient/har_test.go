@@ -0,0 +1,152 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/telepair/telepair/pkg/version"
+)
+
+func TestHARRecordAndReplay(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = w.Write([]byte(`{"echo":"` + string(body) + `","call":` + string(rune('0'+calls)) + `}`))
+	}))
+
+	path := filepath.Join(t.TempDir(), "session.har")
+	recorder := NewHARRecorder(path)
+	c := New(WithRecorder(recorder), WithRetry(1, 1, 1))
+
+	ctx := ContextWithSecrets(context.Background(), "s3cret")
+	idempotent := WithHeader(http.Header{IdempotencyKeyHeader: []string{"login-1"}})
+	resp, err := c.Post(ts.URL+"/login?token=s3cret", []byte("s3cret"), WithContext(ctx), idempotent)
+	require.NoError(t, err)
+	_, body, err := ParseResponse(resp)
+	require.NoError(t, err)
+	assert.Equal(t, `{"echo":"s3cret","call":2}`, string(body))
+	ts.Close()
+
+	// the file is written on close
+	_, err = LoadHAR(path)
+	require.Error(t, err)
+	require.NoError(t, recorder.Close())
+
+	// every attempt is recorded with the secrets masked
+	har, err := LoadHAR(path)
+	require.NoError(t, err)
+	assert.Equal(t, HARVersion, har.Log.Version)
+	assert.Equal(t, version.GetInfo().Version, har.Log.Creator.Version)
+	require.Len(t, har.Log.Entries, 2)
+	entry := har.Log.Entries[1]
+	assert.Equal(t, http.MethodPost, entry.Request.Method)
+	assert.Equal(t, ts.URL+"/login?token=******", entry.Request.URL)
+	assert.Equal(t, []HARNameValue{{Name: "token", Value: "******"}}, entry.Request.QueryString)
+	assert.Equal(t, "******", entry.Request.PostData.Text)
+	assert.Equal(t, http.StatusOK, entry.Response.Status)
+	assert.Equal(t, `{"echo":"******","call":2}`, entry.Response.Content.Text)
+	assert.Equal(t, http.StatusServiceUnavailable, har.Log.Entries[0].Response.Status)
+
+	// the recorded responses are served in order without the server,
+	// the last one is served again once all of them are used
+	replay := New(WithTransport(NewReplayTransport(har)), WithRetry(1, 1, 1))
+	for range 2 {
+		resp, err = replay.Post(ts.URL+"/login?token=s3cret", []byte("s3cret"), WithContext(ctx), idempotent)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		_, body, err = ParseResponse(resp)
+		require.NoError(t, err)
+		assert.Equal(t, `{"echo":"******","call":2}`, string(body))
+	}
+
+	_, err = replay.Get(ts.URL + "/unknown")
+	assert.ErrorIs(t, err, ErrNoRecordedResponse)
+}
+
+func TestNewHAREntryBinaryBody(t *testing.T) {
+	exchange := &Exchange{
+		Method:         http.MethodGet,
+		URL:            "https://example.com/image",
+		Status:         http.StatusOK,
+		ResponseHeader: http.Header{"Content-Type": []string{"image/png"}},
+		ResponseBody:   []byte{0x89, 0x50, 0x4e, 0x47, 0xff},
+	}
+	entry := NewHAREntry(exchange)
+	assert.Equal(t, "base64", entry.Response.Content.Encoding)
+	assert.Equal(t, "image/png", entry.Response.Content.MimeType)
+	assert.Nil(t, entry.Request.PostData)
+
+	body, err := entry.Response.Content.Body()
+	require.NoError(t, err)
+	assert.Equal(t, exchange.ResponseBody, body)
+}
+
+func TestHARRecorderInMemory(t *testing.T) {
+	recorder := NewHARRecorder("")
+	require.NoError(t, recorder.Record(&Exchange{Method: http.MethodGet, URL: "https://example.com"}))
+	har := recorder.HAR()
+	require.Len(t, har.Log.Entries, 1)
+	assert.Equal(t, "https://example.com", har.Log.Entries[0].Request.URL)
+}
+
+func TestRecordTruncatesRequestBody(t *testing.T) {
+	var received int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = len(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	recorder := NewHARRecorder("")
+	c := New(WithRecorder(recorder), WithRetry(0, 1, 1))
+	resp, err := c.Post(ts.URL, bytes.Repeat([]byte("a"), RecordMaxRequestBodySize+10))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// the whole body is sent, the recorded body is truncated
+	assert.Equal(t, RecordMaxRequestBodySize+10, received)
+	har := recorder.HAR()
+	require.Len(t, har.Log.Entries, 1)
+	entry := har.Log.Entries[0]
+	assert.Len(t, entry.Request.PostData.Text, RecordMaxRequestBodySize)
+	assert.Contains(t, entry.Request.PostData.Comment, "truncated")
+	assert.Equal(t, -1, entry.Request.BodySize)
+}
+
+func TestRecordTruncatesResponseBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("a"), RecordMaxResponseBodySize+10))
+	}))
+	defer ts.Close()
+
+	recorder := NewHARRecorder("")
+	c := New(WithRecorder(recorder), WithRetry(0, 1, 1))
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// the whole body is read, the recorded body is truncated
+	assert.Len(t, body, RecordMaxResponseBodySize+10)
+	har := recorder.HAR()
+	require.Len(t, har.Log.Entries, 1)
+	entry := har.Log.Entries[0]
+	assert.Len(t, entry.Response.Content.Text, RecordMaxResponseBodySize)
+	assert.Contains(t, entry.Response.Content.Comment, "truncated")
+	assert.Equal(t, -1, entry.Response.BodySize)
+}
@@ -0,0 +1,222 @@
+package httpclient
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Middleware wraps the transport of the client, e.g. to sign or trace the requests.
+// The middlewares wrap every attempt of a retried request.
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc is a function transport
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req)
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps the transport with the middlewares, the first middleware is the outermost one
+func Chain(transport http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			transport = middlewares[i](transport)
+		}
+	}
+	return transport
+}
+
+// HeaderMiddleware sets the headers which are not set in the request
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for k, v := range header {
+				if _, ok := req.Header[http.CanonicalHeaderKey(k)]; ok {
+					continue
+				}
+				for _, vv := range v {
+					req.Header.Add(k, vv)
+				}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// AuthMiddleware authorizes the requests, e.g. sets the credentials or signs the request
+func AuthMiddleware(authorize func(req *http.Request) error) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			if err := authorize(req); err != nil {
+				closeBody(req)
+				return nil, err
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+type authorizerKey struct{}
+
+// ContextWithAuthorizer returns a context carrying the authorizer of the requests.
+// The clients call it on every attempt, so that the retried requests are signed again.
+func ContextWithAuthorizer(ctx context.Context, authorize func(req *http.Request) error) context.Context {
+	return context.WithValue(ctx, authorizerKey{}, authorize)
+}
+
+// contextAuthMiddleware authorizes the requests with the authorizer of their context
+func contextAuthMiddleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if authorize, ok := req.Context().Value(authorizerKey{}).(func(req *http.Request) error); ok && authorize != nil {
+			return AuthMiddleware(authorize)(next).RoundTrip(req)
+		}
+		return next.RoundTrip(req)
+	})
+}
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context carrying the request ID,
+// it is propagated to the header of the outgoing requests.
+func ContextWithRequestID(ctx context.Context, rid string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, rid)
+}
+
+// RequestIDFromContext returns the request ID carried by the context
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	rid, _ := ctx.Value(requestIDKey{}).(string)
+	return rid
+}
+
+// RequestIDMiddleware sets the request ID header of the requests, the ID of the
+// request context is propagated, a new one is generated if the context has none.
+func RequestIDMiddleware(key string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			GenRequestID(req, key)
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// CompressionMiddleware accepts the gzip and deflate encodings and decompresses the responses.
+// The requests with an Accept-Encoding header are left untouched.
+func CompressionMiddleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get("Accept-Encoding") != "" {
+				return next.RoundTrip(req)
+			}
+			req = req.Clone(req.Context())
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+
+			var body io.ReadCloser
+			switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+			case "gzip":
+				body = &gzipBody{body: resp.Body}
+			case "deflate":
+				body = &decompressBody{Reader: flate.NewReader(resp.Body), body: resp.Body}
+			default:
+				return resp, nil
+			}
+			resp.Body = body
+			resp.Header.Del("Content-Encoding")
+			resp.Header.Del("Content-Length")
+			resp.ContentLength = -1
+			resp.Uncompressed = true
+			return resp, nil
+		})
+	}
+}
+
+// gzipBody reads the gzip header lazily, so that an empty body is not an error
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// decompressBody closes the compressed body along with the reader
+type decompressBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *decompressBody) Close() error {
+	if c, ok := b.Reader.(io.Closer); ok {
+		_ = c.Close()
+	}
+	return b.body.Close()
+}
+
+// Metric is the result of a request attempt
+type Metric struct {
+	Method   string
+	Host     string
+	Status   int
+	Duration time.Duration
+	Err      error
+}
+
+// MetricsMiddleware observes the result of every request attempt.
+// The duration is the time to receive the response headers.
+func MetricsMiddleware(observe func(m Metric)) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			started := time.Now()
+			resp, err := next.RoundTrip(req)
+			m := Metric{
+				Method:   req.Method,
+				Host:     req.URL.Host,
+				Duration: time.Since(started),
+				Err:      err,
+			}
+			if resp != nil {
+				m.Status = resp.StatusCode
+			}
+			observe(m)
+			return resp, err
+		})
+	}
+}
+
+// closeBody closes the body of a request which is not sent,
+// as the transport would do.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,125 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddlewareChain(t *testing.T) {
+	var got http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		_, _ = zw.Write([]byte("hello"))
+		_ = zw.Close()
+	}))
+	defer ts.Close()
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	var metrics []Metric
+	c := New(
+		WithRetry(0, 1, 1),
+		WithMiddleware(
+			trace("first"),
+			trace("second"),
+			HeaderMiddleware(http.Header{"X-Env": []string{"test"}, "Accept": []string{"text/html"}}),
+			AuthMiddleware(func(req *http.Request) error {
+				req.Header.Set("Authorization", "Bearer token")
+				return nil
+			}),
+			RequestIDMiddleware("X-Trace-ID"),
+			CompressionMiddleware(),
+			MetricsMiddleware(func(m Metric) { metrics = append(metrics, m) }),
+		),
+	)
+
+	ctx := ContextWithRequestID(context.Background(), "rid-1")
+	resp, err := c.Get(ts.URL, WithContext(ctx), WithHeader(http.Header{"Accept": []string{"text/plain"}}))
+	require.NoError(t, err)
+	_, body, err := ParseResponse(resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, "hello", string(body))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"first", "second"}, order)
+	assert.Equal(t, "test", got.Get("X-Env"))
+	assert.Equal(t, "text/plain", got.Get("Accept"))
+	assert.Equal(t, "Bearer token", got.Get("Authorization"))
+	assert.Equal(t, "rid-1", got.Get("X-Trace-ID"))
+	assert.Equal(t, "rid-1", got.Get(DefaultRequestIDKey))
+	assert.Equal(t, "gzip, deflate", got.Get("Accept-Encoding"))
+	require.Len(t, metrics, 1)
+	assert.Equal(t, http.MethodGet, metrics[0].Method)
+	assert.Equal(t, http.StatusOK, metrics[0].Status)
+	assert.NoError(t, metrics[0].Err)
+}
+
+func TestAuthMiddlewareError(t *testing.T) {
+	errAuth := errors.New("no credentials")
+	transport := Chain(RoundTripperFunc(func(_ *http.Request) (*http.Response, error) {
+		t.Fatal("the request should not be sent")
+		return nil, nil
+	}), AuthMiddleware(func(_ *http.Request) error { return errAuth }))
+
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com", bytes.NewBufferString("body"))
+	_, err := transport.RoundTrip(req)
+	assert.ErrorIs(t, err, errAuth)
+}
+
+func TestContextWithAuthorizer(t *testing.T) {
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("X-Attempt"))
+		if len(got) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	attempts := 0
+	ctx := ContextWithAuthorizer(context.Background(), func(req *http.Request) error {
+		attempts++
+		req.Header.Set("X-Attempt", strconv.Itoa(attempts))
+		return nil
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	resp, err := New(WithRetry(1, time.Millisecond, time.Millisecond)).Do(req)
+	assert.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, []string{"1", "2"}, got)
+	assert.Empty(t, req.Header.Get("X-Attempt"), "the request of the caller is not modified")
+}
+
+func TestCompressionMiddlewareKeepsAcceptEncoding(t *testing.T) {
+	transport := Chain(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		assert.Equal(t, "br", req.Header.Get("Accept-Encoding"))
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Encoding": []string{"br"}}}, nil
+	}), CompressionMiddleware())
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+}
@@ -2,6 +2,8 @@ package httpclient
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"log/slog"
 	"net/http"
 	"time"
@@ -20,8 +22,9 @@ const (
 type RequestOption func(*request)
 
 type request struct {
-	ctx    context.Context
-	header http.Header
+	ctx         context.Context
+	header      http.Header
+	retryBudget time.Duration
 }
 
 // WithContext sets the context for the request.
@@ -42,40 +45,52 @@ func WithHeader(header http.Header) RequestOption {
 	}
 }
 
+// WithRetryBudget limits the total time of the request including its retries,
+// it overrides the budget of the client retry policy.
+func WithRetryBudget(budget time.Duration) RequestOption {
+	return func(r *request) {
+		if budget > 0 {
+			r.retryBudget = budget
+		}
+	}
+}
+
 // Option is a function that configures the client.
 type Option func(*clientConfig)
 
 type clientConfig struct {
-	retryMax         int
-	retryWaitMin     time.Duration
-	retryWaitMax     time.Duration
-	defaultHeader    http.Header
-	logger           *slog.Logger
-	requestIDKey     string
-	skipTLSVerify    bool
-	dumpChan         <-chan []byte
-	dumpRequest      bool
-	dumpRequestBody  bool
-	dumpResponse     bool
-	dumpResponseBody bool
+	retry         RetryPolicy
+	defaultHeader http.Header
+	logger        *slog.Logger
+	requestIDKey  string
+	skipTLSVerify bool
+	certificates  []tls.Certificate
+	rootCAs       *x509.CertPool
+	serverName    string
+	minTLSVersion uint16
+	pinnedSPKI    []string
+	proxy         ProxyFunc
+	transport     http.RoundTripper
+	recorder      Recorder
+	middlewares   []Middleware
+	breaker       *BreakerConfig
+	rateLimit     *RateLimitConfig
 }
 
 // WithRetry sets the retry options for the client.
 func WithRetry(retryMax int, retryWaitMin, retryWaitMax time.Duration) Option {
 	return func(c *clientConfig) {
-		if retryMax < 0 {
-			retryMax = DefaultRetryMax
-		}
-		if retryWaitMin <= 0 {
-			retryWaitMin = DefaultRetryMinWait
-		}
-		if retryWaitMax <= 0 || retryWaitMax < retryWaitMin {
-			retryWaitMax = DefaultRetryMaxWait
-		}
+		c.retry.Max = retryMax
+		c.retry.WaitMin = retryWaitMin
+		c.retry.WaitMax = retryWaitMax
+		c.retry = c.retry.withDefaults()
+	}
+}
 
-		c.retryMax = retryMax
-		c.retryWaitMin = retryWaitMin
-		c.retryWaitMax = retryWaitMax
+// WithRetryPolicy sets the retry policy for the client, the invalid values use the defaults.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *clientConfig) {
+		c.retry = policy.withDefaults()
 	}
 }
 
@@ -113,22 +128,107 @@ func WithSkipTLSVerify(skipTLSVerify bool) Option {
 	}
 }
 
-// WithDump sets the dump options for the client.
-func WithDump(ch <-chan []byte, request bool, response bool, requestBody bool, responseBody bool) Option {
+// WithClientCertificates sets the client certificates for the mutual TLS,
+// e.g. loaded with tls.LoadX509KeyPair.
+func WithClientCertificates(certs ...tls.Certificate) Option {
+	return func(c *clientConfig) {
+		c.certificates = append(c.certificates, certs...)
+	}
+}
+
+// WithRootCAs sets the root CAs verifying the servers instead of the system ones,
+// e.g. loaded with LoadCertPool.
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(c *clientConfig) {
+		if pool != nil {
+			c.rootCAs = pool
+		}
+	}
+}
+
+// WithServerName overrides the server name sent in the SNI and verified in the server certificate.
+func WithServerName(serverName string) Option {
+	return func(c *clientConfig) {
+		c.serverName = serverName
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version, e.g. tls.VersionTLS13. It is TLS 1.2 by default.
+func WithMinTLSVersion(version uint16) Option {
+	return func(c *clientConfig) {
+		c.minTLSVersion = version
+	}
+}
+
+// WithPinnedSPKI pins the public keys of the servers, a server is accepted when a certificate
+// of its chain matches a pin. The pins are the base64 SHA-256 of the SubjectPublicKeyInfo,
+// optionally prefixed with sha256//, see SPKIPin.
+func WithPinnedSPKI(pins ...string) Option {
 	return func(c *clientConfig) {
-		if ch == nil {
-			slog.Warn("dump channel is nil, it will disable the dump")
-			return
+		c.pinnedSPKI = append(c.pinnedSPKI, pins...)
+	}
+}
+
+// WithProxy sets the outbound proxy of the requests, e.g. built by a ProxyConfig.
+// The proxies of the environment variables are used by default.
+func WithProxy(proxy ProxyFunc) Option {
+	return func(c *clientConfig) {
+		if proxy != nil {
+			c.proxy = proxy
+		}
+	}
+}
+
+// WithTransport sets the transport sending the requests, e.g. a ReplayTransport.
+// The TLS and proxy options are applied to a clone of the transport if it is a *http.Transport,
+// they are ignored otherwise.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(c *clientConfig) {
+		if transport != nil {
+			c.transport = transport
+		}
+	}
+}
+
+// WithRecorder sets the recorder of the request and response exchanges, e.g. a HARRecorder.
+func WithRecorder(recorder Recorder) Option {
+	return func(c *clientConfig) {
+		if recorder != nil {
+			c.recorder = recorder
 		}
-		c.dumpChan = ch
-		c.dumpRequest = request
-		c.dumpResponse = response
-		c.dumpRequestBody = requestBody
-		c.dumpResponseBody = responseBody
 	}
 }
 
-// GenRequestID generates the request ID and sets it to the request header.
+// WithMiddleware appends the middlewares wrapping the transport, the first one is the outermost.
+// The recorder is wrapped by the middlewares, so that the exchanges are recorded as sent.
+func WithMiddleware(middlewares ...Middleware) Option {
+	return func(c *clientConfig) {
+		for _, m := range middlewares {
+			if m != nil {
+				c.middlewares = append(c.middlewares, m)
+			}
+		}
+	}
+}
+
+// WithCircuitBreaker enables a circuit breaker per host, the requests to a host
+// with an open breaker fail fast with a CircuitOpenError and are not retried.
+func WithCircuitBreaker(cfg BreakerConfig) Option {
+	return func(c *clientConfig) {
+		c.breaker = &cfg
+	}
+}
+
+// WithRateLimit limits the requests per host, the requests over the limits wait
+// or fail fast with a RateLimitError, which is not retried.
+func WithRateLimit(cfg RateLimitConfig) Option {
+	return func(c *clientConfig) {
+		c.rateLimit = &cfg
+	}
+}
+
+// GenRequestID generates the request ID and sets it to the request header,
+// the request ID of the request context is used if any.
 func GenRequestID(req *http.Request, key string) string {
 	if key == "" {
 		key = DefaultRequestIDKey
@@ -136,20 +236,21 @@ func GenRequestID(req *http.Request, key string) string {
 	if req.Header == nil {
 		req.Header = http.Header{}
 	}
-	rid := req.Header.Get(key)
+	if rid := req.Header.Get(key); rid != "" {
+		return rid
+	}
+	rid := RequestIDFromContext(req.Context())
 	if rid == "" {
 		rid = utils.UUIDv7().String()
-		req.Header.Set(key, rid)
 	}
+	req.Header.Set(key, rid)
 	return rid
 }
 
 // Add default values method
 func defaultClientConfig() *clientConfig {
 	return &clientConfig{
-		retryMax:     DefaultRetryMax,
-		retryWaitMin: DefaultRetryMinWait,
-		retryWaitMax: DefaultRetryMaxWait,
+		retry:        DefaultRetryPolicy(),
 		requestIDKey: DefaultRequestIDKey,
 		logger:       slog.With("component", "httpclient"),
 	}
@@ -42,9 +42,7 @@ func TestWithRetry(t *testing.T) {
 			retryWaitMin: 2 * time.Second,
 			retryWaitMax: 60 * time.Second,
 			want: &clientConfig{
-				retryMax:     5,
-				retryWaitMin: 2 * time.Second,
-				retryWaitMax: 60 * time.Second,
+				retry: RetryPolicy{Max: 5, WaitMin: 2 * time.Second, WaitMax: 60 * time.Second, Backoff: BackoffExponential},
 			},
 		},
 		{
@@ -53,9 +51,7 @@ func TestWithRetry(t *testing.T) {
 			retryWaitMin: 0,
 			retryWaitMax: 0,
 			want: &clientConfig{
-				retryMax:     DefaultRetryMax,
-				retryWaitMin: DefaultRetryMinWait,
-				retryWaitMax: DefaultRetryMaxWait,
+				retry: DefaultRetryPolicy(),
 			},
 		},
 	}
@@ -65,13 +61,28 @@ func TestWithRetry(t *testing.T) {
 			c := &clientConfig{}
 			opt := WithRetry(tt.retryMax, tt.retryWaitMin, tt.retryWaitMax)
 			opt(c)
-			assert.Equal(t, tt.want.retryMax, c.retryMax)
-			assert.Equal(t, tt.want.retryWaitMin, c.retryWaitMin)
-			assert.Equal(t, tt.want.retryWaitMax, c.retryWaitMax)
+			assert.Equal(t, tt.want.retry, c.retry)
 		})
 	}
 }
 
+func TestWithRetryPolicy(t *testing.T) {
+	c := &clientConfig{}
+	WithRetryPolicy(RetryPolicy{Max: 2, WaitMin: time.Second, WaitMax: time.Second, Backoff: BackoffLinear, Budget: time.Minute})(c)
+	assert.Equal(t, RetryPolicy{Max: 2, WaitMin: time.Second, WaitMax: time.Second, Backoff: BackoffLinear, Budget: time.Minute}, c.retry)
+
+	WithRetryPolicy(RetryPolicy{Max: -1, Backoff: "unknown", Budget: -1})(c)
+	assert.Equal(t, DefaultRetryPolicy(), c.retry)
+}
+
+func TestWithRetryBudget(t *testing.T) {
+	r := &request{}
+	WithRetryBudget(time.Second)(r)
+	assert.Equal(t, time.Second, r.retryBudget)
+	WithRetryBudget(0)(r)
+	assert.Equal(t, time.Second, r.retryBudget)
+}
+
 func TestWithDefaultHeader(t *testing.T) {
 	header := http.Header{
 		"Test-Header": []string{"test-value"},
@@ -106,26 +117,31 @@ func TestWithSkipTLSVerify(t *testing.T) {
 	assert.Equal(t, skip, c.skipTLSVerify)
 }
 
-func TestWithDump(t *testing.T) {
-	ch := make(chan []byte)
-	opt := WithDump(ch, true, true, true, true)
+func TestWithTransport(t *testing.T) {
+	transport := NewReplayTransport(nil)
 	c := &clientConfig{}
-	opt(c)
-	assert.NotNil(t, c.dumpChan)
-	assert.True(t, c.dumpRequest)
-	assert.True(t, c.dumpResponse)
-	assert.True(t, c.dumpRequestBody)
-	assert.True(t, c.dumpResponseBody)
-
-	ch = nil
-	opt = WithDump(ch, true, true, true, true)
-	c = &clientConfig{}
-	opt(c)
-	assert.Nil(t, c.dumpChan)
-	assert.False(t, c.dumpRequest)
-	assert.False(t, c.dumpResponse)
-	assert.False(t, c.dumpRequestBody)
-	assert.False(t, c.dumpResponseBody)
+	WithTransport(transport)(c)
+	assert.Equal(t, transport, c.transport)
+
+	WithTransport(nil)(c)
+	assert.Equal(t, transport, c.transport)
+}
+
+func TestWithRecorder(t *testing.T) {
+	recorder := NewHARRecorder("")
+	c := &clientConfig{}
+	WithRecorder(recorder)(c)
+	assert.Equal(t, recorder, c.recorder)
+
+	WithRecorder(nil)(c)
+	assert.Equal(t, recorder, c.recorder)
+}
+
+func TestWithMiddleware(t *testing.T) {
+	c := &clientConfig{}
+	WithMiddleware(CompressionMiddleware(), nil)(c)
+	WithMiddleware(RequestIDMiddleware(""))(c)
+	assert.Len(t, c.middlewares, 2)
 }
 
 func TestGenRequestID(t *testing.T) {
@@ -147,6 +163,12 @@ func TestGenRequestID(t *testing.T) {
 			key:     "Custom-Request-ID",
 			wantKey: "Custom-Request-ID",
 		},
+		{
+			name:    "context request id",
+			req:     (&http.Request{}).WithContext(ContextWithRequestID(context.Background(), "rid-1")),
+			key:     "",
+			wantKey: DefaultRequestIDKey,
+		},
 	}
 
 	for _, tt := range tests {
@@ -161,9 +183,10 @@ func TestGenRequestID(t *testing.T) {
 func TestDefaultClientConfig(t *testing.T) {
 	cfg := defaultClientConfig()
 
-	assert.Equal(t, DefaultRetryMax, cfg.retryMax)
-	assert.Equal(t, DefaultRetryMinWait, cfg.retryWaitMin)
-	assert.Equal(t, DefaultRetryMaxWait, cfg.retryWaitMax)
+	assert.Equal(t, DefaultRetryMax, cfg.retry.Max)
+	assert.Equal(t, DefaultRetryMinWait, cfg.retry.WaitMin)
+	assert.Equal(t, DefaultRetryMaxWait, cfg.retry.WaitMax)
+	assert.Equal(t, BackoffExponential, cfg.retry.Backoff)
 	assert.Equal(t, DefaultRequestIDKey, cfg.requestIDKey)
 	assert.NotNil(t, cfg.logger)
 }
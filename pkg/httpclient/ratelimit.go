@@ -0,0 +1,271 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is the error of the requests rejected by a rate limiter
+var ErrRateLimited = errors.New("rate limited")
+
+// DefaultRateLimitIdleTTL is the time the limiter of a key is kept after its last request
+const DefaultRateLimitIdleTTL = 10 * time.Minute
+
+// RateLimitError is returned when a request exceeds a rate limit and does not wait,
+// it matches ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	Key string
+	// RetryAfter is the wait before a request is allowed, 0 if it waits for a concurrent request
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s for %s, retry after %s", ErrRateLimited, e.Key, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s for %s, too many concurrent requests", ErrRateLimited, e.Key)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// RateLimitConfig is the config of the rate limiters, a token bucket and a concurrency limit per key.
+// Example: 10 requests per second with bursts of 20 and 4 concurrent requests
+//
+//	requests: 10
+//	per: 1s
+//	burst: 20
+//	concurrency: 4
+type RateLimitConfig struct {
+	// Requests are the requests allowed per period, 0 for no rate limit
+	Requests int `yaml:"requests,omitempty" json:"requests,omitempty"`
+	// Per is the period of the requests, one second by default
+	Per time.Duration `yaml:"per,omitempty" json:"per,omitempty"`
+	// Burst is the size of the token bucket, the requests by default
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+	// Concurrency is the max number of concurrent requests, 0 for no limit
+	Concurrency int `yaml:"concurrency,omitempty" json:"concurrency,omitempty"`
+	// FailFast rejects the requests over the limits with a RateLimitError instead of waiting
+	FailFast bool `yaml:"fail_fast,omitempty" json:"fail_fast,omitempty"`
+}
+
+// Parse checks the rate limit config
+func (c RateLimitConfig) Parse() error {
+	if c.Requests < 0 || c.Per < 0 || c.Burst < 0 || c.Concurrency < 0 {
+		return errors.New("rate limit requests, per, burst and concurrency must not be negative")
+	}
+	if c.Requests == 0 && c.Concurrency == 0 {
+		return errors.New("rate limit requires requests or concurrency")
+	}
+	return nil
+}
+
+func (c RateLimitConfig) withDefaults() RateLimitConfig {
+	if c.Per <= 0 {
+		c.Per = time.Second
+	}
+	if c.Burst <= 0 {
+		c.Burst = c.Requests
+	}
+	return c
+}
+
+type rateLimitFailFastKey struct{}
+
+// ContextWithRateLimitFailFast overrides the FailFast of the rate limiters for the requests of the context
+func ContextWithRateLimitFailFast(ctx context.Context, failFast bool) context.Context {
+	return context.WithValue(ctx, rateLimitFailFastKey{}, failFast)
+}
+
+// RateLimiters are the rate limiters of the keys, e.g. the hosts, the APIs or the tenants.
+// The limiters with a full bucket and no request in flight are evicted after
+// DefaultRateLimitIdleTTL without a request.
+type RateLimiters struct {
+	cfg       RateLimitConfig
+	mu        sync.Mutex
+	limiters  map[string]*limiter
+	lastEvict time.Time
+	now       func() time.Time
+}
+
+// NewRateLimiters creates the rate limiters of the keys
+func NewRateLimiters(cfg RateLimitConfig) *RateLimiters {
+	return &RateLimiters{
+		cfg:      cfg.withDefaults(),
+		limiters: make(map[string]*limiter),
+		now:      time.Now,
+	}
+}
+
+// Acquire waits until a request of the key is allowed, or fails fast with a RateLimitError.
+// The wait is canceled with the context, a request which could not be allowed before
+// the deadline of the context fails without waiting. The release function must be called
+// once the request is done to free its concurrency slot.
+func (r *RateLimiters) Acquire(ctx context.Context, key string) (release func(), err error) {
+	failFast := r.cfg.FailFast
+	if v, ok := ctx.Value(rateLimitFailFastKey{}).(bool); ok {
+		failFast = v
+	}
+	l := r.limiter(key)
+
+	release = func() {}
+	if l.slots != nil {
+		if failFast {
+			select {
+			case l.slots <- struct{}{}:
+			default:
+				return nil, &RateLimitError{Key: key}
+			}
+		} else {
+			select {
+			case l.slots <- struct{}{}:
+			case <-ctx.Done():
+				return nil, context.Cause(ctx)
+			}
+		}
+		var once sync.Once
+		release = func() { once.Do(func() { <-l.slots }) }
+	}
+
+	if r.cfg.Requests > 0 {
+		if err := r.take(ctx, l, key, failFast); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+// take takes a token of the bucket, waiting for it unless it fails fast
+func (r *RateLimiters) take(ctx context.Context, l *limiter, key string, failFast bool) error {
+	interval := r.interval()
+
+	l.mu.Lock()
+	now := r.now()
+	if floor := r.floor(now); l.next.Before(floor) {
+		l.next = floor
+	}
+	wait := l.next.Sub(now)
+	if wait > 0 {
+		deadline, ok := ctx.Deadline()
+		if failFast || ok && deadline.Before(l.next) {
+			l.mu.Unlock()
+			return &RateLimitError{Key: key, RetryAfter: wait}
+		}
+	}
+	l.next = l.next.Add(interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// the token reserved is given back
+		l.mu.Lock()
+		l.next = l.next.Add(-interval)
+		l.mu.Unlock()
+		return context.Cause(ctx)
+	}
+}
+
+// interval is the time between two tokens
+func (r *RateLimiters) interval() time.Duration {
+	return r.cfg.Per / time.Duration(r.cfg.Requests)
+}
+
+// floor is the next of a full bucket, burst intervals before now,
+// every request moves the next of the bucket by one interval
+func (r *RateLimiters) floor(now time.Time) time.Time {
+	return now.Add(-time.Duration(r.cfg.Burst-1) * r.interval())
+}
+
+// Middleware returns the middleware limiting the requests per host.
+// The concurrency slot of a request is released when its response body is closed.
+func (r *RateLimiters) Middleware() Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			release, err := r.Acquire(req.Context(), req.URL.Host)
+			if err != nil {
+				closeBody(req)
+				return nil, err
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				release()
+				return nil, err
+			}
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		})
+	}
+}
+
+func (r *RateLimiters) limiter(key string) *limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	r.evict(now)
+	l, ok := r.limiters[key]
+	if !ok {
+		l = &limiter{}
+		if r.cfg.Concurrency > 0 {
+			l.slots = make(chan struct{}, r.cfg.Concurrency)
+		}
+		r.limiters[key] = l
+	}
+	l.usedAt = now
+	return l
+}
+
+// evict removes the limiters idle for the TTL, at most once per TTL.
+// The ones with requests in flight or tokens taken are kept, the limits would be reset otherwise.
+func (r *RateLimiters) evict(now time.Time) {
+	if now.Sub(r.lastEvict) < DefaultRateLimitIdleTTL {
+		return
+	}
+	r.lastEvict = now
+	for key, l := range r.limiters {
+		if now.Sub(l.usedAt) < DefaultRateLimitIdleTTL || len(l.slots) > 0 {
+			continue
+		}
+		l.mu.Lock()
+		full := r.cfg.Requests == 0 || !l.next.After(r.floor(now))
+		l.mu.Unlock()
+		if full {
+			delete(r.limiters, key)
+		}
+	}
+}
+
+// limiter is the token bucket and the concurrency slots of a key
+type limiter struct {
+	mu sync.Mutex
+	// next is the time the next token is available
+	next  time.Time
+	slots chan struct{}
+	// usedAt is the time of the last request
+	usedAt time.Time
+}
+
+// releaseBody releases the concurrency slot of the request when the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,135 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitConfigParse(t *testing.T) {
+	assert.NoError(t, RateLimitConfig{Requests: 10}.Parse())
+	assert.NoError(t, RateLimitConfig{Concurrency: 1}.Parse())
+	assert.Error(t, RateLimitConfig{}.Parse())
+	assert.Error(t, RateLimitConfig{Requests: -1}.Parse())
+}
+
+func TestRateLimitersTokenBucket(t *testing.T) {
+	now := time.Now()
+	r := NewRateLimiters(RateLimitConfig{Requests: 2, Per: time.Second, Burst: 2, FailFast: true})
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// the burst is allowed at once, the next request waits for a token
+	for range 2 {
+		_, err := r.Acquire(ctx, "a")
+		require.NoError(t, err)
+	}
+	_, err := r.Acquire(ctx, "a")
+	var limitErr *RateLimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, "a", limitErr.Key)
+	assert.Equal(t, 500*time.Millisecond, limitErr.RetryAfter)
+
+	// the keys have their own buckets
+	_, err = r.Acquire(ctx, "b")
+	assert.NoError(t, err)
+
+	now = now.Add(500 * time.Millisecond)
+	_, err = r.Acquire(ctx, "a")
+	assert.NoError(t, err)
+	_, err = r.Acquire(ctx, "a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestRateLimitersWait(t *testing.T) {
+	r := NewRateLimiters(RateLimitConfig{Requests: 1, Per: 50 * time.Millisecond})
+	ctx := context.Background()
+
+	start := time.Now()
+	for range 3 {
+		_, err := r.Acquire(ctx, "a")
+		require.NoError(t, err)
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	// a request which can not be allowed before the deadline fails without waiting
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := r.Acquire(ctx, "a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+
+	// the context overrides the fail fast of the config
+	_, err = r.Acquire(ContextWithRateLimitFailFast(context.Background(), true), "a")
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestRateLimitersConcurrency(t *testing.T) {
+	r := NewRateLimiters(RateLimitConfig{Concurrency: 1})
+	release, err := r.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	_, err = r.Acquire(ContextWithRateLimitFailFast(context.Background(), true), "a")
+	var limitErr *RateLimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Zero(t, limitErr.RetryAfter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = r.Acquire(ctx, "a")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release()
+	next, err := r.Acquire(context.Background(), "a")
+	require.NoError(t, err)
+	next()
+}
+
+func TestRateLimitersEvict(t *testing.T) {
+	now := time.Now()
+	r := NewRateLimiters(RateLimitConfig{Requests: 1, Per: time.Minute, Concurrency: 1})
+	r.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	release, err := r.Acquire(ctx, "idle")
+	require.NoError(t, err)
+	release()
+	_, err = r.Acquire(ctx, "busy")
+	require.NoError(t, err)
+	assert.Len(t, r.limiters, 2)
+
+	// the idle limiters are evicted, the ones with requests in flight are kept
+	now = now.Add(DefaultRateLimitIdleTTL)
+	_, err = r.Acquire(ctx, "other")
+	require.NoError(t, err)
+	assert.Len(t, r.limiters, 2)
+	assert.NotContains(t, r.limiters, "idle")
+	assert.Contains(t, r.limiters, "busy")
+}
+
+func TestClientRateLimit(t *testing.T) {
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	c := New(WithRetry(3, time.Millisecond, time.Millisecond),
+		WithRateLimit(RateLimitConfig{Requests: 1, Per: time.Minute, FailFast: true}))
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// the rejected requests are not retried
+	_, err = c.Get(ts.URL)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,159 @@
+package httpclient
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// RecordMaxRequestBodySize is the max size of the recorded request bodies, the larger bodies
+	// are truncated in the record and streamed to the server as is.
+	RecordMaxRequestBodySize = 1 << 20
+	// RecordMaxResponseBodySize is the max size of the recorded response bodies, the larger bodies
+	// are truncated in the record and read by the caller as is.
+	RecordMaxResponseBodySize = 10 << 20
+)
+
+// Exchange is a recorded request and response, the secret values of the request
+// context are masked in the URL, headers and bodies.
+type Exchange struct {
+	StartedAt time.Time
+	// Wait is the time waiting for the response headers
+	Wait time.Duration
+	// Duration is the total time, including reading the response body
+	Duration time.Duration
+
+	Method        string
+	URL           string
+	Proto         string
+	RequestHeader http.Header
+	RequestBody   []byte
+	// RequestBodyTruncated reports whether the request body is larger than RecordMaxRequestBodySize
+	RequestBodyTruncated bool
+	Status               int
+	StatusText           string
+	ResponseProto        string
+	ResponseHeader       http.Header
+	ResponseBody         []byte
+	// ResponseBodyTruncated reports whether the response body is larger than RecordMaxResponseBodySize
+	ResponseBodyTruncated bool
+}
+
+// Recorder records the exchanges of the client, every attempt of a retried request is recorded.
+// The response is recorded when its body is read to the end or closed.
+type Recorder interface {
+	Record(exchange *Exchange) error
+}
+
+// RecorderFunc is a function recorder
+type RecorderFunc func(exchange *Exchange) error
+
+// Record calls f(exchange)
+func (f RecorderFunc) Record(exchange *Exchange) error {
+	return f(exchange)
+}
+
+// recordTransport records the exchanges of the next transport
+type recordTransport struct {
+	next     http.RoundTripper
+	recorder Recorder
+	logger   *slog.Logger
+}
+
+func (t *recordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		reqBody   []byte
+		truncated bool
+	)
+	if req.Body != nil && req.Body != http.NoBody {
+		body := req.Body
+		data, err := io.ReadAll(io.LimitReader(body, RecordMaxRequestBodySize+1))
+		if err != nil {
+			_ = body.Close()
+			return nil, err
+		}
+		// the rest of the body is streamed after the recorded part
+		req = req.Clone(req.Context())
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(data), body), body}
+		if len(data) > RecordMaxRequestBodySize {
+			data, truncated = data[:RecordMaxRequestBodySize], true
+		}
+		reqBody = data
+	}
+
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := req.Context()
+	exchange := &Exchange{
+		StartedAt:            started,
+		Wait:                 time.Since(started),
+		Method:               req.Method,
+		URL:                  MaskSecrets(ctx, req.URL.String()),
+		Proto:                req.Proto,
+		RequestHeader:        maskHeader(req, req.Header),
+		RequestBody:          []byte(MaskSecrets(ctx, string(reqBody))),
+		RequestBodyTruncated: truncated,
+		Status:               resp.StatusCode,
+		StatusText:           http.StatusText(resp.StatusCode),
+		ResponseProto:        resp.Proto,
+		ResponseHeader:       maskHeader(req, resp.Header),
+	}
+	resp.Body = &recordBody{ReadCloser: resp.Body, record: func(body []byte, truncated bool) {
+		exchange.Duration = time.Since(started)
+		exchange.ResponseBody = []byte(MaskSecrets(ctx, string(body)))
+		exchange.ResponseBodyTruncated = truncated
+		if err := t.recorder.Record(exchange); err != nil {
+			t.logger.Warn("failed to record exchange", "url", exchange.URL, "method", exchange.Method, "error", err)
+		}
+	}}
+	return resp, nil
+}
+
+func maskHeader(req *http.Request, header http.Header) http.Header {
+	masked := make(http.Header, len(header))
+	for k, v := range header {
+		for _, vv := range v {
+			masked.Add(k, MaskSecrets(req.Context(), vv))
+		}
+	}
+	return masked
+}
+
+// recordBody keeps the read body up to RecordMaxResponseBodySize and records it once on EOF or close
+type recordBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	truncated bool
+	once      sync.Once
+	record    func(body []byte, truncated bool)
+}
+
+func (b *recordBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	room := RecordMaxResponseBodySize - b.buf.Len()
+	if n > room {
+		b.truncated = true
+	}
+	b.buf.Write(p[:min(n, room)])
+	if err == io.EOF {
+		b.once.Do(func() { b.record(b.buf.Bytes(), b.truncated) })
+	}
+	return n, err
+}
+
+func (b *recordBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.record(b.buf.Bytes(), b.truncated) })
+	return err
+}
@@ -0,0 +1,201 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// IdempotencyKeyHeader is the header making a non idempotent request retryable
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// Backoff is the strategy of the wait between the retries
+type Backoff string
+
+const (
+	// BackoffExponential doubles the wait on every retry, with a random jitter of up to half the wait
+	BackoffExponential Backoff = "exponential"
+	// BackoffLinear increases the wait by the min wait on every retry
+	BackoffLinear Backoff = "linear"
+	// BackoffConstant waits the min wait between the retries
+	BackoffConstant Backoff = "constant"
+)
+
+// RetryPolicy is the retry policy of the client.
+// The Retry-After header of the 429 and 503 responses is honored, up to the max wait.
+// Only the idempotent requests, or the ones with an Idempotency-Key header, are retried.
+type RetryPolicy struct {
+	Max     int           `yaml:"max" json:"max"`
+	WaitMin time.Duration `yaml:"wait_min" json:"wait_min"`
+	WaitMax time.Duration `yaml:"wait_max" json:"wait_max"`
+	Backoff Backoff       `yaml:"backoff" json:"backoff"`
+	// Budget limits the total time of a request including its retries, 0 for no limit.
+	// A retry is not attempted when its wait would exceed the budget.
+	Budget time.Duration `yaml:"budget,omitempty" json:"budget,omitempty"`
+	// RetryNonIdempotent retries the non idempotent requests without an Idempotency-Key header,
+	// it can apply the request twice.
+	RetryNonIdempotent bool `yaml:"retry_non_idempotent,omitempty" json:"retry_non_idempotent,omitempty"`
+}
+
+// DefaultRetryPolicy returns the default retry policy
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		Max:     DefaultRetryMax,
+		WaitMin: DefaultRetryMinWait,
+		WaitMax: DefaultRetryMaxWait,
+		Backoff: BackoffExponential,
+	}
+}
+
+// withDefaults replaces the invalid values with the defaults
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Max < 0 {
+		p.Max = DefaultRetryMax
+	}
+	if p.WaitMin <= 0 {
+		p.WaitMin = DefaultRetryMinWait
+	}
+	if p.WaitMax <= 0 || p.WaitMax < p.WaitMin {
+		p.WaitMax = DefaultRetryMaxWait
+	}
+	switch p.Backoff {
+	case BackoffExponential, BackoffLinear, BackoffConstant:
+	default:
+		p.Backoff = BackoffExponential
+	}
+	if p.Budget < 0 {
+		p.Budget = 0
+	}
+	return p
+}
+
+// Wait returns the wait before the retry, the attempt starts from 0 for the first retry
+func (p RetryPolicy) Wait(attempt int, resp *http.Response) time.Duration {
+	wait := p.maxWait(attempt, resp)
+	if p.Backoff == BackoffExponential && !hasRetryAfter(resp) && wait > 1 {
+		wait = wait/2 + rand.N(wait/2) //nolint:gosec
+	}
+	return wait
+}
+
+// maxWait returns the wait before the retry without the jitter
+func (p RetryPolicy) maxWait(attempt int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		return min(wait, p.WaitMax)
+	}
+	var wait float64
+	switch p.Backoff {
+	case BackoffConstant:
+		wait = float64(p.WaitMin)
+	case BackoffLinear:
+		wait = float64(p.WaitMin) * float64(attempt+1)
+	default:
+		wait = float64(p.WaitMin) * math.Pow(2, float64(attempt))
+	}
+	if wait > float64(p.WaitMax) {
+		return p.WaitMax
+	}
+	return time.Duration(wait)
+}
+
+func hasRetryAfter(resp *http.Response) bool {
+	_, ok := retryAfter(resp)
+	return ok
+}
+
+// retryAfter parses the Retry-After header of the 429 and 503 responses,
+// in seconds or as a HTTP date.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// IsIdempotent reports whether the request can be retried without applying it twice
+func IsIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get(IdempotencyKeyHeader) != ""
+}
+
+type retryStateKey struct{}
+
+// retryState is the retry state of a request, carried by the request context
+type retryState struct {
+	started   time.Time
+	budget    time.Duration
+	retryable bool
+	retries   int
+	// status is the status of the last response, 0 if the last attempt failed without a response
+	status int
+}
+
+// StatusError is returned when a request gives up on a retryable response, the response is closed
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string { return e.Err.Error() }
+
+func (e *StatusError) Unwrap() error { return e.Err }
+
+// checkRetry checks whether the request is retried with the policy of the client
+func (c *client) checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	state, ok := ctx.Value(retryStateKey{}).(*retryState)
+	if ok {
+		state.status = 0
+		if resp != nil {
+			state.status = resp.StatusCode
+		}
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited) {
+		return false, err
+	}
+	retry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if !retry || checkErr != nil {
+		return retry, checkErr
+	}
+	if !ok {
+		return true, nil
+	}
+	if !state.retryable {
+		return false, nil
+	}
+	if state.budget > 0 && time.Since(state.started)+c.cfg.retry.maxWait(state.retries, resp) > state.budget {
+		return false, nil
+	}
+	state.retries++
+	return true, nil
+}
+
+// backoff returns the wait before the retry with the policy of the client
+func (c *client) backoff(_, _ time.Duration, attempt int, resp *http.Response) time.Duration {
+	return c.cfg.retry.Wait(attempt, resp)
+}
@@ -0,0 +1,118 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyWait(t *testing.T) {
+	policy := RetryPolicy{WaitMin: time.Second, WaitMax: 5 * time.Second}
+
+	policy.Backoff = BackoffConstant
+	assert.Equal(t, time.Second, policy.Wait(3, nil))
+
+	policy.Backoff = BackoffLinear
+	assert.Equal(t, 3*time.Second, policy.Wait(2, nil))
+	assert.Equal(t, 5*time.Second, policy.Wait(10, nil))
+
+	policy.Backoff = BackoffExponential
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		wait := policy.Wait(attempt, nil)
+		assert.LessOrEqual(t, wait, want)
+		assert.GreaterOrEqual(t, wait, want/2)
+	}
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"2"}}}
+	assert.Equal(t, 2*time.Second, policy.Wait(0, resp))
+	resp.Header.Set("Retry-After", "120")
+	assert.Equal(t, 5*time.Second, policy.Wait(0, resp))
+	resp.Header.Set("Retry-After", time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat))
+	assert.Equal(t, time.Duration(0), policy.Wait(0, resp))
+
+	// the Retry-After header is only honored on 429 and 503
+	policy.Backoff = BackoffConstant
+	resp = &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{"Retry-After": []string{"2"}}}
+	assert.Equal(t, time.Second, policy.Wait(0, resp))
+}
+
+func TestIsIdempotent(t *testing.T) {
+	for method, want := range map[string]bool{
+		http.MethodGet:    true,
+		http.MethodPut:    true,
+		http.MethodDelete: true,
+		http.MethodPost:   false,
+		http.MethodPatch:  false,
+	} {
+		req, _ := http.NewRequest(method, "http://example.com", nil)
+		assert.Equal(t, want, IsIdempotent(req), method)
+	}
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	req.Header.Set(IdempotencyKeyHeader, "key-1")
+	assert.True(t, IsIdempotent(req))
+}
+
+func TestClientRetryPolicy(t *testing.T) {
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.RawQuery == "" {
+			w.Header().Set("Retry-After", "0")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	c := New(WithRetryPolicy(RetryPolicy{Max: 2, WaitMin: time.Millisecond, WaitMax: time.Millisecond}))
+
+	// the idempotent requests are retried
+	_, err := c.Get(ts.URL)
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+
+	// the non idempotent requests are not retried
+	calls = 0
+	resp, err := c.Post(ts.URL, []byte("order"))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	_ = resp.Body.Close()
+	assert.Equal(t, 1, calls)
+
+	// unless they have an idempotency key
+	calls = 0
+	_, err = c.Post(ts.URL, []byte("order"), WithHeader(http.Header{IdempotencyKeyHeader: []string{"key-1"}}))
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+
+	// the retries stop when the wait exceeds the budget
+	calls = 0
+	slow := New(WithRetryPolicy(RetryPolicy{Max: 5, WaitMin: time.Second, WaitMax: time.Second, Backoff: BackoffConstant}))
+	started := time.Now()
+	resp, err = slow.Get(ts.URL+"?slow", WithRetryBudget(500*time.Millisecond))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, 1, calls)
+	assert.Less(t, time.Since(started), 500*time.Millisecond)
+}
+
+func TestClientStatusError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	_, err := New(WithRetry(1, time.Millisecond, time.Millisecond)).Get(ts.URL)
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.StatusCode)
+	assert.Contains(t, err.Error(), "giving up after 2 attempt(s)")
+
+	_, err = New(WithRetry(0, time.Millisecond, time.Millisecond)).Get("http://127.0.0.1:1")
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &statusErr))
+}
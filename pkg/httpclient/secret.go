@@ -0,0 +1,78 @@
+package httpclient
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// SecretMask is the replacement of the secret values in logs and dumps
+const SecretMask = "******"
+
+type secretsKey struct{}
+
+// ContextWithSecrets returns a context carrying the secret values of the request,
+// they are masked in the client logs and dumps.
+func ContextWithSecrets(ctx context.Context, secrets ...string) context.Context {
+	if len(secrets) == 0 {
+		return ctx
+	}
+	all := append(SecretsFromContext(ctx), secrets...)
+	all = slices.DeleteFunc(all, func(s string) bool { return s == "" })
+	// mask the longest secret first, in case a secret contains another one
+	slices.SortFunc(all, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+	all = slices.Compact(all)
+	return context.WithValue(ctx, secretsKey{}, all)
+}
+
+// SecretsFromContext returns the secret values carried by the context
+func SecretsFromContext(ctx context.Context) []string {
+	if ctx == nil {
+		return nil
+	}
+	secrets, _ := ctx.Value(secretsKey{}).([]string)
+	return slices.Clone(secrets)
+}
+
+// MaskSecrets replaces the secret values carried by the context in s,
+// including their URL encoded forms.
+func MaskSecrets(ctx context.Context, s string) string {
+	for _, secret := range SecretsFromContext(ctx) {
+		s = strings.ReplaceAll(s, secret, SecretMask)
+		if escaped := url.QueryEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, SecretMask)
+		}
+		if escaped := url.PathEscape(secret); escaped != secret {
+			s = strings.ReplaceAll(s, escaped, SecretMask)
+		}
+	}
+	return s
+}
+
+// maskedError is an error whose message has the secret values masked
+type maskedError struct {
+	msg string
+	err error
+}
+
+func (e *maskedError) Error() string { return e.msg }
+
+func (e *maskedError) Unwrap() error { return e.err }
+
+// MaskError masks the secret values carried by the context in the error message
+func MaskError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := MaskSecrets(ctx, err.Error())
+	if msg == err.Error() {
+		return err
+	}
+	return &maskedError{msg: msg, err: err}
+}
@@ -0,0 +1,53 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextWithSecrets(t *testing.T) {
+	ctx := ContextWithSecrets(context.Background())
+	assert.Empty(t, SecretsFromContext(ctx))
+
+	ctx = ContextWithSecrets(ctx, "abc", "", "abcdef")
+	ctx = ContextWithSecrets(ctx, "abc", "xyz")
+	assert.Equal(t, []string{"abcdef", "abc", "xyz"}, SecretsFromContext(ctx))
+	assert.Nil(t, SecretsFromContext(nil)) //nolint:staticcheck
+}
+
+func TestMaskSecrets(t *testing.T) {
+	ctx := ContextWithSecrets(context.Background(), "p@ss word", "abcdef")
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{name: "plain", s: "token=abcdef", want: "token=******"},
+		{name: "query escaped", s: "/login?pass=p%40ss+word", want: "/login?pass=******"},
+		{name: "path escaped", s: "/users/p@ss%20word", want: "/users/******"},
+		{name: "no secret", s: "hello", want: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, MaskSecrets(ctx, tt.s))
+		})
+	}
+	assert.Equal(t, "abcdef", MaskSecrets(context.Background(), "abcdef"))
+}
+
+func TestMaskError(t *testing.T) {
+	ctx := ContextWithSecrets(context.Background(), "abcdef")
+	assert.NoError(t, MaskError(ctx, nil))
+
+	base := errors.New("get https://example.com/?token=abcdef: timeout")
+	err := MaskError(ctx, base)
+	assert.EqualError(t, err, "get https://example.com/?token=******: timeout")
+	assert.ErrorIs(t, err, base)
+
+	other := errors.New("timeout")
+	assert.Equal(t, other, MaskError(ctx, other))
+}
@@ -0,0 +1,203 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxStreamLineSize is the max size of a line of the server-sent events and NDJSON streams
+const MaxStreamLineSize = 1 << 20
+
+// ErrIdleTimeout is the cause of the cancellation of a request without data for the idle timeout
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// StreamFormat is the format of a streamed response body
+type StreamFormat string
+
+const (
+	// StreamFormatSSE is the server-sent events format, text/event-stream
+	StreamFormatSSE StreamFormat = "sse"
+	// StreamFormatNDJSON is the newline delimited JSON format
+	StreamFormatNDJSON StreamFormat = "ndjson"
+	// StreamFormatRaw is any other format, streamed as the chunks arrive
+	StreamFormatRaw StreamFormat = "raw"
+)
+
+// DetectStreamFormat returns the stream format of the response content type
+func DetectStreamFormat(resp *http.Response) StreamFormat {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/event-stream":
+		return StreamFormatSSE
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines",
+		"application/stream+json":
+		return StreamFormatNDJSON
+	default:
+		return StreamFormatRaw
+	}
+}
+
+// Event is a server-sent event
+type Event struct {
+	// ID is the last event ID of the stream
+	ID string `json:"id,omitempty"`
+	// Event is the event type, message by default
+	Event string `json:"event"`
+	// Data are the data lines of the event joined by a newline
+	Data string `json:"data"`
+	// Retry is the reconnection time sent by the server
+	Retry time.Duration `json:"retry,omitempty"`
+}
+
+// ReadEvents reads the server-sent events as they arrive and calls fn on each event.
+// It returns nil at the end of the stream, or the error of the reader or fn.
+func ReadEvents(r io.Reader, fn func(event Event) error) error {
+	var (
+		lastID string
+		event  Event
+		data   []string
+	)
+	return scanLines(r, func(line []byte) error {
+		if len(line) == 0 {
+			// a blank line dispatches the event, an event without data is dropped
+			if len(data) > 0 {
+				event.ID = lastID
+				if event.Event == "" {
+					event.Event = "message"
+				}
+				event.Data = strings.Join(data, "\n")
+				if err := fn(event); err != nil {
+					return err
+				}
+			}
+			event, data = Event{}, data[:0]
+			return nil
+		}
+		if line[0] == ':' {
+			return nil
+		}
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "event":
+			event.Event = string(value)
+		case "data":
+			data = append(data, string(value))
+		case "id":
+			if !bytes.ContainsRune(value, 0) {
+				lastID = string(value)
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(string(value)); err == nil && ms >= 0 {
+				event.Retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+		return nil
+	})
+}
+
+// ReadLines reads the lines of a NDJSON stream as they arrive and calls fn on each non-empty line.
+// It returns nil at the end of the stream, or the error of the reader or fn.
+func ReadLines(r io.Reader, fn func(line []byte) error) error {
+	return scanLines(r, func(line []byte) error {
+		if len(bytes.TrimSpace(line)) == 0 {
+			return nil
+		}
+		return fn(line)
+	})
+}
+
+// scanLines calls fn on each line without its line ending, the line is only valid during the call
+func scanLines(r io.Reader, fn func(line []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), MaxStreamLineSize)
+	for scanner.Scan() {
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// IdleTimeout cancels its context when it is not reset for the timeout,
+// it replaces the overall timeout of the streamed responses
+type IdleTimeout struct {
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// NewIdleTimeout starts the idle timeout, the parent context cancellation is kept
+func NewIdleTimeout(parent context.Context, timeout time.Duration) *IdleTimeout {
+	ctx, cancel := context.WithCancelCause(parent)
+	t := &IdleTimeout{timeout: timeout, ctx: ctx, cancel: cancel}
+	t.timer = time.AfterFunc(timeout, func() {
+		cancel(fmt.Errorf("%w: no data for %s", ErrIdleTimeout, timeout))
+	})
+	return t
+}
+
+// Context returns the context canceled by the idle timeout
+func (t *IdleTimeout) Context() context.Context {
+	return t.ctx
+}
+
+// Reset restarts the idle timeout
+func (t *IdleTimeout) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ctx.Err() == nil {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+// Stop stops the idle timeout and cancels its context
+func (t *IdleTimeout) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer.Stop()
+	t.cancel(context.Canceled)
+}
+
+// Body returns the body resetting the idle timeout on each read of data,
+// closing it stops the idle timeout
+func (t *IdleTimeout) Body(body io.ReadCloser) io.ReadCloser {
+	return &idleBody{ReadCloser: body, idle: t}
+}
+
+type idleBody struct {
+	io.ReadCloser
+	idle *IdleTimeout
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.idle.Reset()
+	}
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.idle.ctx); errors.Is(cause, ErrIdleTimeout) {
+			err = cause
+		}
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.idle.Stop()
+	return err
+}
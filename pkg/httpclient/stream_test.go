@@ -0,0 +1,110 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadEvents(t *testing.T) {
+	stream := ": comment\r\n" +
+		"retry: 1500\n" +
+		"data: first\n\n" +
+		"event: update\nid: 7\ndata: line 1\ndata:line 2\n\n" +
+		"id\n\n" +
+		"data: {\"a\":1}\n\n" +
+		"data: not dispatched"
+	var events []Event
+	err := ReadEvents(strings.NewReader(stream), func(e Event) error {
+		events = append(events, e)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Event{
+		{Event: "message", Data: "first", Retry: 1500 * time.Millisecond},
+		{ID: "7", Event: "update", Data: "line 1\nline 2"},
+		{Event: "message", Data: `{"a":1}`},
+	}, events)
+
+	stop := errors.New("stop")
+	err = ReadEvents(strings.NewReader(stream), func(Event) error { return stop })
+	assert.ErrorIs(t, err, stop)
+}
+
+func TestReadLines(t *testing.T) {
+	var lines []string
+	err := ReadLines(strings.NewReader("{\"a\":1}\n\n  \n{\"b\":2}\r\n{\"c\":3}"), func(line []byte) error {
+		lines = append(lines, string(line))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"a":1}`, `{"b":2}`, `{"c":3}`}, lines)
+}
+
+func TestDetectStreamFormat(t *testing.T) {
+	for contentType, want := range map[string]StreamFormat{
+		"text/event-stream":            StreamFormatSSE,
+		"text/event-stream; charset=x": StreamFormatSSE,
+		"application/x-ndjson":         StreamFormatNDJSON,
+		"application/jsonl":            StreamFormatNDJSON,
+		"application/json":             StreamFormatRaw,
+		"":                             StreamFormatRaw,
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": {contentType}}}
+		assert.Equal(t, want, DetectStreamFormat(resp), contentType)
+	}
+}
+
+// newTestStreamServer sends the events with the delay before each one
+func newTestStreamServer(delay time.Duration, events int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for range events {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			_, _ = io.WriteString(w, "data: tick\n\n")
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
+func TestIdleTimeout(t *testing.T) {
+	ts := newTestStreamServer(20*time.Millisecond, 5)
+	defer ts.Close()
+
+	// the stream lasts longer than the idle timeout, but sends data more often
+	idle := NewIdleTimeout(context.Background(), 200*time.Millisecond)
+	req, _ := http.NewRequestWithContext(idle.Context(), http.MethodGet, ts.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body := idle.Body(resp.Body)
+	count := 0
+	require.NoError(t, ReadEvents(body, func(Event) error { count++; return nil }))
+	assert.Equal(t, 5, count)
+	require.NoError(t, body.Close())
+	assert.Error(t, idle.Context().Err(), "closing the body stops the idle timeout")
+
+	slow := newTestStreamServer(300*time.Millisecond, 2)
+	defer slow.Close()
+	idle = NewIdleTimeout(context.Background(), 50*time.Millisecond)
+	req, _ = http.NewRequestWithContext(idle.Context(), http.MethodGet, slow.URL, nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body = idle.Body(resp.Body)
+	defer body.Close() //nolint:errcheck
+	err = ReadEvents(body, func(Event) error { return nil })
+	assert.ErrorIs(t, err, ErrIdleTimeout)
+}
@@ -0,0 +1,116 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SPKIPinPrefix is the optional prefix of the SPKI pins, as in the curl --pinnedpubkey option
+const SPKIPinPrefix = "sha256//"
+
+// ErrCertificatePinning is returned when no certificate of the server matches the pinned SPKI
+var ErrCertificatePinning = errors.New("no certificate matches the pinned public keys")
+
+// LoadCertPool loads the PEM certificates of the files and directories into a pool,
+// the directories are not walked recursively.
+func LoadCertPool(paths ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		files := []string{path}
+		if info.IsDir() {
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return nil, err
+			}
+			files = files[:0]
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			if !pool.AppendCertsFromPEM(data) && !info.IsDir() {
+				return nil, fmt.Errorf("no PEM certificate in %s", file)
+			}
+		}
+	}
+	return pool, nil
+}
+
+// ParseTLSVersion parses the TLS version, e.g. 1.2 or TLS1.3
+func ParseTLSVersion(version string) (uint16, error) {
+	v := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(version)), "TLS")
+	switch strings.TrimPrefix(v, "V") {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version: %s", version)
+	}
+}
+
+// SPKIPin returns the pin of the certificate public key, the base64 SHA-256 of its SubjectPublicKeyInfo
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// verifyPins returns the connection verifier accepting the servers with a certificate of the chain matching a pin
+func verifyPins(pins []string) func(cs tls.ConnectionState) error {
+	allowed := make(map[string]struct{}, len(pins))
+	for _, pin := range pins {
+		allowed[strings.TrimPrefix(strings.TrimSpace(pin), SPKIPinPrefix)] = struct{}{}
+	}
+	return func(cs tls.ConnectionState) error {
+		for _, cert := range cs.PeerCertificates {
+			if _, ok := allowed[SPKIPin(cert)]; ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s", ErrCertificatePinning, cs.ServerName)
+	}
+}
+
+// tlsConfig builds the TLS config of the client, nil for the default one
+func (c *clientConfig) tlsConfig() *tls.Config {
+	if !c.skipTLSVerify && len(c.certificates) == 0 && c.rootCAs == nil &&
+		c.serverName == "" && c.minTLSVersion == 0 && len(c.pinnedSPKI) == 0 {
+		return nil
+	}
+	cfg := &tls.Config{
+		InsecureSkipVerify: c.skipTLSVerify, //nolint:gosec
+		Certificates:       c.certificates,
+		RootCAs:            c.rootCAs,
+		ServerName:         c.serverName,
+		MinVersion:         c.minTLSVersion,
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	if len(c.pinnedSPKI) > 0 {
+		// the pins are checked even if the verification of the chain is skipped
+		cfg.VerifyConnection = verifyPins(c.pinnedSPKI)
+	}
+	return cfg
+}
@@ -0,0 +1,136 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertificate creates a self-signed client certificate
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, cert
+}
+
+func writeCertificate(t *testing.T, path string, cert *x509.Certificate) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestTLSOptions(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	writeCertificate(t, filepath.Join(dir, "server.pem"), ts.Certificate())
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0o600))
+	pool, err := LoadCertPool(dir)
+	require.NoError(t, err)
+
+	noRetry := WithRetry(0, time.Millisecond, time.Millisecond)
+
+	_, err = New(noRetry).Get(ts.URL)
+	assert.Error(t, err, "the server is not trusted by the system CAs")
+
+	resp, err := New(noRetry, WithRootCAs(pool)).Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// the test certificate is issued for example.com
+	resp, err = New(noRetry, WithRootCAs(pool), WithServerName("example.com")).Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = New(noRetry, WithRootCAs(pool), WithServerName("other.com")).Get(ts.URL)
+	assert.Error(t, err)
+
+	resp, err = New(noRetry, WithRootCAs(pool), WithPinnedSPKI(SPKIPinPrefix+SPKIPin(ts.Certificate()))).Get(ts.URL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = New(noRetry, WithSkipTLSVerify(true), WithPinnedSPKI("AAAA")).Get(ts.URL)
+	assert.ErrorIs(t, err, ErrCertificatePinning)
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	clientCert, clientLeaf := newTestCertificate(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientLeaf)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs, MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	defer ts.Close()
+
+	noRetry := WithRetry(0, time.Millisecond, time.Millisecond)
+	_, err := New(noRetry, WithSkipTLSVerify(true)).Get(ts.URL)
+	assert.Error(t, err, "the client certificate is required")
+
+	resp, err := New(noRetry, WithSkipTLSVerify(true), WithClientCertificates(clientCert)).Get(ts.URL)
+	require.NoError(t, err)
+	_, body, err := ParseResponse(resp)
+	require.NoError(t, err)
+	assert.Equal(t, "client", string(body))
+
+	_, err = New(noRetry, WithSkipTLSVerify(true), WithClientCertificates(clientCert), WithMinTLSVersion(tls.VersionTLS13)).Get(ts.URL)
+	assert.Error(t, err, "the server does not support TLS 1.3")
+}
+
+func TestLoadCertPoolErrors(t *testing.T) {
+	_, err := LoadCertPool(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+
+	file := filepath.Join(t.TempDir(), "invalid.pem")
+	require.NoError(t, os.WriteFile(file, []byte("invalid"), 0o600))
+	_, err = LoadCertPool(file)
+	assert.Error(t, err)
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for version, want := range map[string]uint16{
+		"1.0":     tls.VersionTLS10,
+		"1.1":     tls.VersionTLS11,
+		"TLS1.2":  tls.VersionTLS12,
+		"tlsv1.3": tls.VersionTLS13,
+	} {
+		got, err := ParseTLSVersion(version)
+		require.NoError(t, err, version)
+		assert.Equal(t, want, got, version)
+	}
+	_, err := ParseTLSVersion("2.0")
+	assert.Error(t, err)
+}
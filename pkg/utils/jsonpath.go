@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPathNotFound is the error returned when the JSON path does not exist.
+var ErrPathNotFound = errors.New("path not found")
+
+// JSONPath returns the value at the given path of the decoded JSON data.
+// It supports a subset of JSONPath, the leading `$` is optional:
+//
+//	$.data.items[0].name
+//	data.items[-1]['display name']
+func JSONPath(data any, path string) (any, error) {
+	keys, err := splitJSONPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cur := data
+	for _, key := range keys {
+		switch v := cur.(type) {
+		case map[string]any:
+			val, ok := v[key]
+			if !ok {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+			}
+			cur = val
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+			}
+			if idx < 0 {
+				idx += len(v)
+			}
+			if idx < 0 || idx >= len(v) {
+				return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+			}
+			cur = v[idx]
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+		}
+	}
+	return cur, nil
+}
+
+// splitJSONPath splits the path into keys
+func splitJSONPath(path string) ([]string, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	keys := []string{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+			j := i
+			for j < len(path) && path[j] != '.' && path[j] != '[' {
+				j++
+			}
+			if j == i {
+				return nil, fmt.Errorf("invalid json path %q: empty key", path)
+			}
+			keys = append(keys, path[i:j])
+			i = j
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid json path %q: missing ]", path)
+			}
+			key := strings.TrimSpace(path[i+1 : i+end])
+			key = strings.Trim(key, `'"`)
+			if key == "" {
+				return nil, fmt.Errorf("invalid json path %q: empty index", path)
+			}
+			keys = append(keys, key)
+			i += end + 1
+		default:
+			if i != 0 {
+				return nil, fmt.Errorf("invalid json path %q at %d", path, i)
+			}
+			// path without leading `$.`, e.g. data.items
+			path = "." + path
+		}
+	}
+	return keys, nil
+}
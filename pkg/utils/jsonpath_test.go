@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ExampleJSONPath() {
+	var data any
+	_ = json.Unmarshal([]byte(`{"data": {"items": [{"name": "a"}, {"name": "b"}]}}`), &data)
+	val, err := JSONPath(data, "$.data.items[1].name")
+	fmt.Println(val, err)
+	// Output:
+	// b <nil>
+}
+
+func TestJSONPath(t *testing.T) {
+	var data any
+	err := json.Unmarshal([]byte(`{
+		"ok": true,
+		"count": 3,
+		"data": {"items": [{"name": "a"}, {"name": "b"}], "display name": "x"}
+	}`), &data)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    any
+		wantErr bool
+	}{
+		{name: "root", path: "$", want: data},
+		{name: "bool", path: "$.ok", want: true},
+		{name: "number", path: "count", want: float64(3)},
+		{name: "nested index", path: "$.data.items[0].name", want: "a"},
+		{name: "negative index", path: "$.data.items[-1].name", want: "b"},
+		{name: "bracket key", path: "$.data['display name']", want: "x"},
+		{name: "missing key", path: "$.missing", wantErr: true},
+		{name: "index out of range", path: "$.data.items[5]", wantErr: true},
+		{name: "index on object", path: "$.data[0]", wantErr: true},
+		{name: "invalid path", path: "$.data[0", wantErr: true},
+		{name: "empty key", path: "$..data", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := JSONPath(data, tt.path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,20 +1,76 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
+	"reflect"
 	"regexp"
 	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
 )
 
 // VariablePattern is the regex pattern for matching variables in the template
 var VariablePattern = regexp.MustCompile(`{{\s*([a-zA-Z0-9_-]+)\s*}}`)
 
+// PathVariablePattern is the regex pattern for matching variables with dotted names, e.g. `{{ login.token }}`
+var PathVariablePattern = regexp.MustCompile(`{{\s*([a-zA-Z0-9_-]+(?:\.[a-zA-Z0-9_-]+)*)\s*}}`)
+
+// Engine is the template engine
+type Engine string
+
+const (
+	// EngineSimple only substitutes `{{ name }}` variables, see SimpleRender
+	EngineSimple Engine = "simple"
+	// EngineGo is the go text/template engine with TemplateFuncs, see GoRender
+	EngineGo Engine = "go"
+)
+
+// ParseEngine parses the engine name, empty means EngineSimple
+func ParseEngine(engine string) (Engine, error) {
+	switch e := Engine(strings.ToLower(strings.TrimSpace(engine))); e {
+	case "", EngineSimple:
+		return EngineSimple, nil
+	case EngineGo:
+		return EngineGo, nil
+	default:
+		return "", fmt.Errorf("template engine %s is invalid", engine)
+	}
+}
+
+// Render renders the template with the given engine and variables
+func Render(engine Engine, tmpl string, vars map[string]string) (string, error) {
+	if engine == EngineGo {
+		data := make(map[string]any, len(vars))
+		for k, v := range vars {
+			data[k] = v
+		}
+		return GoRender(tmpl, data)
+	}
+	return SimpleRender(tmpl, vars)
+}
+
 // SimpleRender renders the template with the given variables and defaults
 func SimpleRender(tmpl string, vars map[string]string) (string, error) {
+	return simpleRender(VariablePattern, tmpl, vars)
+}
+
+// SimpleRenderPaths renders the template like SimpleRender, the variable names may be dotted paths
+func SimpleRenderPaths(tmpl string, vars map[string]string) (string, error) {
+	return simpleRender(PathVariablePattern, tmpl, vars)
+}
+
+func simpleRender(pattern *regexp.Regexp, tmpl string, vars map[string]string) (string, error) {
 	result := tmpl
 	notFound := []string{}
-	result = VariablePattern.ReplaceAllStringFunc(result, func(match string) string {
-		varName := VariablePattern.FindStringSubmatch(match)[1]
+	result = pattern.ReplaceAllStringFunc(result, func(match string) string {
+		varName := pattern.FindStringSubmatch(match)[1]
 		if value, exists := vars[varName]; exists {
 			return value
 		}
@@ -28,3 +84,187 @@ func SimpleRender(tmpl string, vars map[string]string) (string, error) {
 	}
 	return result, nil
 }
+
+// TemplateFuncs are the functions available in GoRender templates, in addition
+// to the text/template builtins such as `urlquery`, `html`, `printf`, `eq`:
+//
+//	{{ .lang | default "en" }}    fallback when the value is empty
+//	{{ .name | json }}            JSON encoded value, e.g. "a \"quoted\" name"
+//	{{ .path | urlpath }}         path segment escaping
+//	{{ .token | base64 }}         standard base64 encoding
+//	{{ .city | upper }}           upper case, also lower and trim
+//	{{ now | date "2006-01-02" }} current time, formatted
+//	{{ uuid }}                    a new UUIDv7
+//	{{ query "q" .q "page" .page }}         URL query of the non-empty values, e.g. page=2&q=go
+//	{{ object "name" .name "tags" (rawjson .tags) | json }}  object of the non-empty values
+//	{{ .tags | rawjson }}         the value as raw JSON if it is a valid JSON text
+var TemplateFuncs = template.FuncMap{
+	"default": defaultValue,
+	"json":    toJSON,
+	"urlpath": func(v any) string { return url.PathEscape(fmt.Sprint(v)) },
+	"base64":  func(v any) string { return base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(v))) },
+	"upper":   func(v any) string { return strings.ToUpper(fmt.Sprint(v)) },
+	"lower":   func(v any) string { return strings.ToLower(fmt.Sprint(v)) },
+	"trim":    func(v any) string { return strings.TrimSpace(fmt.Sprint(v)) },
+	"now":     time.Now,
+	"date":    func(layout string, t time.Time) string { return t.Format(layout) },
+	"uuid":    func() string { return UUIDv7().String() },
+	"query":   buildQuery,
+	"object":  buildObject,
+	"rawjson": rawJSON,
+}
+
+// ParseGoTemplate parses the go template with TemplateFuncs
+func ParseGoTemplate(tmpl string) (*template.Template, error) {
+	return template.New("render").Funcs(TemplateFuncs).Option("missingkey=error").Parse(tmpl)
+}
+
+// GoRender renders the go template with TemplateFuncs and the given variables.
+// Variables are accessed with a leading dot, e.g. `{{ .name }}`.
+func GoRender(tmpl string, vars map[string]any) (string, error) {
+	t, err := ParseGoTemplate(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// GoTemplateVars returns the sorted names of the variables the go template references,
+// e.g. `lang` of `{{ .lang | default "en" }}`, so they can be declared before rendering.
+func GoTemplateVars(tmpl string) ([]string, error) {
+	t, err := ParseGoTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]struct{}{}
+	if t.Tree != nil {
+		collectFields(t.Root, names)
+	}
+	vars := make([]string, 0, len(names))
+	for name := range names {
+		vars = append(vars, name)
+	}
+	slices.Sort(vars)
+	return vars, nil
+}
+
+func collectFields(node parse.Node, names map[string]struct{}) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectFields(child, names)
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, names)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectFields(cmd, names)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectFields(arg, names)
+		}
+	case *parse.ChainNode:
+		collectFields(n.Node, names)
+	case *parse.FieldNode:
+		names[n.Ident[0]] = struct{}{}
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, names)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, names)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, names)
+	case *parse.TemplateNode:
+		collectFields(n.Pipe, names)
+	}
+}
+
+func collectBranch(n *parse.BranchNode, names map[string]struct{}) {
+	collectFields(n.Pipe, names)
+	collectFields(n.List, names)
+	collectFields(n.ElseList, names)
+}
+
+func defaultValue(def any, val any) any {
+	if val == nil {
+		return def
+	}
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if rv.Len() == 0 {
+			return def
+		}
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return def
+		}
+	}
+	return val
+}
+
+func isEmptyValue(val any) bool {
+	return defaultValue(nil, val) == nil
+}
+
+func buildQuery(pairs ...any) (string, error) {
+	if len(pairs)%2 != 0 {
+		return "", errors.New("query requires key and value pairs")
+	}
+	query := url.Values{}
+	for i := 0; i < len(pairs); i += 2 {
+		key := fmt.Sprint(pairs[i])
+		val := pairs[i+1]
+		if isEmptyValue(val) {
+			continue
+		}
+		if rv := reflect.ValueOf(val); rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+			for j := range rv.Len() {
+				query.Add(key, fmt.Sprint(rv.Index(j).Interface()))
+			}
+			continue
+		}
+		query.Add(key, fmt.Sprint(val))
+	}
+	return query.Encode(), nil
+}
+
+func buildObject(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("object requires key and value pairs")
+	}
+	obj := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		if !isEmptyValue(pairs[i+1]) {
+			obj[fmt.Sprint(pairs[i])] = pairs[i+1]
+		}
+	}
+	return obj, nil
+}
+
+func rawJSON(v any) any {
+	if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	return v
+}
+
+func toJSON(v any) (string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -123,3 +123,188 @@ func TestRender_JSON(t *testing.T) {
 		})
 	}
 }
+
+func ExampleGoRender() {
+	tmpl := `{"name": {{ .name | json }}, "q": "{{ .q | urlquery }}", "lang": "{{ .lang | default "en" | upper }}"}`
+	vars := map[string]any{"name": `John "Johnny" Doe`, "q": "a b&c", "lang": ""}
+	got, err := GoRender(tmpl, vars)
+	fmt.Println(got, err)
+	// Output:
+	// {"name": "John \"Johnny\" Doe", "q": "a+b%26c", "lang": "EN"} <nil>
+}
+
+func TestSimpleRenderPaths(t *testing.T) {
+	vars := map[string]string{"login.token": "abc", "user": "john"}
+
+	// the dotted names are left as they are by SimpleRender
+	got, err := SimpleRender("{{ user }} {{ login.token }}", vars)
+	assert.NoError(t, err)
+	assert.Equal(t, "john {{ login.token }}", got)
+
+	got, err = SimpleRenderPaths("{{ user }} {{ login.token }}", vars)
+	assert.NoError(t, err)
+	assert.Equal(t, "john abc", got)
+
+	_, err = SimpleRenderPaths("{{ login.id }}", vars)
+	assert.EqualError(t, err, "not found: [login.id]")
+}
+
+func TestParseEngine(t *testing.T) {
+	tests := []struct {
+		engine  string
+		want    Engine
+		wantErr bool
+	}{
+		{engine: "", want: EngineSimple},
+		{engine: "simple", want: EngineSimple},
+		{engine: " Go ", want: EngineGo},
+		{engine: "jinja", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.engine, func(t *testing.T) {
+			got, err := ParseEngine(tt.engine)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGoRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		vars    map[string]any
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "basic substitution",
+			tmpl: "Hello {{ .name }}!",
+			vars: map[string]any{"name": "World"},
+			want: "Hello World!",
+		},
+		{
+			name: "url path and base64",
+			tmpl: "/users/{{ .user | urlpath }}?auth={{ .token | base64 }}",
+			vars: map[string]any{"user": "a/b c", "token": "u:p"},
+			want: "/users/a%2Fb%20c?auth=dTpw",
+		},
+		{
+			name: "default with value",
+			tmpl: `{{ .lang | default "en" | lower }}`,
+			vars: map[string]any{"lang": "ZH"},
+			want: "zh",
+		},
+		{
+			name: "trim",
+			tmpl: `[{{ .name | trim }}]`,
+			vars: map[string]any{"name": "  x  "},
+			want: "[x]",
+		},
+		{
+			name: "query skips empty values",
+			tmpl: `/search{{ with query "q" .q "page" .page "tag" .tags "sort" .sort }}?{{ . }}{{ end }}`,
+			vars: map[string]any{"q": "a b", "page": int64(2), "tags": []string{"x", "y"}, "sort": ""},
+			want: "/search?page=2&q=a+b&tag=x&tag=y",
+		},
+		{
+			name: "query all empty",
+			tmpl: `/search{{ with query "q" .q }}?{{ . }}{{ end }}`,
+			vars: map[string]any{"q": ""},
+			want: "/search",
+		},
+		{
+			name:    "query odd pairs",
+			tmpl:    `{{ query "q" }}`,
+			wantErr: true,
+		},
+		{
+			name: "object with raw json",
+			tmpl: `{{ object "name" .name "age" .age "tags" (rawjson .tags) "note" .note | json }}`,
+			vars: map[string]any{"name": "bob", "age": int64(3), "tags": `["a"]`, "note": ""},
+			want: `{"age":3,"name":"bob","tags":["a"]}`,
+		},
+		{
+			name: "rawjson invalid text",
+			tmpl: `{{ .v | rawjson | json }}`,
+			vars: map[string]any{"v": "not json"},
+			want: `"not json"`,
+		},
+		{
+			name: "if block",
+			tmpl: `{{ if .debug }}debug{{ else }}release{{ end }}`,
+			vars: map[string]any{"debug": ""},
+			want: "release",
+		},
+		{
+			name: "range block",
+			tmpl: `{{ range $i, $v := .items }}{{ if $i }},{{ end }}{{ $v | json }}{{ end }}`,
+			vars: map[string]any{"items": []string{"a", "b"}},
+			want: `"a","b"`,
+		},
+		{
+			name:    "missing variable",
+			tmpl:    "Hello {{ .name }}!",
+			vars:    map[string]any{},
+			wantErr: true,
+		},
+		{
+			name:    "syntax error",
+			tmpl:    "Hello {{ .name ",
+			vars:    map[string]any{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GoRender(tt.tmpl, tt.vars)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGoRender_Helpers(t *testing.T) {
+	got, err := GoRender(`{{ uuid }}`, nil)
+	assert.NoError(t, err)
+	var id UUID
+	assert.NoError(t, id.FromString(got))
+
+	got, err = GoRender(`{{ now | date "2006" }}`, nil)
+	assert.NoError(t, err)
+	assert.Len(t, got, 4)
+}
+
+func TestGoTemplateVars(t *testing.T) {
+	got, err := GoTemplateVars(`{{ .lang | default "en" }}/{{ if .debug }}{{ .login.token }}{{ end }}{{ range .items }}{{ . }}{{ end }}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"debug", "items", "lang", "login"}, got)
+
+	got, err = GoTemplateVars("plain")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	_, err = GoTemplateVars("{{ .name ")
+	assert.Error(t, err)
+}
+
+func TestRenderEngine(t *testing.T) {
+	vars := map[string]string{"name": "World"}
+	got, err := Render(EngineSimple, "Hello {{ name }}!", vars)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World!", got)
+
+	got, err = Render(EngineGo, "Hello {{ .name | upper }}!", vars)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello WORLD!", got)
+}